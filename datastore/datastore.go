@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"context"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -10,7 +11,19 @@ type MongoDB interface {
 	Save(ctx context.Context, collectionName string, dto interface{}) error
 	SaveMany(ctx context.Context, collectionName string, dtos []interface{}) error
 	Update(ctx context.Context, collectionName string, filter, dto interface{}) error
+	Replace(ctx context.Context, collectionName string, filter, dto interface{}, upsert bool) (int64, error)
+	Upsert(ctx context.Context, collectionName string, filter, dto interface{}) error
+	UpdateMatched(ctx context.Context, collectionName string, filter, dto interface{}) (int64, error)
 	GetByFilter(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, dto interface{}) ([]byte, error)
-	Delete(ctx context.Context, collectionName string, filter interface{}) error
+	Iterate(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, dto interface{}, fn func(doc interface{}) error) error
+	GetAll(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, results interface{}) error
+	Aggregate(ctx context.Context, collectionName string, pipeline interface{}, results interface{}) error
+	FindOneAndUpdate(ctx context.Context, collectionName string, filter, update, sort interface{}, dto interface{}) error
+	Delete(ctx context.Context, collectionName string, filter interface{}) (int64, error)
 	DeleteMany(ctx context.Context, collectionName string, filter interface{}) error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	EnsureExpiry(ctx context.Context, collectionName string, field string) error
+	EnsureUnique(ctx context.Context, collectionName string, keys interface{}, partial interface{}) error
+	EnsureIndex(ctx context.Context, collectionName string, keys interface{}) error
+	Watch(ctx context.Context, collectionName string, pipeline interface{}) (*mongo.ChangeStream, error)
 }
\ No newline at end of file
@@ -0,0 +1,610 @@
+// Package datastoretest provides an in-memory datastore for tests.
+package datastoretest
+
+import (
+	"awesomeTestProject/datastore"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is a datastore keeping the documents in memory, for tests. It
+// understands the filters and updates the services use: equality, $in, $ne,
+// $lt, $lte, $gt, $gte, $exists, $or and $expr comparing two fields, and $set,
+// $unset, $inc, $push and $setOnInsert. Like mongo it keeps _id unique. FindOneAndUpdate
+// sorts, other find options are ignored and documents come back in the order
+// they were saved. A failing transaction undoes every change made while it
+// ran, transactions are not isolated from each other. Operations it does not
+// implement panic.
+type Store struct {
+	datastore.MongoDB
+
+	mu          sync.Mutex
+	collections map[string][]bson.D
+	unique      map[string][]uniqueIndex
+	failures    map[string][]error
+}
+
+type uniqueIndex struct {
+	keys    []string
+	partial bson.D
+}
+
+// Use makes the datastore a new Store for the test.
+func Use(t *testing.T) *Store {
+	store := &Store{
+		collections: map[string][]bson.D{},
+		unique:      map[string][]uniqueIndex{},
+		failures:    map[string][]error{},
+	}
+	datastore.SetDatastore(store)
+	t.Cleanup(func() { datastore.SetDatastore(nil) })
+	return store
+}
+
+// Fail makes the next calls of the operation, a method name, on the
+// collection fail with errs in turn, a nil error lets the call through.
+func (m *Store) Fail(operation, collectionName string, errs ...error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[operation+" "+collectionName] = errs
+}
+
+func (m *Store) failure(operation, collectionName string) error {
+	key := operation + " " + collectionName
+	errs := m.failures[key]
+	if len(errs) == 0 {
+		return nil
+	}
+	m.failures[key] = errs[1:]
+	return errs[0]
+}
+
+// All decodes every document of the collection into results, a pointer to a
+// slice.
+func (m *Store) All(collectionName string, results interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	decodeAll(m.collections[collectionName], results)
+}
+
+func (m *Store) Save(ctx context.Context, collectionName string, dto interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Save", collectionName); err != nil {
+		return err
+	}
+	doc := toDocument(dto)
+	if err := m.checkUnique(collectionName, doc); err != nil {
+		return err
+	}
+	m.collections[collectionName] = append(m.collections[collectionName], doc)
+	return nil
+}
+
+// SaveMany inserts the documents unordered like mongo, a duplicate is
+// reported by its index in a mongo.BulkWriteException.
+func (m *Store) SaveMany(ctx context.Context, collectionName string, dtos []interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Save", collectionName); err != nil {
+		return err
+	}
+	var failed []mongo.BulkWriteError
+	for i, dto := range dtos {
+		doc := toDocument(dto)
+		var duplicate mongo.WriteException
+		if errors.As(m.checkUnique(collectionName, doc), &duplicate) {
+			we := duplicate.WriteErrors[0]
+			we.Index = i
+			failed = append(failed, mongo.BulkWriteError{WriteError: we})
+			continue
+		}
+		m.collections[collectionName] = append(m.collections[collectionName], doc)
+	}
+	if len(failed) > 0 {
+		return mongo.BulkWriteException{WriteErrors: failed}
+	}
+	return nil
+}
+
+func (m *Store) GetById(ctx context.Context, collectionName string, filter interface{}, dto interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("GetById", collectionName); err != nil {
+		return err
+	}
+	i := m.find(collectionName, toDocument(filter))
+	if i < 0 {
+		return &datastore.NotFoundError{Collection: collectionName}
+	}
+	decode(m.collections[collectionName][i], dto)
+	return nil
+}
+
+func (m *Store) GetAll(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, results interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("GetAll", collectionName); err != nil {
+		return err
+	}
+	f := toDocument(filter)
+	var matching []bson.D
+	for _, doc := range m.collections[collectionName] {
+		if matches(doc, f) {
+			matching = append(matching, doc)
+		}
+	}
+	decodeAll(matching, results)
+	return nil
+}
+
+func (m *Store) Update(ctx context.Context, collectionName string, filter, dto interface{}) error {
+	_, err := m.UpdateMatched(ctx, collectionName, filter, dto)
+	return err
+}
+
+func (m *Store) UpdateMatched(ctx context.Context, collectionName string, filter, dto interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Update", collectionName); err != nil {
+		return 0, err
+	}
+	i := m.find(collectionName, toDocument(filter))
+	if i < 0 {
+		return 0, nil
+	}
+	m.collections[collectionName][i] = apply(m.collections[collectionName][i], toDocument(dto))
+	return 1, nil
+}
+
+// Upsert inserts the equalities of the filter when no document matches,
+// before applying the update. $setOnInsert is applied to inserted documents
+// only.
+func (m *Store) Upsert(ctx context.Context, collectionName string, filter, dto interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Update", collectionName); err != nil {
+		return err
+	}
+	f := toDocument(filter)
+	update := bson.D{}
+	var onInsert bson.D
+	for _, op := range toDocument(dto) {
+		if op.Key == "$setOnInsert" {
+			onInsert = bson.D{{Key: "$set", Value: op.Value}}
+			continue
+		}
+		update = append(update, op)
+	}
+	i := m.find(collectionName, f)
+	if i < 0 {
+		update = append(update, onInsert...)
+		doc := bson.D{}
+		for _, e := range f {
+			if _, operators := e.Value.(bson.D); !operators && !strings.HasPrefix(e.Key, "$") {
+				doc = append(doc, e)
+			}
+		}
+		m.collections[collectionName] = append(m.collections[collectionName], doc)
+		i = len(m.collections[collectionName]) - 1
+	}
+	m.collections[collectionName][i] = apply(m.collections[collectionName][i], update)
+	return nil
+}
+
+func (m *Store) FindOneAndUpdate(ctx context.Context, collectionName string, filter, update, sort interface{}, dto interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Update", collectionName); err != nil {
+		return err
+	}
+	f := toDocument(filter)
+	var order bson.D
+	if sort != nil {
+		order = toDocument(sort)
+	}
+	i := -1
+	for j, doc := range m.collections[collectionName] {
+		if matches(doc, f) && (i < 0 || before(doc, m.collections[collectionName][i], order)) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return &datastore.NotFoundError{Collection: collectionName}
+	}
+	m.collections[collectionName][i] = apply(m.collections[collectionName][i], toDocument(update))
+	decode(m.collections[collectionName][i], dto)
+	return nil
+}
+
+// before tells whether doc comes before other in the sort order.
+func before(doc, other bson.D, order bson.D) bool {
+	for _, e := range order {
+		a, _ := lookup(doc, e.Key)
+		b, _ := lookup(other, e.Key)
+		c := compare(a, b)
+		if c == 0 || c == 2 {
+			continue
+		}
+		if direction, _ := number(e.Value); direction < 0 {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+func (m *Store) Delete(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.failure("Delete", collectionName); err != nil {
+		return 0, err
+	}
+	i := m.find(collectionName, toDocument(filter))
+	if i < 0 {
+		return 0, nil
+	}
+	docs := m.collections[collectionName]
+	m.collections[collectionName] = append(docs[:i:i], docs[i+1:]...)
+	return 1, nil
+}
+
+func (m *Store) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.mu.Lock()
+	snapshot := make(map[string][]bson.D, len(m.collections))
+	for name, docs := range m.collections {
+		for _, doc := range docs {
+			// updates change documents in place
+			snapshot[name] = append(snapshot[name], toDocument(doc))
+		}
+	}
+	m.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		m.mu.Lock()
+		m.collections = snapshot
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *Store) EnsureExpiry(ctx context.Context, collectionName string, field string) error {
+	return nil
+}
+
+func (m *Store) EnsureUnique(ctx context.Context, collectionName string, keys interface{}, partial interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := uniqueIndex{}
+	for _, e := range toDocument(keys) {
+		index.keys = append(index.keys, e.Key)
+	}
+	if partial != nil {
+		index.partial = toDocument(partial)
+	}
+	m.unique[collectionName] = append(m.unique[collectionName], index)
+	return nil
+}
+
+func (m *Store) EnsureIndex(ctx context.Context, collectionName string, keys interface{}) error {
+	return nil
+}
+
+func (m *Store) find(collectionName string, filter bson.D) int {
+	for i, doc := range m.collections[collectionName] {
+		if matches(doc, filter) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Store) checkUnique(collectionName string, doc bson.D) error {
+	indexes := m.unique[collectionName]
+	if _, ok := lookup(doc, "_id"); ok {
+		indexes = append([]uniqueIndex{{keys: []string{"_id"}}}, indexes...)
+	}
+	for _, index := range indexes {
+		if index.partial != nil && !matches(doc, index.partial) {
+			continue
+		}
+		for _, other := range m.collections[collectionName] {
+			if index.partial != nil && !matches(other, index.partial) {
+				continue
+			}
+			same := true
+			for _, key := range index.keys {
+				a, _ := lookup(doc, key)
+				b, _ := lookup(other, key)
+				if compare(a, b) != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+					Code:    11000,
+					Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s", collectionName, strings.Join(index.keys, "_")),
+				}}}
+			}
+		}
+	}
+	return nil
+}
+
+// toDocument turns a filter, update or dto into a bson.D holding the values
+// as mongo would store them.
+func toDocument(v interface{}) bson.D {
+	if v == nil {
+		return bson.D{}
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func decode(doc bson.D, dto interface{}) {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	if err := bson.Unmarshal(b, dto); err != nil {
+		panic(err)
+	}
+}
+
+func decodeAll(docs []bson.D, results interface{}) {
+	slice := reflect.ValueOf(results).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(docs)))
+	for _, doc := range docs {
+		item := reflect.New(slice.Type().Elem())
+		decode(doc, item.Interface())
+		slice.Set(reflect.Append(slice, item.Elem()))
+	}
+}
+
+func lookup(doc bson.D, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		d, ok := current.(bson.D)
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for _, e := range d {
+			if e.Key == key {
+				current, found = e.Value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func matches(doc bson.D, filter bson.D) bool {
+	for _, e := range filter {
+		if e.Key == "$expr" {
+			if !expression(doc, e.Value.(bson.D)) {
+				return false
+			}
+			continue
+		}
+		if e.Key == "$or" {
+			matched := false
+			for _, alternative := range e.Value.(bson.A) {
+				if matches(doc, alternative.(bson.D)) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		value, exists := lookup(doc, e.Key)
+		operators, ok := e.Value.(bson.D)
+		if !ok || len(operators) == 0 || !strings.HasPrefix(operators[0].Key, "$") {
+			if !equals(value, e.Value) {
+				return false
+			}
+			continue
+		}
+		for _, op := range operators {
+			if !matchesOperator(value, exists, op) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// expression evaluates a comparison of $expr, its operands are "$field"
+// references or values.
+func expression(doc bson.D, expr bson.D) bool {
+	for _, op := range expr {
+		operands := op.Value.(bson.A)
+		values := make([]interface{}, len(operands))
+		for i, operand := range operands {
+			values[i] = operand
+			if path, ok := operand.(string); ok && strings.HasPrefix(path, "$") {
+				values[i], _ = lookup(doc, path[1:])
+			}
+		}
+		if !matchesOperator(values[0], values[0] != nil, bson.E{Key: op.Key, Value: values[1]}) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesOperator(value interface{}, exists bool, op bson.E) bool {
+	switch op.Key {
+	case "$in":
+		for _, v := range op.Value.(bson.A) {
+			if equals(value, v) {
+				return true
+			}
+		}
+		return false
+	case "$ne":
+		return !equals(value, op.Value)
+	case "$exists":
+		return exists == op.Value.(bool)
+	case "$lt":
+		return exists && compare(value, op.Value) < 0
+	case "$lte":
+		return exists && compare(value, op.Value) <= 0
+	case "$gt":
+		return exists && compare(value, op.Value) > 0
+	case "$gte":
+		return exists && compare(value, op.Value) >= 0
+	}
+	panic("datastoretest does not support " + op.Key)
+}
+
+// equals matches a value like mongo does, an array matches any of its
+// elements.
+func equals(value, expected interface{}) bool {
+	if values, ok := value.(bson.A); ok {
+		if _, ok := expected.(bson.A); !ok {
+			for _, v := range values {
+				if compare(v, expected) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return compare(value, expected) == 0
+}
+
+func compare(a, b interface{}) int {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := a.(primitive.DateTime); ok {
+		if y, ok := b.(primitive.DateTime); ok {
+			return compare(int64(x), int64(y))
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return 2
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func apply(doc bson.D, update bson.D) bson.D {
+	for _, op := range update {
+		for _, e := range op.Value.(bson.D) {
+			current, _ := lookup(doc, e.Key)
+			switch op.Key {
+			case "$set":
+				doc = setPath(doc, e.Key, e.Value)
+			case "$unset":
+				doc = unsetPath(doc, e.Key)
+			case "$inc":
+				x, _ := number(current)
+				y, _ := number(e.Value)
+				if _, ok := current.(float64); ok {
+					doc = setPath(doc, e.Key, x+y)
+				} else {
+					doc = setPath(doc, e.Key, int64(x+y))
+				}
+			case "$push":
+				values, _ := current.(bson.A)
+				doc = setPath(doc, e.Key, append(append(bson.A{}, values...), e.Value))
+			default:
+				panic("datastoretest does not support " + op.Key)
+			}
+		}
+	}
+	return doc
+}
+
+func setPath(doc bson.D, path string, value interface{}) bson.D {
+	key, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		key, rest = path[:i], path[i+1:]
+	}
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if rest == "" {
+			doc[i].Value = value
+		} else {
+			nested, _ := e.Value.(bson.D)
+			doc[i].Value = setPath(nested, rest, value)
+		}
+		return doc
+	}
+	if rest == "" {
+		return append(doc, bson.E{Key: key, Value: value})
+	}
+	return append(doc, bson.E{Key: key, Value: setPath(bson.D{}, rest, value)})
+}
+
+func unsetPath(doc bson.D, path string) bson.D {
+	key, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		key, rest = path[:i], path[i+1:]
+	}
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if rest == "" {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		nested, _ := e.Value.(bson.D)
+		doc[i].Value = unsetPath(nested, rest)
+		return doc
+	}
+	return doc
+}
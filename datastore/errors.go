@@ -0,0 +1,21 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NotFoundError is returned when no document matched the filter of an
+// operation that expects one.
+type NotFoundError struct {
+	Collection string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("No document found in %s", e.Collection)
+}
+
+func IsNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
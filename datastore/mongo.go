@@ -3,6 +3,7 @@ package datastore
 import (
 	"context"
 	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
@@ -10,7 +11,9 @@ import (
 )
 
 var (
-	Db               *MongoDatabase
+	Db *MongoDatabase
+	// store stands in for Db, see SetDatastore
+	store MongoDB
 )
 
 type MongoDatabase struct {
@@ -21,9 +24,18 @@ type MongoDatabase struct {
 }
 
 func GetDatastore() MongoDB {
+	if store != nil {
+		return store
+	}
 	return Db
 }
 
+// SetDatastore makes GetDatastore return m instead of Db, so tests can run the
+// services against an in memory datastore. nil goes back to Db.
+func SetDatastore(m MongoDB) {
+	store = m
+}
+
 func InitialiseAndConnectToMongo(url, username, password, dbName string) *MongoDatabase {
 	var md MongoDatabase
 	md = md.Bootstrap(url, username, password, dbName).(MongoDatabase)
@@ -71,13 +83,44 @@ func (m MongoDatabase) Update(ctx context.Context, collectionName string, filter
 	return err
 }
 
+// Replace swaps the whole document matching the filter for dto and reports how
+// many documents matched. With upsert dto is inserted when nothing matched.
+func (m MongoDatabase) Replace(ctx context.Context, collectionName string, filter, dto interface{}, upsert bool) (int64, error) {
+	res, err := m.Client.Database(m.Name).Collection(collectionName).ReplaceOne(ctx, filter, dto, options.Replace().SetUpsert(upsert))
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+func (m MongoDatabase) Upsert(ctx context.Context, collectionName string, filter, dto interface{}) error {
+	_, err := m.Client.Database(m.Name).Collection(collectionName).UpdateOne(ctx, filter, dto, options.Update().SetUpsert(true))
+	return err
+}
+
+// UpdateMatched behaves like Update but reports how many documents matched the
+// filter, so callers can use the filter as a guard for conditional updates.
+func (m MongoDatabase) UpdateMatched(ctx context.Context, collectionName string, filter, dto interface{}) (int64, error) {
+	res, err := m.Client.Database(m.Name).Collection(collectionName).UpdateOne(ctx, filter, dto)
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+// SaveMany inserts the documents unordered, so one failing document does not
+// stop the others. The returned error is a mongo.BulkWriteException carrying
+// the index of every document that failed.
 func (m MongoDatabase) SaveMany(ctx context.Context, collectionName string, dtos []interface{}) error {
-	_, err := m.Client.Database(m.Name).Collection(collectionName).InsertMany(ctx, dtos)
+	_, err := m.Client.Database(m.Name).Collection(collectionName).InsertMany(ctx, dtos, options.InsertMany().SetOrdered(false))
 	return err
 }
 
 func (m MongoDatabase) GetById(ctx context.Context, collectionName string, filter interface{}, dto interface{}) error {
 	err := m.Client.Database(m.Name).Collection(collectionName).FindOne(ctx, filter).Decode(dto)
+	if err == mongo.ErrNoDocuments {
+		return &NotFoundError{collectionName}
+	}
 	return err
 }
 
@@ -104,12 +147,136 @@ func (m MongoDatabase) GetByFilter(ctx context.Context, collectionName string, f
 	return json.Marshal(results)
 }
 
-func (m MongoDatabase) Delete(ctx context.Context, collectionName string, filter interface{}) error {
-	_, err := m.Client.Database(m.Name).Collection(collectionName).DeleteOne(ctx, filter)
+// Iterate decodes the matching documents one at a time into a new value of
+// the type dto points to and hands each to fn, so the result never has to fit
+// in memory. Iteration stops at the first error returned by fn.
+func (m MongoDatabase) Iterate(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, dto interface{}, fn func(doc interface{}) error) error {
+	objectType := reflect.TypeOf(dto).Elem()
+
+	cur, err := m.Client.Database(m.Name).Collection(collectionName).Find(ctx, filter, opt)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		result := reflect.New(objectType).Interface()
+		if err := cur.Decode(result); err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// GetAll decodes every document matching the filter into results, which must
+// be a pointer to a slice.
+func (m MongoDatabase) GetAll(ctx context.Context, collectionName string, filter interface{}, opt *options.FindOptions, results interface{}) error {
+	cur, err := m.Client.Database(m.Name).Collection(collectionName).Find(ctx, filter, opt)
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, results)
+}
+
+// Aggregate runs the pipeline and decodes every resulting document into
+// results, which must be a pointer to a slice.
+func (m MongoDatabase) Aggregate(ctx context.Context, collectionName string, pipeline interface{}, results interface{}) error {
+	cur, err := m.Client.Database(m.Name).Collection(collectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, results)
+}
+
+// FindOneAndUpdate updates the first document matching the filter in sort
+// order and decodes it into dto as it is after the update. Matching and
+// updating is atomic, so two callers never get the same document for a
+// filter the update stops matching.
+func (m MongoDatabase) FindOneAndUpdate(ctx context.Context, collectionName string, filter, update, sort interface{}, dto interface{}) error {
+	opts := options.FindOneAndUpdate().SetSort(sort).SetReturnDocument(options.After)
+	err := m.Client.Database(m.Name).Collection(collectionName).FindOneAndUpdate(ctx, filter, update, opts).Decode(dto)
+	if err == mongo.ErrNoDocuments {
+		return &NotFoundError{collectionName}
+	}
 	return err
 }
 
+// Delete removes the first document matching the filter and reports how many
+// documents were deleted.
+func (m MongoDatabase) Delete(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
+	res, err := m.Client.Database(m.Name).Collection(collectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
 func (m MongoDatabase) DeleteMany(ctx context.Context, collectionName string, filter interface{}) error {
 	_, err := m.Client.Database(m.Name).Collection(collectionName).DeleteMany(ctx, filter)
 	return err
 }
+
+// WithTransaction runs fn inside a multi document transaction. The context
+// handed to fn carries the session, datastore calls made with it take part in
+// the transaction which is committed when fn returns nil and aborted otherwise.
+// Called with the context of a running transaction fn joins it.
+func (m MongoDatabase) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// EnsureExpiry creates a TTL index on field, so mongo removes each document
+// once the time stored in field has passed. Creating an existing index is a
+// no-op.
+func (m MongoDatabase) EnsureExpiry(ctx context.Context, collectionName string, field string) error {
+	_, err := m.Client.Database(m.Name).Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// EnsureUnique creates a unique index on keys, so mongo refuses a second
+// document with the same values with a duplicate key error. With a partial
+// filter only the documents matching it have to be unique. Creating an
+// existing index is a no-op.
+func (m MongoDatabase) EnsureUnique(ctx context.Context, collectionName string, keys interface{}, partial interface{}) error {
+	opt := options.Index().SetUnique(true)
+	if partial != nil {
+		opt.SetPartialFilterExpression(partial)
+	}
+	_, err := m.Client.Database(m.Name).Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: opt,
+	})
+	return err
+}
+
+// EnsureIndex creates an index on keys for the queries filtering or sorting
+// on them. Creating an existing index is a no-op.
+func (m MongoDatabase) EnsureIndex(ctx context.Context, collectionName string, keys interface{}) error {
+	_, err := m.Client.Database(m.Name).Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+	return err
+}
+
+// Watch opens a change stream on the collection, filtered by the aggregation
+// pipeline. Change streams need a replica set or a sharded cluster, opening
+// one on a standalone server fails.
+func (m MongoDatabase) Watch(ctx context.Context, collectionName string, pipeline interface{}) (*mongo.ChangeStream, error) {
+	return m.Client.Database(m.Name).Collection(collectionName).Watch(ctx, pipeline)
+}
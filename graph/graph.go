@@ -0,0 +1,91 @@
+package graph
+
+import (
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// ParseRequest reads a GraphQL request from the query string of a GET or the
+// body of a POST. A GET may only run a query, so links and prefetching
+// browsers cannot change data.
+func ParseRequest(ctx context.Context, req HttpWebRequest) (*Request, error) {
+	var request Request
+
+	if req.Method() == http.MethodGet {
+		request.Query = req.Param("query")
+		request.OperationName = req.Param("operationName")
+		if v := req.Param("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &request.Variables); err != nil {
+				return nil, Error.InvalidParam("variables", "a JSON object", v)
+			}
+		}
+	} else {
+		err := req.Decode(&request)
+		if err != nil {
+			Fatal(ctx, "Unable to deserialize the request body")
+			return nil, err
+		}
+	}
+
+	if request.Query == "" {
+		return nil, Error.MissingRequiredProperty("query")
+	}
+	if req.Method() == http.MethodGet {
+		// syntax errors are left to Execute to report
+		doc, err := parser.Parse(parser.ParseParams{Source: request.Query})
+		if err == nil {
+			if operation := operationOf(doc, request.OperationName); operation != nil && operation.Operation != ast.OperationTypeQuery {
+				return nil, Error.InvalidParam("query", "a query, "+operation.Operation+"s need a POST", operation.Operation)
+			}
+		}
+	}
+	return &request, nil
+}
+
+// operationOf is the operation of the document the request runs, the one
+// named operationName or the first without one.
+func operationOf(doc *ast.Document, operationName string) *ast.OperationDefinition {
+	for _, d := range doc.Definitions {
+		if d, ok := d.(*ast.OperationDefinition); ok && (operationName == "" || d.Name != nil && d.Name.Value == operationName) {
+			return d
+		}
+	}
+	return nil
+}
+
+// Execute runs the request against the student schema. Queries deeper than
+// graphql-max-depth or costlier than graphql-max-complexity are refused
+// without touching the datastore.
+func Execute(ctx context.Context, request *Request, actor string, config *MapPropertySource) *graphql.Result {
+	if schemaErr != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(schemaErr)}
+	}
+
+	if doc, err := parser.Parse(parser.ParseParams{Source: request.Query}); err == nil {
+		if err := checkLimits(doc, request.OperationName, request.Variables, config); err != nil {
+			return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+		}
+	}
+
+	ctx = withScope(ctx, &scope{config: config, loaders: newLoaders(config), actor: actor})
+	return graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+}
@@ -0,0 +1,142 @@
+package graph
+
+import (
+	. "awesomeTestProject/shared"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// limiter estimates the depth and cost of an operation before it runs. Every
+// field costs one and a list field multiplies the cost of its selections by
+// its first argument, or by graphql-page-size for students without one.
+// Introspection fields are not counted.
+type limiter struct {
+	maxDepth      int
+	maxComplexity int
+	pageSize      int
+	variables     map[string]interface{}
+	fragments     map[string]*ast.FragmentDefinition
+	visiting      map[string]bool
+}
+
+func checkLimits(doc *ast.Document, operationName string, variables map[string]interface{}, config *MapPropertySource) error {
+	l := &limiter{
+		maxDepth:      config.GetInt("graphql-max-depth"),
+		maxComplexity: config.GetInt("graphql-max-complexity"),
+		pageSize:      config.GetInt("graphql-page-size"),
+		variables:     variables,
+		fragments:     map[string]*ast.FragmentDefinition{},
+		visiting:      map[string]bool{},
+	}
+
+	for _, d := range doc.Definitions {
+		if d, ok := d.(*ast.FragmentDefinition); ok {
+			l.fragments[d.Name.Value] = d
+		}
+	}
+	operation := operationOf(doc, operationName)
+	if operation == nil {
+		return nil
+	}
+
+	cost, err := l.selectionSet(operation.SelectionSet, 1)
+	if err != nil {
+		return err
+	}
+	if cost > l.maxComplexity {
+		return Error.Text("query complexity %d exceeds the maximum of %d", cost, l.maxComplexity)
+	}
+	return nil
+}
+
+func (l *limiter) selectionSet(set *ast.SelectionSet, depth int) (int, error) {
+	if set == nil {
+		return 0, nil
+	}
+	if depth > l.maxDepth {
+		return 0, Error.Text("query depth exceeds the maximum of %d", l.maxDepth)
+	}
+
+	cost := 0
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			children, err := l.selectionSet(s.SelectionSet, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			cost += 1 + children*l.multiplier(s)
+		case *ast.InlineFragment:
+			children, err := l.selectionSet(s.SelectionSet, depth)
+			if err != nil {
+				return 0, err
+			}
+			cost += children
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, ok := l.fragments[name]
+			if !ok || l.visiting[name] {
+				continue
+			}
+			l.visiting[name] = true
+			children, err := l.selectionSet(fragment.SelectionSet, depth)
+			l.visiting[name] = false
+			if err != nil {
+				return 0, err
+			}
+			cost += children
+		}
+		// stop early so huge multipliers cannot overflow the estimate
+		if cost > l.maxComplexity {
+			return cost, nil
+		}
+	}
+	return cost, nil
+}
+
+// multiplier is capped just above the maximum complexity, anything larger
+// is refused anyway.
+func (l *limiter) multiplier(field *ast.Field) int {
+	n := l.first(field)
+	if n > l.maxComplexity {
+		return l.maxComplexity + 1
+	}
+	return n
+}
+
+func (l *limiter) first(field *ast.Field) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return n
+			}
+		case *ast.Variable:
+			switch n := l.variables[v.Name.Value].(type) {
+			case float64:
+				if n > float64(l.maxComplexity) {
+					return l.maxComplexity + 1
+				}
+				if n > 0 {
+					return int(n)
+				}
+			case int:
+				if n > 0 {
+					return n
+				}
+			}
+		}
+	}
+	if field.Name.Value == "students" {
+		return l.pageSize
+	}
+	return 1
+}
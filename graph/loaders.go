@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	. "awesomeTestProject/shared"
+	"context"
+	"sync"
+)
+
+type batchFunc func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+// Loader batches the keys requested while one level of the query resolves.
+// Load only queues the key and hands back a thunk, the executor calls the
+// thunks once every field on the level has resolved and the first of them
+// fetches all queued keys with one call to batch. Results are kept for the
+// rest of the request.
+type Loader struct {
+	batch   batchFunc
+	lock    sync.Mutex
+	pending []string
+	queued  map[string]bool
+	results map[string]interface{}
+	errs    map[string]error
+}
+
+func NewLoader(batch batchFunc) *Loader {
+	return &Loader{
+		batch:   batch,
+		queued:  map[string]bool{},
+		results: map[string]interface{}{},
+		errs:    map[string]error{},
+	}
+}
+
+func (l *Loader) Load(ctx context.Context, key string) func() (interface{}, error) {
+	l.lock.Lock()
+	_, done := l.results[key]
+	if !done && !l.queued[key] {
+		l.pending = append(l.pending, key)
+		l.queued[key] = true
+	}
+	l.lock.Unlock()
+
+	return func() (interface{}, error) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		if len(l.pending) > 0 {
+			keys := l.pending
+			l.pending = nil
+			results, err := l.batch(ctx, keys)
+			for _, k := range keys {
+				delete(l.queued, k)
+				if err != nil {
+					l.errs[k] = err
+					continue
+				}
+				l.results[k] = results[k]
+			}
+		}
+		if err := l.errs[key]; err != nil {
+			return nil, err
+		}
+		return l.results[key], nil
+	}
+}
+
+// loaders are created per request so nothing is cached across requests.
+type loaders struct {
+	enrollmentsByStudent *Loader
+	courseById           *Loader
+}
+
+func newLoaders(config *MapPropertySource) *loaders {
+	return &loaders{
+		enrollmentsByStudent: NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			byStudent, err := service.GetStudentsEnrollments(ctx, keys, config)
+			if err != nil {
+				return nil, err
+			}
+			results := make(map[string]interface{}, len(keys))
+			for _, k := range keys {
+				enrollments := byStudent[k]
+				if enrollments == nil {
+					enrollments = []models.Enrollment{}
+				}
+				results[k] = enrollments
+			}
+			return results, nil
+		}),
+		courseById: NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			courses, err := service.GetCourses(ctx, keys, config)
+			if err != nil {
+				return nil, err
+			}
+			results := make(map[string]interface{}, len(keys))
+			for i := range courses {
+				results[courses[i].Id] = &courses[i]
+			}
+			return results, nil
+		}),
+	}
+}
@@ -0,0 +1,326 @@
+package graph
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	. "awesomeTestProject/shared"
+	"context"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+)
+
+var metaType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Meta",
+	Fields: graphql.Fields{
+		"resourceType": &graphql.Field{Type: graphql.String},
+		"created":      &graphql.Field{Type: graphql.DateTime},
+		"lastModified": &graphql.Field{Type: graphql.DateTime},
+		"version":      &graphql.Field{Type: graphql.String},
+	},
+})
+
+var courseType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Course",
+	Fields: graphql.Fields{
+		"id":               &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"name":             &graphql.Field{Type: graphql.String},
+		"term":             &graphql.Field{Type: graphql.String},
+		"termEnd":          &graphql.Field{Type: graphql.DateTime},
+		"credits":          &graphql.Field{Type: graphql.Float},
+		"gradingScale":     &graphql.Field{Type: graphql.String},
+		"capacity":         &graphql.Field{Type: graphql.Int},
+		"enrolled":         &graphql.Field{Type: graphql.Int},
+		"dropDeadline":     &graphql.Field{Type: graphql.DateTime},
+		"withdrawDeadline": &graphql.Field{Type: graphql.DateTime},
+		"meta":             &graphql.Field{Type: metaType},
+	},
+})
+
+var gradeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Grade",
+	Fields: graphql.Fields{
+		"scale":   &graphql.Field{Type: graphql.String},
+		"mark":    &graphql.Field{Type: graphql.String},
+		"percent": &graphql.Field{Type: graphql.Float},
+		"points":  &graphql.Field{Type: graphql.Float},
+		"passing": &graphql.Field{Type: graphql.Boolean},
+	},
+})
+
+var enrollmentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Enrollment",
+	Fields: graphql.Fields{
+		"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"studentId":    &graphql.Field{Type: graphql.ID},
+		"courseId":     &graphql.Field{Type: graphql.ID},
+		"status":       &graphql.Field{Type: graphql.String},
+		"enrolledDate": &graphql.Field{Type: graphql.DateTime},
+		"dropDate":     &graphql.Field{Type: graphql.DateTime},
+		"withdrawDate": &graphql.Field{Type: graphql.DateTime},
+		"grade":        &graphql.Field{Type: gradeType},
+		"meta":         &graphql.Field{Type: metaType},
+		"course": &graphql.Field{
+			Type: courseType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				enrollment := p.Source.(models.Enrollment)
+				return scopeOf(p.Context).loaders.courseById.Load(p.Context, enrollment.CourseId), nil
+			},
+		},
+	},
+})
+
+var studentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Student",
+	Fields: graphql.Fields{
+		"id":               &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"name":             &graphql.Field{Type: graphql.String},
+		"enrollmentNumber": &graphql.Field{Type: graphql.String},
+		"status":           &graphql.Field{Type: graphql.String},
+		"meta":             &graphql.Field{Type: metaType},
+		"enrollments": &graphql.Field{
+			Type: graphql.NewList(enrollmentType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				student := p.Source.(models.Student)
+				return scopeOf(p.Context).loaders.enrollmentsByStudent.Load(p.Context, student.Id), nil
+			},
+		},
+	},
+})
+
+var transitionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StatusTransition",
+	Fields: graphql.Fields{
+		"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"studentId": &graphql.Field{Type: graphql.ID},
+		"from":      &graphql.Field{Type: graphql.String},
+		"to":        &graphql.Field{Type: graphql.String},
+		"reason":    &graphql.Field{Type: graphql.String},
+		"by":        &graphql.Field{Type: graphql.String},
+		"at":        &graphql.Field{Type: graphql.DateTime},
+	},
+})
+
+var studentFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "StudentFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"id":               &graphql.InputObjectFieldConfig{Type: graphql.ID},
+		"name":             &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"enrollmentNumber": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"status":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var sortOrderType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "SortOrder",
+	Values: graphql.EnumValueConfigMap{
+		"ASCENDING":  &graphql.EnumValueConfig{Value: "ascending"},
+		"DESCENDING": &graphql.EnumValueConfig{Value: "descending"},
+	},
+})
+
+var studentInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "StudentInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":             &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"enrollmentNumber": &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"student": &graphql.Field{
+			Type: studentType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				var student models.Student
+				student.Id = p.Args["id"].(string)
+				err := service.GetStudent(p.Context, &student, scopeOf(p.Context).config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return student, nil
+			},
+		},
+		"students": &graphql.Field{
+			Type: graphql.NewList(studentType),
+			Args: graphql.FieldConfigArgument{
+				"filter":    &graphql.ArgumentConfig{Type: studentFilterType},
+				"sortField": &graphql.ArgumentConfig{Type: graphql.String},
+				"sortOrder": &graphql.ArgumentConfig{Type: sortOrderType},
+				"first":     &graphql.ArgumentConfig{Type: graphql.Int},
+				"offset":    &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				config := scopeOf(p.Context).config
+				params, err := studentParams(p.Args, config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				students, err := service.GetStudents(p.Context, params, config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return students, nil
+			},
+		},
+		"course": &graphql.Field{
+			Type: courseType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return scopeOf(p.Context).loaders.courseById.Load(p.Context, p.Args["id"].(string)), nil
+			},
+		},
+	},
+})
+
+var mutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mutation",
+	Fields: graphql.Fields{
+		"createStudent": &graphql.Field{
+			Type: studentType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(studentInputType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				student := studentInput(p.Args["input"])
+				saved, err := service.SaveStudent(p.Context, &student, scopeOf(p.Context).config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return *saved, nil
+			},
+		},
+		"replaceStudent": &graphql.Field{
+			Type: studentType,
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(studentInputType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				student := studentInput(p.Args["input"])
+				student.Id = p.Args["id"].(string)
+				replaced, _, err := service.PutStudent(p.Context, &student, scopeOf(p.Context).config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return *replaced, nil
+			},
+		},
+		"deleteStudent": &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				err := service.DeleteStudent(p.Context, p.Args["id"].(string), scopeOf(p.Context).config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return true, nil
+			},
+		},
+		"transitionStudent": &graphql.Field{
+			Type: transitionType,
+			Args: graphql.FieldConfigArgument{
+				"id":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"to":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"reason": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				scope := scopeOf(p.Context)
+				payload := &models.TransitionRequestPayload{To: p.Args["to"].(string)}
+				if reason, ok := p.Args["reason"].(string); ok {
+					payload.Reason = reason
+				}
+				transition, err := service.TransitionStudent(p.Context, p.Args["id"].(string), scope.actor, payload, scope.config)
+				if err != nil {
+					return nil, resolverError(err)
+				}
+				return transition, nil
+			},
+		},
+	},
+})
+
+var schema, schemaErr = graphql.NewSchema(graphql.SchemaConfig{
+	Query:    queryType,
+	Mutation: mutationType,
+})
+
+// studentParams turns the students arguments into the parameters GetFilter
+// understands. Without first a page holds graphql-page-size students, the
+// page size the complexity of the query was estimated with, so first has to
+// be positive.
+func studentParams(args map[string]interface{}, config *MapPropertySource) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if filter, ok := args["filter"].(map[string]interface{}); ok {
+		for k, v := range filter {
+			params[k] = v
+		}
+	}
+	if v, ok := args["sortField"].(string); ok {
+		params["sortfield"] = v
+	}
+	if v, ok := args["sortOrder"].(string); ok {
+		params["sortorder"] = v
+	}
+	params["limit"] = config.GetInt("graphql-page-size")
+	if v, ok := args["first"].(int); ok {
+		if v < 1 {
+			return nil, Error.InvalidParam("first", "a positive integer", strconv.Itoa(v))
+		}
+		params["limit"] = v
+	}
+	if v, ok := args["offset"].(int); ok {
+		if v < 0 {
+			return nil, Error.InvalidParam("offset", "a non-negative integer", strconv.Itoa(v))
+		}
+		params["offset"] = v
+	}
+	return params, nil
+}
+
+func studentInput(arg interface{}) models.Student {
+	var student models.Student
+	input := arg.(map[string]interface{})
+	student.Name, _ = input["name"].(string)
+	student.EnrollmentNumber, _ = input["enrollmentNumber"].(string)
+	return student
+}
+
+// scope is what resolvers need from the request that runs them.
+type scope struct {
+	config  *MapPropertySource
+	loaders *loaders
+	actor   string
+}
+
+type scopeKey struct{}
+
+func withScope(ctx context.Context, s *scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+func scopeOf(ctx context.Context) *scope {
+	return ctx.Value(scopeKey{}).(*scope)
+}
+
+// statusError carries the http status and the error code the REST api would
+// answer with into the extensions of the GraphQL error.
+type statusError struct {
+	error
+}
+
+func (e statusError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"status": ErrorStatus(e.error), "code": ErrorCode(e.error)}
+}
+
+func resolverError(err error) error {
+	return statusError{err}
+}
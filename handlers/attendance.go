@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostAttendanceHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	bulkPayload, err := service.ParseAttendanceBulk(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(bulkPayload)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Mark Attendance of Course(%s)", bulkPayload.CourseId))
+	marked, err := service.MarkAttendance(ctx, bulkPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Marked %d Attendances of Course(%s).", len(marked), bulkPayload.CourseId))
+	ri.Entity(marked)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetCourseAttendanceHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	from, to, err := service.ParseAttendanceRange(ctx, req)
+	ErrorCheck(err)
+	groupBy := req.Param("groupBy")
+	if groupBy == "" {
+		groupBy = "student"
+	}
+
+	var course models.Course
+	course.Id = id
+	err = service.GetCourse(ctx, &course, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Get Attendance Report of Course(%s) by %s", id, groupBy))
+	report, err := service.GetAttendanceReport(ctx, bson.D{{"courseId", id}}, groupBy, from, to, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Attendance Report of Course(%s).", id))
+	ri.Entity(report)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetStudentAttendanceHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	from, to, err := service.ParseAttendanceRange(ctx, req)
+	ErrorCheck(err)
+	groupBy := req.Param("groupBy")
+	if groupBy == "" {
+		groupBy = "course"
+	}
+
+	Info(ctx, fmt.Sprintf("Get Attendance Report of Student(%s) by %s", id, groupBy))
+	report, err := service.GetAttendanceReport(ctx, bson.D{{"studentId", id}}, groupBy, from, to, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Attendance Report of Student(%s).", id))
+	ri.Entity(report)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetAttendanceAlertsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	from, to, err := service.ParseAttendanceRange(ctx, req)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Get Attendance Alerts of Course(%s)", id))
+	alert, err := service.GetAttendanceAlerts(ctx, id, from, to, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got %d Attendance Alerts of Course(%s).", len(alert.Students), id))
+	ri.Entity(alert)
+	ri.Status(http.StatusOK)
+	return ri
+}
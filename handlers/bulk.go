@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostBulkHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	bulkRequest, err := service.ParseBulk(ctx, req, config)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(bulkRequest)
+
+	location := strings.TrimSuffix(req.Raw().URL.Path, "/Bulk")
+	Info(ctx, fmt.Sprintf("Parsing completed, Process %d Bulk Operations", len(bulkRequest.Operations)))
+	bulkResponse, err := service.ProcessBulk(ctx, bulkRequest, location, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Processed %d Bulk Operations.", len(bulkResponse.Operations)))
+	ri.Entity(bulkResponse)
+	ri.Status(http.StatusOK)
+	return ri
+}
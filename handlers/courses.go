@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostCourseHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	postRequestPayload, err := service.ParseCourse(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(postRequestPayload)
+
+	Info(ctx, "Parsing completed, Create Course")
+	course, err := service.SaveCourse(ctx, postRequestPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, "Course created.")
+	ri.Entity(course)
+	ri.Status(http.StatusCreated)
+	return ri
+}
+
+func GetCourseByIdHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	var course models.Course
+	course.Id = req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Course(%s)", course.Id))
+	err := service.GetCourse(ctx, &course, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Course(%s).", course.Id))
+	ri.Entity(course)
+	ri.Status(http.StatusOK)
+	return ri
+}
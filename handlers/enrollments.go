@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostEnrollmentHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	postRequestPayload, err := service.ParseEnrollment(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(postRequestPayload)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Enroll Student(%s) in Course(%s)", postRequestPayload.StudentId, postRequestPayload.CourseId))
+	enrollment, err := service.EnrollStudent(ctx, postRequestPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Enrollment(%s) %s.", enrollment.Id, enrollment.Status))
+	ri.Entity(enrollment)
+	ri.Status(http.StatusCreated)
+	return ri
+}
+
+func GetCourseEnrollmentsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Enrollments of Course(%s)", id))
+	var course models.Course
+	course.Id = id
+	err := service.GetCourse(ctx, &course, config)
+	ErrorCheck(err)
+
+	enrollments, err := service.GetCourseEnrollments(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Enrollments of Course(%s).", id))
+	ri.Entity(enrollments)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetStudentEnrollmentsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Enrollments of Student(%s)", id))
+	enrollments, err := service.GetStudentEnrollments(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Enrollments of Student(%s).", id))
+	ri.Entity(enrollments)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func DropEnrollmentHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	var enrollment models.Enrollment
+	enrollment.CourseId = req.Param("id")
+	enrollment.Id = req.Param("enrollmentId")
+	Info(ctx, fmt.Sprintf("Drop Enrollment(%s)", enrollment.Id))
+	dropped, err := service.DropEnrollment(ctx, &enrollment, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Dropped Enrollment(%s).", dropped.Id))
+	ri.Entity(dropped)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func WithdrawEnrollmentHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	var enrollment models.Enrollment
+	enrollment.CourseId = req.Param("id")
+	enrollment.Id = req.Param("enrollmentId")
+	Info(ctx, fmt.Sprintf("Withdraw Enrollment(%s)", enrollment.Id))
+	withdrawn, err := service.WithdrawEnrollment(ctx, &enrollment, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Withdrew Enrollment(%s).", withdrawn.Id))
+	ri.Entity(withdrawn)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GetStudentEventsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := NewResponseOut()
+	Info(ctx, "Parse request")
+
+	params, err := service.ParseGetRequest(ctx, req)
+	ErrorCheck(err)
+	lastEventId, err := service.ParseLastEventId(req)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Stream Student events after %d", lastEventId))
+	ri.Header("Content-Type", "text/event-stream")
+	ri.Header("Cache-Control", "no-cache")
+	ri.Header("X-Accel-Buffering", "no")
+	ri.Stream(func(w io.Writer) error {
+		// ctx is done once the client goes away or the server shuts down
+		err := service.StreamStudentEvents(ctx, params, lastEventId, w, config)
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Student event stream ended: %s", err.Error()))
+			return err
+		}
+		Info(ctx, "Student event stream closed by the client")
+		return nil
+	})
+	ri.Status(http.StatusOK)
+	return ri
+}
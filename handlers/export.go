@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func ExportStudentsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := NewResponseOut()
+	Info(ctx, "Parse request")
+
+	params, err := service.ParseGetRequest(ctx, req)
+	ErrorCheck(err)
+	format, err := service.ParseExportFormat(req)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Export Students as %s", format))
+	ri.Header("Content-Type", service.ExportContentType(format))
+	ri.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="students.%s"`, format))
+	ri.Stream(func(w io.Writer) error {
+		err := service.ExportStudents(ctx, params, format, w, config)
+		if err != nil {
+			Fatal(ctx, fmt.Sprintf("Export of Students as %s failed: %s", format, err.Error()))
+			return err
+		}
+		Info(ctx, fmt.Sprintf("Exported Students as %s.", format))
+		return nil
+	})
+	ri.Status(http.StatusOK)
+	return ri
+}
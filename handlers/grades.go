@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PutGradeHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	gradeRequestPayload, err := service.ParseGrade(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(gradeRequestPayload)
+
+	var enrollment models.Enrollment
+	enrollment.CourseId = req.Param("id")
+	enrollment.Id = req.Param("enrollmentId")
+	Info(ctx, fmt.Sprintf("Parsing completed, Grade Enrollment(%s)", enrollment.Id))
+	graded, err := service.RecordGrade(ctx, &enrollment, gradeRequestPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Enrollment(%s) graded.", graded.Id))
+	ri.Entity(graded)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetTranscriptHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Transcript of Student(%s)", id))
+	transcript, err := service.GetTranscript(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Transcript of Student(%s).", id))
+	ri.Entity(transcript)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"awesomeTestProject/graph"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GraphQLHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	graphRequest, err := graph.ParseRequest(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(graphRequest)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Execute GraphQL operation %q", graphRequest.OperationName))
+	result := graph.Execute(ctx, graphRequest, AuthenticatedUser(ctx), config)
+
+	Info(ctx, fmt.Sprintf("Executed GraphQL operation %q with %d errors.", graphRequest.OperationName, len(result.Errors)))
+	ri.Entity(result)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	. "awesomeTestProject/shared"
+)
+
+const idempotencyReleaseTimeout = 5 * time.Second
+
+// Idempotent honours the Idempotency-Key header: the first response for a
+// key and the authenticated caller is stored and replayed for retries instead of running next
+// again. A retry with another body is refused with 422. Failed requests are
+// not stored, their retries run again.
+func Idempotent(next EndpointHandler) EndpointHandler {
+	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+		key := req.Header("Idempotency-Key")
+		if key == "" {
+			return next(req, ctx, config)
+		}
+
+		body, err := req.Body()
+		ErrorCheck(err)
+		req.Raw().Body = ioutil.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(req.Method()+" "+req.Raw().URL.Path+"\n"), body...))
+
+		caller := AuthenticatedUser(ctx)
+		replay, err := service.ClaimIdempotencyKey(ctx, key, caller, hex.EncodeToString(sum[:]), config)
+		ErrorCheck(err)
+		if replay != nil {
+			Info(ctx, fmt.Sprintf("Replaying the response to Idempotency-Key %s", key))
+			ri := NewResponseOut()
+			for k, v := range replay.Headers {
+				ri.Header(k, v)
+			}
+			ri.Header("Idempotent-Replayed", "true")
+			if len(replay.Body) > 0 {
+				ri.Entity(json.RawMessage(replay.Body))
+			}
+			ri.Status(replay.ResponseStatus)
+			return ri
+		}
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// released even when the request was cancelled or ran out of time
+			release, cancel := context.WithTimeout(context.Background(), idempotencyReleaseTimeout)
+			defer cancel()
+			if err := service.ReleaseIdempotencyKey(release, key, caller, config); err != nil {
+				Warn(ctx, fmt.Sprintf("Unable to release Idempotency-Key %s: %s", key, err.Error()))
+			}
+		}()
+
+		ri := next(req, ctx, config)
+		var stored []byte
+		if ri.GetEntity() != nil {
+			stored, err = json.Marshal(ri.GetEntity())
+			ErrorCheck(err)
+		}
+		ErrorCheck(service.CompleteIdempotencyKey(ctx, key, caller, ri.GetStatus(), ri.GetHeaders(), stored, config))
+		completed = true
+		return ri
+	}
+}
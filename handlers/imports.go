@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostStudentImportHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	opts, body, err := service.ParseImportRequest(ctx, req, config)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(opts)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Import Students (dry run %t)", opts.DryRun))
+	job, background, err := service.StartImport(ctx, opts, body, config)
+	ErrorCheck(err)
+
+	ri.Entity(job)
+	if background {
+		Info(ctx, fmt.Sprintf("Import job(%s) queued for %d rows.", job.Id, job.TotalRows))
+		ri.Status(http.StatusAccepted)
+		return ri
+	}
+	Info(ctx, fmt.Sprintf("Imported %d Students, %d rows failed.", job.Imported, job.Failed))
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetStudentImportHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("jobId")
+	Info(ctx, fmt.Sprintf("Get Import job(%s)", id))
+	job, err := service.GetImportJob(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Import job(%s) is %s, %d of %d rows processed.", id, job.Status, job.ProcessedRows, job.TotalRows))
+	ri.Entity(job)
+	ri.Status(http.StatusOK)
+	return ri
+}
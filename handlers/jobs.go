@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"awesomeTestProject/jobs"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GetJobHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Job(%s)", id))
+	job, err := jobs.Get(ctx, id)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Job(%s) is %s, %d of %d done.", id, job.Status, job.Progress.Completed, job.Progress.Total))
+	ri.Entity(job)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+type ledgerOperation func(ctx context.Context, studentId string, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error)
+
+func ledgerEntryHandler(kind string, operation ledgerOperation) EndpointHandler {
+	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+		ri := &ResponseOut{}
+		Info(ctx, "Parse request")
+
+		Info(ctx, "Parse request body")
+		ledgerRequestPayload, err := service.ParseLedgerRequest(ctx, req)
+		ErrorCheck(err)
+		ErrorCheckNilThrowInvalidParam(ledgerRequestPayload)
+
+		id := req.Param("id")
+		Info(ctx, fmt.Sprintf("Parsing completed, Add %s for Student(%s)", kind, id))
+		entry, err := operation(ctx, id, ledgerRequestPayload, config)
+		ErrorCheck(err)
+
+		Info(ctx, fmt.Sprintf("LedgerEntry(%s) %s added.", entry.Id, kind))
+		ri.Entity(entry)
+		ri.Status(http.StatusCreated)
+		return ri
+	}
+}
+
+var (
+	PostInvoiceHandler = ledgerEntryHandler(models.LedgerInvoice, service.AddInvoice)
+	PostPaymentHandler = ledgerEntryHandler(models.LedgerPayment, service.CapturePayment)
+	PostRefundHandler  = ledgerEntryHandler(models.LedgerRefund, service.RefundPayment)
+)
+
+func PostReversalHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	ledgerRequestPayload, err := service.ParseLedgerRequest(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(ledgerRequestPayload)
+
+	id := req.Param("id")
+	entryId := req.Param("entryId")
+	Info(ctx, fmt.Sprintf("Parsing completed, Reverse LedgerEntry(%s) of Student(%s)", entryId, id))
+	entry, err := service.ReverseLedgerEntry(ctx, id, entryId, ledgerRequestPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("LedgerEntry(%s) reversed by LedgerEntry(%s).", entryId, entry.Id))
+	ri.Entity(entry)
+	ri.Status(http.StatusCreated)
+	return ri
+}
+
+func GetLedgerHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Ledger of Student(%s)", id))
+	ledger, err := service.GetLedger(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Ledger of Student(%s).", id))
+	ri.Entity(ledger)
+	ri.Status(http.StatusOK)
+	return ri
+}
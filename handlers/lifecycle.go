@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostTransitionHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	transitionRequestPayload, err := service.ParseTransition(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(transitionRequestPayload)
+
+	id := req.Param("id")
+	actor := AuthenticatedUser(ctx)
+	Info(ctx, fmt.Sprintf("Parsing completed, Transition Student(%s) to %s by %s", id, transitionRequestPayload.To, actor))
+	transition, err := service.TransitionStudent(ctx, id, actor, transitionRequestPayload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Student(%s) moved from %s to %s.", id, transition.From, transition.To))
+	ri.Entity(transition)
+	ri.Status(http.StatusCreated)
+	return ri
+}
+
+func GetTransitionsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Transitions of Student(%s)", id))
+	transitions, err := service.GetStudentTransitions(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got Transitions of Student(%s).", id))
+	ri.Entity(transitions)
+	ri.Status(http.StatusOK)
+	return ri
+}
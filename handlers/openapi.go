@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"awesomeTestProject/openapi"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GetOpenAPIHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	doc := openapi.Build("Students API", "v1")
+
+	Info(ctx, fmt.Sprintf("Described %d paths.", len(doc.Paths)))
+	ri.Entity(doc)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"awesomeTestProject/scheduler"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GetSchedulesHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Get Schedules")
+	schedules, err := scheduler.List(ctx, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got %d Schedules.", len(schedules)))
+	ri.Entity(schedules)
+	ri.Status(http.StatusOK)
+	return ri
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"encoding/json"
+
+	. "awesomeTestProject/shared"
+)
+
+// SCIMErrors answers the errors of SCIM routes in the error schema of SCIM
+// instead of as problem details. It wraps ErrorRecovery.
+func SCIMErrors(next EndpointHandler) EndpointHandler {
+	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+		ri := next(req, ctx, config)
+		problem := ri.GetProblem()
+		if problem == nil {
+			return ri
+		}
+		b, err := json.Marshal(service.ScimError(problem.Status, problem.Code, problem.Detail))
+		if err != nil {
+			return ri
+		}
+		return ri.Header("Content-Type", MediaTypeSCIMJSON).Body(b)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"awesomeTestProject/models"
 	"awesomeTestProject/services"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	. "awesomeTestProject/shared"
 )
@@ -25,8 +25,7 @@ func PostStudentHandler(req HttpWebRequest, ctx context.Context, config *MapProp
 	ErrorCheck(err)
 
 	Info(ctx, "Student created.")
-	res, _ := json.Marshal(student)
-	ri.Body(res)
+	ri.Entity(student)
 	ri.Status(http.StatusCreated)
 	return ri
 }
@@ -41,12 +40,16 @@ func PutStudentHandler(req HttpWebRequest, ctx context.Context, config *MapPrope
 	ErrorCheckNilThrowInvalidParam(postRequestPayload)
 
 	Info(ctx, fmt.Sprintf("Parsing completed, Update Student(%s)", postRequestPayload.Id))
-	student, err := service.PutStudent(ctx, postRequestPayload, config)
+	student, created, err := service.PutStudent(ctx, postRequestPayload, config)
 	ErrorCheck(err)
 
+	ri.Entity(student)
+	if created {
+		Info(ctx, fmt.Sprintf("Student(%s) created.", student.Id))
+		ri.Status(http.StatusCreated)
+		return ri
+	}
 	Info(ctx, fmt.Sprintf("Student(%s) updated.", student.Id))
-	res, _ := json.Marshal(student)
-	ri.Body(res)
 	ri.Status(http.StatusOK)
 	return ri
 }
@@ -64,8 +67,7 @@ func PatchStudentHandler(req HttpWebRequest, ctx context.Context, config *MapPro
 	ErrorCheck(err)
 
 	Info(ctx, fmt.Sprintf("Student(%s) patched.", student.Id))
-	res, _ := json.Marshal(student)
-	ri.Body(res)
+	ri.Entity(student)
 	ri.Status(http.StatusOK)
 	return ri
 }
@@ -83,7 +85,7 @@ func GetStudentsHandler(req HttpWebRequest, ctx context.Context, config *MapProp
 	ErrorCheck(err)
 
 	Info(ctx, "Got Students.")
-	ri.Body(item)
+	ri.Entity(item)
 	ri.Status(http.StatusOK)
 	return ri
 }
@@ -94,18 +96,26 @@ func GetStudentByIdHandler(req HttpWebRequest, ctx context.Context, config *MapP
 
 	var student models.Student
 	student.Id = req.Param("id")
-	Info(ctx, fmt.Sprintf("Get Student(%s)", student.Id))
-	err := service.GetStudent(ctx, &student, config)
-	if err != nil && err.Error() == "mongo: no documents in result" {
-		ri.Body([]byte{})
+	if asOf := req.Param("asOf"); asOf != "" {
+		t, err := time.Parse(time.RFC3339, asOf)
+		ErrorCheckForFalseAndThrowError(err == nil, Error.InvalidParam("asOf", "an RFC3339 timestamp", asOf))
+
+		Info(ctx, fmt.Sprintf("Get Student(%s) as of %s", student.Id, asOf))
+		versioned, err := service.GetStudentAsOf(ctx, student.Id, t, config)
+		ErrorCheck(err)
+
+		Info(ctx, fmt.Sprintf("Student(%s) version %s.", versioned.Id, versioned.Meta.Version))
+		ri.Entity(versioned)
 		ri.Status(http.StatusOK)
 		return ri
 	}
+
+	Info(ctx, fmt.Sprintf("Get Student(%s)", student.Id))
+	err := service.GetStudent(ctx, &student, config)
 	ErrorCheck(err)
 
 	Info(ctx, fmt.Sprintf("Student(%s).", student.Id))
-	res, _ := json.Marshal(student)
-	ri.Body(res)
+	ri.Entity(student)
 	ri.Status(http.StatusOK)
 	return ri
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func GetStudentVersionsHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Versions of Student(%s)", id))
+	versions, err := service.GetStudentVersions(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got %d prior Versions of Student(%s).", len(versions.Versions), id))
+	ri.Entity(versions)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetStudentVersionHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	version := req.Param("version")
+	Info(ctx, fmt.Sprintf("Get Student(%s) version %s", id, version))
+	student, err := service.GetStudentVersion(ctx, id, version, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Student(%s) version %s.", id, version))
+	ri.Entity(student)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func RevertStudentHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	version := req.Param("version")
+	Info(ctx, fmt.Sprintf("Revert Student(%s) to version %s", id, version))
+	student, err := service.RevertStudent(ctx, id, version, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Student(%s) reverted to version %s as version %s.", id, version, student.Meta.Version))
+	ri.Entity(student)
+	ri.Status(http.StatusOK)
+	return ri
+}
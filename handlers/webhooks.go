@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"awesomeTestProject/services"
+	"context"
+	"fmt"
+	"net/http"
+
+	. "awesomeTestProject/shared"
+)
+
+func PostWebhookHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Parse request body")
+	payload, err := service.ParseWebhookSubscription(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(payload)
+
+	Info(ctx, "Parsing completed, Create Webhook")
+	subscription, err := service.CreateWebhookSubscription(ctx, payload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Webhook(%s) created for %v.", subscription.Id, subscription.Events))
+	ri.Entity(subscription)
+	ri.Status(http.StatusCreated)
+	return ri
+}
+
+func GetWebhooksHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	Info(ctx, "Get Webhooks")
+	subscriptions, err := service.GetWebhookSubscriptions(ctx, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got %d Webhooks.", len(subscriptions)))
+	ri.Entity(subscriptions)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetWebhookHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Webhook(%s)", id))
+	subscription, err := service.GetWebhookSubscription(ctx, id, config)
+	ErrorCheck(err)
+
+	ri.Entity(subscription)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func PutWebhookHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, "Parse request body")
+	payload, err := service.ParseWebhookSubscription(ctx, req)
+	ErrorCheck(err)
+	ErrorCheckNilThrowInvalidParam(payload)
+
+	Info(ctx, fmt.Sprintf("Parsing completed, Update Webhook(%s)", id))
+	subscription, err := service.UpdateWebhookSubscription(ctx, id, payload, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Webhook(%s) updated, active %t.", id, subscription.Active))
+	ri.Entity(subscription)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func DeleteWebhookHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Delete Webhook(%s)", id))
+	err := service.DeleteWebhookSubscription(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Deleted Webhook(%s).", id))
+	ri.Body([]byte{})
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func GetWebhookDeliveriesHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id := req.Param("id")
+	Info(ctx, fmt.Sprintf("Get Deliveries of Webhook(%s)", id))
+	deliveries, err := service.GetWebhookDeliveries(ctx, id, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Got %d Deliveries of Webhook(%s).", len(deliveries), id))
+	ri.Entity(deliveries)
+	ri.Status(http.StatusOK)
+	return ri
+}
+
+func PostRedeliveryHandler(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+	ri := &ResponseOut{}
+	Info(ctx, "Parse request")
+
+	id, deliveryId := req.Param("id"), req.Param("deliveryId")
+	Info(ctx, fmt.Sprintf("Redeliver Delivery(%s) of Webhook(%s)", deliveryId, id))
+	delivery, err := service.RedeliverWebhook(ctx, id, deliveryId, config)
+	ErrorCheck(err)
+
+	Info(ctx, fmt.Sprintf("Delivery(%s) queued again.", deliveryId))
+	ri.Entity(delivery)
+	ri.Status(http.StatusAccepted)
+	return ri
+}
@@ -0,0 +1,106 @@
+// Package jobs is a durable queue of background work kept in the jobs
+// collection of MongoDatabase. Jobs are claimed under a lease by a pool of
+// workers, failed jobs are retried with a growing delay and dead-lettered
+// once they run out of attempts.
+package jobs
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Handler runs a job of one type, the payload is the one it was enqueued
+// with. A returned error or a panic fails the attempt.
+type Handler func(ctx context.Context, job *models.Job, progress Progress) error
+
+// Progress stores how far a job got, which GET /jobs/:id shows. Reporting
+// progress also extends the lease, it fails once the lease was lost to
+// another worker and the handler should stop.
+type Progress func(completed, total int) error
+
+var (
+	lock     sync.RWMutex
+	handlers = map[string]Handler{}
+)
+
+// Register makes jobs of the given type runnable by the workers.
+func Register(jobType string, handler Handler) {
+	lock.Lock()
+	defer lock.Unlock()
+	handlers[jobType] = handler
+}
+
+func getHandler(jobType string) (Handler, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	h, ok := handlers[jobType]
+	return h, ok
+}
+
+func registeredTypes() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	types := make([]string, 0, len(handlers))
+	for t := range handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Enqueue stores a job of the given type to be run as soon as a worker is
+// free. The payload is stored as JSON.
+func Enqueue(ctx context.Context, jobType string, payload interface{}, config *MapPropertySource) (*models.Job, error) {
+	return EnqueueAt(ctx, jobType, payload, time.Now(), config)
+}
+
+// EnqueueAt stores a job that is not run before runAt.
+func EnqueueAt(ctx context.Context, jobType string, payload interface{}, runAt time.Time, config *MapPropertySource) (*models.Job, error) {
+	if _, ok := getHandler(jobType); !ok {
+		return nil, Error.InvalidParam("type", strings.Join(registeredTypes(), ", "), jobType)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	job := &models.Job{
+		Id:          uuid.NewV4().String(),
+		Type:        jobType,
+		Payload:     b,
+		Status:      models.JobPending,
+		MaxAttempts: config.GetInt("job-max-attempts"),
+		RunAt:       runAt.UTC(),
+		Meta:        models.Meta{ResourceType: "Job", Created: now, LastModified: now},
+	}
+	err = datastore.GetDatastore().Save(ctx, datastore.Db.Jobs, job)
+	if err != nil {
+		return nil, err
+	}
+	Info(ctx, fmt.Sprintf("Job(%s) of type %s enqueued", job.Id, jobType))
+	return job, nil
+}
+
+func Get(ctx context.Context, id string) (*models.Job, error) {
+	var job models.Job
+	err := datastore.GetDatastore().GetById(ctx, datastore.Db.Jobs, bson.D{{"id", id}}, &job)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
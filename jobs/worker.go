@@ -0,0 +1,268 @@
+package jobs
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// outcomeTimeout bounds recording the outcome of a job, which is done apart
+// from the context of the job so it is recorded after the job was cancelled.
+const outcomeTimeout = 5 * time.Second
+
+// Start runs job-workers workers until ctx is done. Each polls the queue
+// every job-poll-interval seconds while it is empty.
+func Start(ctx context.Context, config *MapPropertySource) error {
+	for _, name := range []string{"job-lease", "job-poll-interval", "job-max-attempts", "job-retention"} {
+		if n := config.GetInt(name); n < 1 {
+			return Error.InvalidParam(name, "a positive integer", fmt.Sprint(n))
+		}
+	}
+
+	// the workers claim by status and time every poll, jobs are read by id
+	collection := datastore.Db.Jobs
+	if err := datastore.GetDatastore().EnsureUnique(ctx, collection, bson.D{{"id", 1}}, nil); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to create the unique index of %s: %s", collection, err.Error()))
+	}
+	for _, keys := range []bson.D{{{"status", 1}, {"runAt", 1}}, {{"status", 1}, {"leaseUntil", 1}}} {
+		if err := datastore.GetDatastore().EnsureIndex(ctx, collection, keys); err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the claim index of %s: %s", collection, err.Error()))
+		}
+	}
+	if err := datastore.GetDatastore().EnsureExpiry(ctx, collection, "expiresAt"); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to create the expiry index of %s: %s", collection, err.Error()))
+	}
+
+	for i := 0; i < config.GetInt("job-workers"); i++ {
+		go work(ctx, uuid.NewV4().String(), config)
+	}
+	return nil
+}
+
+func work(ctx context.Context, owner string, config *MapPropertySource) {
+	poll := time.Duration(config.GetInt("job-poll-interval")) * time.Second
+	for ctx.Err() == nil {
+		job, err := claim(ctx, owner, config)
+		if err != nil && !datastore.IsNotFound(err) {
+			Warn(ctx, fmt.Sprintf("Worker(%s) unable to claim a job: %s", owner, err.Error()))
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(poll):
+			}
+			continue
+		}
+		run(ctx, job, owner, config)
+	}
+}
+
+// claim leases the pending job that is due the longest, or a running job
+// whose worker let the lease run out, to owner.
+func claim(ctx context.Context, owner string, config *MapPropertySource) (*models.Job, error) {
+	now := time.Now().UTC()
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"status", models.JobPending}, {"runAt", bson.D{{"$lte", now}}}},
+		bson.D{{"status", models.JobRunning}, {"leaseUntil", bson.D{{"$lt", now}}}},
+	}}}
+	update := bson.D{
+		{"$set", bson.D{
+			{"status", models.JobRunning},
+			{"leaseOwner", owner},
+			{"leaseUntil", now.Add(lease(config))},
+			{"meta.lastModified", now},
+		}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+
+	var job models.Job
+	err := datastore.GetDatastore().FindOneAndUpdate(ctx, datastore.Db.Jobs, filter, update, bson.D{{"runAt", 1}}, &job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// run runs the job in its own service scope, so a panic is recovered and
+// logged with the trace id of the run. The handler is cancelled when ctx is
+// done, the job then goes back to the queue, and when the lease is lost.
+func run(ctx context.Context, job *models.Job, owner string, config *MapPropertySource) {
+	InjectServiceScopeWith(ctx, time.Now().Unix(), func(t int64, ctx context.Context) {
+		Info(ctx, fmt.Sprintf("Job(%s) of type %s started, attempt %d of %d", job.Id, job.Type, job.Attempts, job.MaxAttempts))
+
+		handler, ok := getHandler(job.Type)
+		if !ok {
+			finish(ctx, job, owner, Error.Text("no handler for jobs of type %s", job.Type), config)
+			return
+		}
+		if job.Attempts > job.MaxAttempts {
+			finish(ctx, job, owner, Error.Text("lease ran out on the last attempt"), config)
+			return
+		}
+
+		running, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := keepLease(running, cancel, job, owner, config)
+		defer stop()
+		defer func() {
+			if r := recover(); r != nil {
+				err, ok := r.(error)
+				if !ok {
+					err = Error.Text("%v", r)
+				}
+				finish(ctx, job, owner, err, config)
+				panic(err)
+			}
+		}()
+
+		progress := func(completed, total int) error {
+			job.Progress.Completed, job.Progress.Total = completed, total
+			err := update(running, job, owner, bson.D{
+				{"progress.completed", completed},
+				{"progress.total", total},
+				{"leaseUntil", time.Now().UTC().Add(lease(config))},
+			})
+			if err != nil && running.Err() == nil {
+				cancel()
+			}
+			return err
+		}
+		err := handler(running, job, progress)
+		switch {
+		case ctx.Err() != nil:
+			requeue(ctx, job, owner)
+		case running.Err() != nil:
+			// the lease was lost, the outcome is the new owner's to record
+		default:
+			finish(ctx, job, owner, err, config)
+		}
+	})
+}
+
+// keepLease extends the lease of a running job until the returned function is
+// called, so long jobs that do not report progress keep it too. Once the
+// lease is lost to another worker cancel stops the handler.
+func keepLease(ctx context.Context, cancel context.CancelFunc, job *models.Job, owner string, config *MapPropertySource) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease(config) / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				matched, err := datastore.GetDatastore().UpdateMatched(ctx, datastore.Db.Jobs, bson.D{{"id", job.Id}, {"leaseOwner", owner}},
+					bson.D{{"$set", bson.D{{"leaseUntil", time.Now().UTC().Add(lease(config))}, {"meta.lastModified", time.Now().UTC()}}}})
+				if err != nil {
+					// the lease may still be extended before it runs out
+					Warn(ctx, fmt.Sprintf("Unable to extend the lease of Job(%s): %s", job.Id, err.Error()))
+				} else if matched == 0 {
+					Warn(ctx, fmt.Sprintf("Lease on Job(%s) was lost, stopping it", job.Id))
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// requeue puts back a job whose worker is shutting down, the attempt it was
+// claimed with does not count.
+func requeue(ctx context.Context, job *models.Job, owner string) {
+	store, cancel := context.WithTimeout(context.Background(), outcomeTimeout)
+	defer cancel()
+	now := time.Now().UTC()
+	query := bson.D{
+		{"$set", bson.D{{"status", models.JobPending}, {"runAt", now}, {"meta.lastModified", now}}},
+		{"$unset", bson.D{{"leaseOwner", ""}, {"leaseUntil", ""}}},
+		{"$inc", bson.D{{"attempts", -1}}},
+	}
+	_, err := datastore.GetDatastore().UpdateMatched(store, datastore.Db.Jobs, bson.D{{"id", job.Id}, {"leaseOwner", owner}}, query)
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to put back Job(%s), it is claimed again once its lease runs out: %s", job.Id, err.Error()))
+	}
+}
+
+// finish records the outcome of an attempt. A failed job is retried after
+// job-backoff seconds, doubled for every attempt and capped at
+// job-backoff-max, and dead-lettered after its last attempt. Completed and
+// dead jobs are removed job-retention seconds later.
+func finish(ctx context.Context, job *models.Job, owner string, err error, config *MapPropertySource) {
+	now := time.Now().UTC()
+	set := bson.D{{"meta.lastModified", now}}
+	switch {
+	case err == nil:
+		job.Status = models.JobCompleted
+		Info(ctx, fmt.Sprintf("Job(%s) completed", job.Id))
+	case job.Attempts >= job.MaxAttempts:
+		job.Status = models.JobDead
+		Fatal(ctx, fmt.Sprintf("Job(%s) failed attempt %d of %d and is dead: %s", job.Id, job.Attempts, job.MaxAttempts, err.Error()))
+	default:
+		job.Status = models.JobPending
+		job.RunAt = now.Add(backoff(job.Attempts, config))
+		set = append(set, bson.E{"runAt", job.RunAt})
+		Warn(ctx, fmt.Sprintf("Job(%s) failed attempt %d of %d, retrying at %s: %s", job.Id, job.Attempts, job.MaxAttempts, job.RunAt.Format(time.RFC3339), err.Error()))
+	}
+	if err != nil {
+		job.LastError = err.Error()
+		set = append(set, bson.E{"lastError", job.LastError})
+	}
+	set = append(set, bson.E{"status", job.Status})
+	if job.Status != models.JobPending {
+		job.ExpiresAt = now.Add(time.Duration(config.GetInt("job-retention")) * time.Second)
+		set = append(set, bson.E{"expiresAt", job.ExpiresAt})
+	}
+
+	query := bson.D{
+		{"$set", set},
+		{"$unset", bson.D{{"leaseOwner", ""}, {"leaseUntil", ""}}},
+	}
+	// recorded even when the job ran out of time
+	store, cancel := context.WithTimeout(context.Background(), outcomeTimeout)
+	defer cancel()
+	matched, uerr := datastore.GetDatastore().UpdateMatched(store, datastore.Db.Jobs, bson.D{{"id", job.Id}, {"leaseOwner", owner}}, query)
+	if uerr != nil {
+		Warn(ctx, fmt.Sprintf("Unable to record the outcome of Job(%s): %s", job.Id, uerr.Error()))
+	} else if matched == 0 {
+		Warn(ctx, fmt.Sprintf("Job(%s) lease was lost, the outcome is dropped", job.Id))
+	}
+}
+
+// update sets fields on a job while owner holds its lease.
+func update(ctx context.Context, job *models.Job, owner string, set bson.D) error {
+	set = append(set, bson.E{"meta.lastModified", time.Now().UTC()})
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, datastore.Db.Jobs, bson.D{{"id", job.Id}, {"leaseOwner", owner}}, bson.D{{"$set", set}})
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return Error.Text("lease on Job(%s) was lost", job.Id)
+	}
+	return nil
+}
+
+func lease(config *MapPropertySource) time.Duration {
+	return time.Duration(config.GetInt("job-lease")) * time.Second
+}
+
+func backoff(attempts int, config *MapPropertySource) time.Duration {
+	delay := time.Duration(config.GetInt("job-backoff")) * time.Second
+	max := time.Duration(config.GetInt("job-backoff-max")) * time.Second
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/datastore/datastoretest"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func jobTest(t *testing.T) (*datastoretest.Store, *MapPropertySource) {
+	InitConfigs()
+	// a copy, the lease of a job run by an earlier test may still read its own
+	config := &MapPropertySource{Data: map[string]interface{}{}}
+	for k, v := range GetConfigs().Data {
+		config.Data[k] = v
+	}
+	config.Data["job-max-attempts"] = 2
+	config.Data["job-backoff"] = 0
+	config.Data["job-backoff-max"] = 0
+	store := datastoretest.Use(t)
+	datastore.Db = &datastore.MongoDatabase{Jobs: "jobs"}
+	return store, config
+}
+
+func stored(t *testing.T, id string) *models.Job {
+	job, err := Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	return job
+}
+
+// claimAndRun runs the next due job the way a worker does.
+func claimAndRun(t *testing.T, owner string, config *MapPropertySource) *models.Job {
+	job, err := claim(context.Background(), owner, config)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	run(context.Background(), job, owner, config)
+	return job
+}
+
+func TestBackoffDoublesUpToTheMax(t *testing.T) {
+	_, config := jobTest(t)
+	config.Data["job-backoff"] = 2
+	config.Data["job-backoff-max"] = 10
+
+	for attempts, want := range map[int]int{1: 2, 2: 4, 3: 8, 4: 10, 20: 10} {
+		if got := backoff(attempts, config); got != time.Duration(want)*time.Second {
+			t.Errorf("backoff after %d attempts = %s, want %ds", attempts, got, want)
+		}
+	}
+}
+
+func TestFailingJobIsRetriedThenDead(t *testing.T) {
+	_, config := jobTest(t)
+	Register("test-failing", func(ctx context.Context, job *models.Job, progress Progress) error {
+		return errors.New("broken")
+	})
+	queued, err := Enqueue(context.Background(), "test-failing", nil, config)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	claimAndRun(t, "w1", config)
+	job := stored(t, queued.Id)
+	if job.Status != models.JobPending || job.Attempts != 1 || job.LastError != "broken" || job.LeaseOwner != "" {
+		t.Fatalf("job = %+v, want pending for a retry after a failure", job)
+	}
+	if !job.ExpiresAt.IsZero() {
+		t.Fatal("job to be retried expires")
+	}
+
+	claimAndRun(t, "w1", config)
+	job = stored(t, queued.Id)
+	if job.Status != models.JobDead || job.Attempts != 2 || job.ExpiresAt.IsZero() {
+		t.Fatalf("job = %+v, want dead and expiring after its last attempt", job)
+	}
+	if _, err := claim(context.Background(), "w1", config); !datastore.IsNotFound(err) {
+		t.Fatalf("claim after the job died: %v, want nothing to claim", err)
+	}
+}
+
+func TestRetryWaitsForTheBackoff(t *testing.T) {
+	_, config := jobTest(t)
+	config.Data["job-backoff"] = 60
+	config.Data["job-backoff-max"] = 60
+	Register("test-failing", func(ctx context.Context, job *models.Job, progress Progress) error {
+		return errors.New("broken")
+	})
+	queued, _ := Enqueue(context.Background(), "test-failing", nil, config)
+
+	claimAndRun(t, "w1", config)
+	if job := stored(t, queued.Id); job.RunAt.Before(time.Now().Add(59 * time.Second)) {
+		t.Fatalf("retry at %s, want a minute later", job.RunAt)
+	}
+	if _, err := claim(context.Background(), "w1", config); !datastore.IsNotFound(err) {
+		t.Fatalf("claim during the backoff: %v, want nothing to claim", err)
+	}
+}
+
+func TestCompletedJobExpires(t *testing.T) {
+	_, config := jobTest(t)
+	Register("test-ok", func(ctx context.Context, job *models.Job, progress Progress) error {
+		return progress(1, 1)
+	})
+	queued, _ := Enqueue(context.Background(), "test-ok", nil, config)
+
+	claimAndRun(t, "w1", config)
+	job := stored(t, queued.Id)
+	if job.Status != models.JobCompleted || job.Progress.Completed != 1 || job.LeaseOwner != "" {
+		t.Fatalf("job = %+v, want completed", job)
+	}
+	retention := time.Duration(config.GetInt("job-retention")) * time.Second
+	if job.ExpiresAt.Before(time.Now().Add(retention - time.Minute)) {
+		t.Fatalf("completed job expires at %s, want after job-retention", job.ExpiresAt)
+	}
+}
+
+func TestExpiredLeaseIsClaimedByAnotherWorker(t *testing.T) {
+	_, config := jobTest(t)
+	Register("test-ok", func(ctx context.Context, job *models.Job, progress Progress) error { return nil })
+	queued, _ := Enqueue(context.Background(), "test-ok", nil, config)
+
+	first, err := claim(context.Background(), "w1", config)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := claim(context.Background(), "w2", config); !datastore.IsNotFound(err) {
+		t.Fatalf("claim of a leased job: %v, want nothing to claim", err)
+	}
+	// w1 stopped extending its lease
+	_ = datastore.GetDatastore().Update(context.Background(), "jobs", bson.D{{"id", queued.Id}},
+		bson.D{{"$set", bson.D{{"leaseUntil", time.Now().UTC().Add(-time.Second)}}}})
+
+	second, err := claim(context.Background(), "w2", config)
+	if err != nil || second.Id != queued.Id || second.Attempts != 2 {
+		t.Fatalf("claim after the lease ran out = %+v, %v, want the job on its second attempt", second, err)
+	}
+	// the outcome of the worker that lost the lease is dropped
+	finish(context.Background(), first, "w1", errors.New("late"), config)
+	if job := stored(t, queued.Id); job.Status != models.JobRunning || job.LeaseOwner != "w2" {
+		t.Fatalf("job = %+v, want still running for w2", job)
+	}
+}
+
+func TestJobStopsOnceItsLeaseIsLost(t *testing.T) {
+	_, config := jobTest(t)
+	config.Data["job-lease"] = 1
+	stopped := make(chan struct{})
+	Register("test-long", func(ctx context.Context, job *models.Job, progress Progress) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+	queued, _ := Enqueue(context.Background(), "test-long", nil, config)
+	job, err := claim(context.Background(), "w1", config)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	go run(context.Background(), job, "w1", config)
+	_ = datastore.GetDatastore().Update(context.Background(), "jobs", bson.D{{"id", queued.Id}},
+		bson.D{{"$set", bson.D{{"leaseOwner", "w2"}}}})
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("job kept running after its lease was lost")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if job := stored(t, queued.Id); job.Status != models.JobRunning || job.LeaseOwner != "w2" {
+		t.Fatalf("job = %+v, want left to w2", job)
+	}
+}
+
+func TestStartRefusesANonPositiveLease(t *testing.T) {
+	_, config := jobTest(t)
+	config.Data["job-lease"] = 0
+
+	var invalid *InvalidParamError
+	if err := Start(context.Background(), config); !errors.As(err, &invalid) || invalid.Name != "job-lease" {
+		t.Fatalf("start: %v, want InvalidParamError for job-lease", err)
+	}
+}
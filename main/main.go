@@ -2,11 +2,26 @@ package main
 
 import (
 	"awesomeTestProject/datastore"
+	"awesomeTestProject/graph"
 	"awesomeTestProject/handlers"
+	"awesomeTestProject/jobs"
+	"awesomeTestProject/models"
+	"awesomeTestProject/openapi"
+	"awesomeTestProject/outbox"
+	"awesomeTestProject/rpc"
+	"awesomeTestProject/scheduler"
+	service "awesomeTestProject/services"
+	"context"
 	"fmt"
 	"github.com/go-zoo/bone"
+	"github.com/graphql-go/graphql"
 	"github.com/rs/cors"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"awesomeTestProject/shared"
 )
@@ -22,27 +37,216 @@ func main() {
 		configs.GetString("database-username"),
 		configs.GetString("database-password"),
 		configs.GetString("database-name"))
+	datastore.Db.Jobs = configs.GetString("jobs-collection")
+	shared.RegisterError(&datastore.NotFoundError{},
+		shared.ErrorMapping{Status: http.StatusNotFound, Code: "not_found", Title: "Resource not found", Log: shared.Info})
 
+	// serverCtx is done on shutdown, which ends the background work and the
+	// requests still running after shutdown-grace seconds
+	serverCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	// streams is done as soon as shutdown starts, event streams never finish
+	// on their own and would hold it for the whole shutdown-grace
+	streams, endStreams := context.WithCancel(serverCtx)
+
+	if err := service.LoadGradingScales(configs); err != nil {
+		fmt.Println("Unable to load the grading scales " + err.Error())
+	}
+	if err := jobs.Start(serverCtx, configs); err != nil {
+		fmt.Println("Unable to start the job workers " + err.Error())
+	}
+	if err := scheduler.Start(serverCtx, configs); err != nil {
+		fmt.Println("Unable to start the scheduler " + err.Error())
+	}
+	if err := outbox.Start(serverCtx, configs); err != nil {
+		fmt.Println("Unable to start the outbox relay " + err.Error())
+	}
+
+	// wrapWithin is wrap for routes that need a deadline other than request-timeout
+	wrapWithin := func(timeout int, handler shared.EndpointHandler) http.HandlerFunc {
+		return shared.Endpoint(shared.InjectRequestScope(shared.Deadline(timeout, shared.ErrorRecovery(shared.AuthHandler(openapi.ValidateRequests(handler))))), configs)
+	}
+	// wrapSCIM is wrap for SCIM routes, which answer errors in the SCIM error schema
+	wrapSCIM := func(timeout int, handler shared.EndpointHandler) http.HandlerFunc {
+		return shared.Endpoint(shared.InjectRequestScope(shared.Deadline(timeout, handlers.SCIMErrors(shared.ErrorRecovery(shared.AuthHandler(openapi.ValidateRequests(handler)))))), configs)
+	}
 	wrap := func(handler shared.EndpointHandler) http.HandlerFunc {
-		return shared.Endpoint(shared.InjectRequestScope(shared.ErrorRecovery(shared.AuthHandler(handler))), configs)
+		return wrapWithin(configs.GetInt("request-timeout"), handler)
+	}
+	wrapStream := func(timeout int, handler shared.EndpointHandler) http.HandlerFunc {
+		return shared.StreamEndpoint(shared.InjectRequestScope(shared.Deadline(timeout, shared.ErrorRecovery(shared.AuthHandler(openapi.ValidateRequests(handler))))), configs)
 	}
 
 	mux := bone.New()
 
 	mux.Prefix("/v1/test")
+	api := openapi.NewRouter(mux, "/v1/test")
+
+	listParams := []openapi.Parameter{
+		{Name: "id"}, {Name: "name"},
+		{Name: "sortfield", Description: "attribute to sort by, meta.created by default"},
+		{Name: "sortorder", Description: "ascending or descending"},
+		{Name: "attributes", Description: "comma separated attributes to return"},
+	}
+	rangeParams := []openapi.Parameter{
+		{Name: "from", Description: "first day, YYYY-MM-DD"},
+		{Name: "to", Description: "last day, YYYY-MM-DD"},
+		{Name: "groupBy", Description: "student, course or date"},
+	}
+	ledgerEntry := openapi.Operation{Request: models.LedgerRequestPayload{}, Response: models.LedgerEntry{}, Status: http.StatusCreated}
+
+	api.Post("/student", wrap(handlers.Idempotent(handlers.PostStudentHandler)),
+		openapi.Operation{Summary: "Create a student", Request: models.Student{}, Response: models.Student{}, Status: http.StatusCreated,
+			Headers: []openapi.Parameter{{Name: "Idempotency-Key", Description: "retries with the same key replay the first response"}}})
+	api.Post("/student/import", wrapWithin(configs.GetInt("import-request-timeout"), handlers.PostStudentImportHandler),
+		openapi.Operation{Summary: "Import students from CSV", Consumes: []string{"text/csv"}, Response: models.ImportJob{},
+			Query: []openapi.Parameter{{Name: "dryRun", Type: "boolean"}, {Name: "delimiter"}, {Name: "mapping", Description: "attribute=Column pairs"}}})
+	api.Get("/student/import/:jobId", wrap(handlers.GetStudentImportHandler),
+		openapi.Operation{Summary: "Get an import job", Response: models.ImportJob{}})
+	api.Put("/student/:id", wrap(handlers.PutStudentHandler),
+		openapi.Operation{Summary: "Replace a student", Request: models.Student{}, Response: models.Student{}})
+	api.Patch("/student/:id", wrap(handlers.PatchStudentHandler),
+		openapi.Operation{Summary: "Patch a student", Request: models.PatchRequestPayload{}, Response: models.Student{}})
+	api.Get("/student", wrap(handlers.GetStudentsHandler),
+		openapi.Operation{Summary: "List students", Query: listParams, Response: []models.Student{}})
+	api.Get("/student/export", wrapStream(configs.GetInt("export-request-timeout"), handlers.ExportStudentsHandler),
+		openapi.Operation{Summary: "Export students", Query: append(listParams, openapi.Parameter{Name: "format", Description: "csv, ndjson or xlsx"}),
+			Produces: []string{"text/csv", "application/x-ndjson", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}})
+	api.Get("/student/events", wrapStream(0, shared.Until(streams, handlers.GetStudentEventsHandler)),
+		openapi.Operation{Summary: "Stream student changes as server-sent events", Query: listParams, Produces: []string{"text/event-stream"},
+			Headers: []openapi.Parameter{{Name: "Last-Event-ID", Description: "resume after the event with this id"}}})
+	api.Get("/student/:id", wrap(handlers.GetStudentByIdHandler),
+		openapi.Operation{Summary: "Get a student", Query: []openapi.Parameter{{Name: "asOf", Description: "RFC3339 timestamp"}}, Response: models.Student{}})
+	api.Delete("/student/:id", wrap(handlers.DeleteStudentHandler),
+		openapi.Operation{Summary: "Delete a student"})
+	api.Get("/student/:id/enrollments", wrap(handlers.GetStudentEnrollmentsHandler),
+		openapi.Operation{Summary: "List the enrollments of a student", Response: []models.Enrollment{}})
+	api.Get("/student/:id/transcript", wrap(handlers.GetTranscriptHandler),
+		openapi.Operation{Summary: "Get the transcript of a student", Response: models.Transcript{}})
+	api.Get("/student/:id/attendance", wrap(handlers.GetStudentAttendanceHandler),
+		openapi.Operation{Summary: "Attendance report of a student", Query: rangeParams, Response: models.AttendanceReport{}})
+	api.Get("/student/:id/versions", wrap(handlers.GetStudentVersionsHandler),
+		openapi.Operation{Summary: "List the versions of a student", Response: models.StudentVersions{}})
+	api.Get("/student/:id/versions/:version", wrap(handlers.GetStudentVersionHandler),
+		openapi.Operation{Summary: "Get a version of a student", Response: models.Student{}})
+	api.Post("/student/:id/versions/:version/revert", wrap(handlers.RevertStudentHandler),
+		openapi.Operation{Summary: "Revert a student to a version", Response: models.Student{}})
+	api.Post("/student/:id/transitions", wrap(handlers.PostTransitionHandler),
+		openapi.Operation{Summary: "Move a student to another status", Request: models.TransitionRequestPayload{}, Response: models.StatusTransition{}, Status: http.StatusCreated})
+	api.Get("/student/:id/transitions", wrap(handlers.GetTransitionsHandler),
+		openapi.Operation{Summary: "List the status transitions of a student", Response: []models.StatusTransition{}})
+	api.Get("/student/:id/ledger", wrap(handlers.GetLedgerHandler),
+		openapi.Operation{Summary: "Get the ledger of a student", Response: models.Ledger{}})
+	api.Post("/student/:id/invoices", wrap(handlers.PostInvoiceHandler), ledgerEntry)
+	api.Post("/student/:id/payments", wrap(handlers.PostPaymentHandler), ledgerEntry)
+	api.Post("/student/:id/refunds", wrap(handlers.PostRefundHandler), ledgerEntry)
+	api.Post("/student/:id/ledger/:entryId/reversal", wrap(handlers.PostReversalHandler), ledgerEntry)
+
+	api.Post("/Bulk", wrapSCIM(configs.GetInt("bulk-request-timeout"), handlers.PostBulkHandler),
+		openapi.Operation{Summary: "Run SCIM bulk operations", Request: models.BulkRequest{}, Response: models.BulkResponse{}})
+
+	api.Get("/jobs/:id", wrap(handlers.GetJobHandler),
+		openapi.Operation{Summary: "Get the status and progress of a background job", Response: models.Job{}})
+
+	api.Post("/webhooks", wrap(handlers.PostWebhookHandler),
+		openapi.Operation{Summary: "Subscribe to student events", Request: models.WebhookSubscriptionPayload{}, Response: models.WebhookSubscription{}, Status: http.StatusCreated})
+	api.Get("/webhooks", wrap(handlers.GetWebhooksHandler),
+		openapi.Operation{Summary: "List webhook subscriptions", Response: []models.WebhookSubscription{}})
+	api.Get("/webhooks/:id", wrap(handlers.GetWebhookHandler),
+		openapi.Operation{Summary: "Get a webhook subscription", Response: models.WebhookSubscription{}})
+	api.Put("/webhooks/:id", wrap(handlers.PutWebhookHandler),
+		openapi.Operation{Summary: "Update a webhook subscription", Request: models.WebhookSubscriptionPayload{}, Response: models.WebhookSubscription{}})
+	api.Delete("/webhooks/:id", wrap(handlers.DeleteWebhookHandler),
+		openapi.Operation{Summary: "Delete a webhook subscription"})
+	api.Get("/webhooks/:id/deliveries", wrap(handlers.GetWebhookDeliveriesHandler),
+		openapi.Operation{Summary: "Delivery log of a webhook subscription", Response: []models.WebhookDelivery{}})
+	api.Post("/webhooks/:id/deliveries/:deliveryId/redeliver", wrap(handlers.PostRedeliveryHandler),
+		openapi.Operation{Summary: "Send a delivery again", Response: models.WebhookDelivery{}, Status: http.StatusAccepted})
+
+	api.Get("/admin/schedules", wrap(handlers.GetSchedulesHandler),
+		openapi.Operation{Summary: "Status and last results of the scheduled routines", Response: []models.Schedule{}})
 
-	mux.Post("/student", wrap(handlers.PostStudentHandler))
-	mux.Put("/student/:id", wrap(handlers.PutStudentHandler))
-	mux.Patch("/student/:id", wrap(handlers.PatchStudentHandler))
-	mux.Get("/student", wrap(handlers.GetStudentsHandler))
-	mux.Get("/student/:id", wrap(handlers.GetStudentByIdHandler))
-	mux.Delete("/student/:id", wrap(handlers.DeleteStudentHandler))
+	api.Post("/course", wrap(handlers.PostCourseHandler),
+		openapi.Operation{Summary: "Create a course", Request: models.Course{}, Response: models.Course{}, Status: http.StatusCreated})
+	api.Get("/course/:id", wrap(handlers.GetCourseByIdHandler),
+		openapi.Operation{Summary: "Get a course", Response: models.Course{}})
+	api.Post("/course/:id/enrollments", wrap(handlers.PostEnrollmentHandler),
+		openapi.Operation{Summary: "Enroll a student", Request: models.Enrollment{}, Response: models.Enrollment{}, Status: http.StatusCreated})
+	api.Get("/course/:id/enrollments", wrap(handlers.GetCourseEnrollmentsHandler),
+		openapi.Operation{Summary: "List the enrollments of a course", Response: []models.Enrollment{}})
+	api.Post("/course/:id/enrollments/:enrollmentId/drop", wrap(handlers.DropEnrollmentHandler),
+		openapi.Operation{Summary: "Drop an enrollment", Response: models.Enrollment{}})
+	api.Post("/course/:id/enrollments/:enrollmentId/withdraw", wrap(handlers.WithdrawEnrollmentHandler),
+		openapi.Operation{Summary: "Withdraw an enrollment", Response: models.Enrollment{}})
+	api.Put("/course/:id/enrollments/:enrollmentId/grade", wrap(handlers.PutGradeHandler),
+		openapi.Operation{Summary: "Record a grade", Request: models.GradeRequestPayload{}, Response: models.Enrollment{}})
+	api.Post("/course/:id/attendance", wrap(handlers.PostAttendanceHandler),
+		openapi.Operation{Summary: "Mark attendance", Request: models.AttendanceBulkPayload{}, Response: []models.Attendance{}})
+	api.Get("/course/:id/attendance", wrap(handlers.GetCourseAttendanceHandler),
+		openapi.Operation{Summary: "Attendance report of a course", Query: rangeParams, Response: models.AttendanceReport{}})
+	api.Get("/course/:id/attendance/alerts", wrap(handlers.GetAttendanceAlertsHandler),
+		openapi.Operation{Summary: "Students under the attendance threshold", Query: rangeParams[:2], Response: models.AttendanceAlert{}})
+
+	root := http.NewServeMux()
+	root.Handle("/v1/graphql", wrap(handlers.GraphQLHandler))
+	openapi.Register(http.MethodPost, "/v1/graphql",
+		openapi.Operation{Summary: "Run a GraphQL query or mutation", Request: graph.Request{}, Response: graphql.Result{}})
+	root.Handle("/openapi.json", wrap(handlers.GetOpenAPIHandler))
+	root.Handle("/docs/", openapi.DocsHandler())
+	root.Handle("/", mux)
+
+	grpcServer := rpc.NewServer(configs)
+	go func() {
+		fmt.Println("Started gRPC on " + configs.GetString("grpc-port"))
+		listener, err := net.Listen("tcp", ":"+configs.GetString("grpc-port"))
+		if err == nil {
+			err = grpcServer.Serve(listener)
+		}
+		if err != nil {
+			fmt.Println("Unable to serve gRPC on that port")
+		}
+	}()
 
 	fmt.Println("Started listening on 8000")
-	handler := cors.AllowAll().Handler(mux)
+	handler := cors.AllowAll().Handler(root)
+
+	server := &http.Server{
+		Addr:        ":8000",
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return serverCtx },
+	}
+	server.RegisterOnShutdown(endStreams)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		fmt.Println("Shutting down")
+		grace, cancel := context.WithTimeout(context.Background(), time.Duration(configs.GetInt("shutdown-grace"))*time.Second)
+		defer cancel()
+		grpcStopped := make(chan struct{})
+		go func() {
+			defer close(grpcStopped)
+			grpcServer.GracefulStop()
+		}()
+		if err := server.Shutdown(grace); err != nil {
+			fmt.Println("Cancelling the requests still running " + err.Error())
+		}
+		select {
+		case <-grpcStopped:
+		case <-grace.Done():
+			fmt.Println("Cancelling the gRPC calls still running")
+			grpcServer.Stop()
+		}
+		stop()
+	}()
 
-	err := http.ListenAndServe(":8000", handler)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		fmt.Println("Unable to listen on that port")
+		return
 	}
+	<-stopped
 }
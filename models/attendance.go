@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+const (
+	AttendancePresent = "present"
+	AttendanceAbsent  = "absent"
+	AttendanceLate    = "late"
+	AttendanceExcused = "excused"
+)
+
+type Attendance struct {
+	Id        string    `json:"id" bson:"id"`
+	StudentId string    `json:"studentId" bson:"studentId"`
+	CourseId  string    `json:"courseId" bson:"courseId"`
+	Date      time.Time `json:"date" bson:"date"`
+	Status    string    `json:"status" bson:"status"`
+	Note      string    `json:"note,omitempty" bson:"note,omitempty"`
+	Meta      Meta      `json:"meta" bson:"meta"`
+}
+
+// AttendanceBulkPayload marks a whole class for one session. Students of the
+// course without an entry in Records get DefaultStatus when it is set.
+type AttendanceBulkPayload struct {
+	CourseId      string             `json:"courseId"`
+	Date          time.Time          `json:"date"`
+	DefaultStatus string             `json:"defaultStatus"`
+	Records       []AttendanceRecord `json:"records"`
+}
+
+type AttendanceRecord struct {
+	StudentId string `json:"studentId"`
+	Status    string `json:"status"`
+	Note      string `json:"note"`
+}
+
+type AttendanceSummary struct {
+	Key     string   `json:"key" bson:"_id"`
+	Total   int      `json:"total" bson:"total"`
+	Present int      `json:"present" bson:"present"`
+	Absent  int      `json:"absent" bson:"absent"`
+	Late    int      `json:"late" bson:"late"`
+	Excused int      `json:"excused" bson:"excused"`
+	Rate    *float64 `json:"rate" bson:"rate"`
+}
+
+type AttendanceReport struct {
+	GroupBy string              `json:"groupBy"`
+	From    *time.Time          `json:"from,omitempty"`
+	To      *time.Time          `json:"to,omitempty"`
+	Rows    []AttendanceSummary `json:"rows"`
+}
+
+type AttendanceAlert struct {
+	CourseId  string              `json:"courseId"`
+	Threshold float64             `json:"threshold"`
+	Students  []AttendanceSummary `json:"students"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Course struct {
+	Id               string    `json:"id" bson:"id"`
+	Name             string    `json:"name" bson:"name"`
+	Term             string    `json:"term" bson:"term"`
+	TermEnd          time.Time `json:"termEnd" bson:"termEnd"`
+	Credits          float64   `json:"credits" bson:"credits"`
+	GradingScale     string    `json:"gradingScale" bson:"gradingScale"`
+	Capacity         int       `json:"capacity" bson:"capacity"`
+	Enrolled         int       `json:"enrolled" bson:"enrolled"`
+	DropDeadline     time.Time `json:"dropDeadline" bson:"dropDeadline"`
+	WithdrawDeadline time.Time `json:"withdrawDeadline" bson:"withdrawDeadline"`
+	Meta             Meta      `json:"meta" bson:"meta"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	EnrollmentEnrolled   = "enrolled"
+	EnrollmentWaitlisted = "waitlisted"
+	EnrollmentDropped    = "dropped"
+	EnrollmentWithdrawn  = "withdrawn"
+)
+
+type Enrollment struct {
+	Id           string        `json:"id" bson:"id"`
+	StudentId    string        `json:"studentId" bson:"studentId"`
+	CourseId     string        `json:"courseId" bson:"courseId"`
+	Status       string        `json:"status" bson:"status"`
+	Active       bool          `json:"-" bson:"active"`
+	EnrolledDate *time.Time    `json:"enrolledDate,omitempty" bson:"enrolledDate,omitempty"`
+	DropDate     *time.Time    `json:"dropDate,omitempty" bson:"dropDate,omitempty"`
+	WithdrawDate *time.Time    `json:"withdrawDate,omitempty" bson:"withdrawDate,omitempty"`
+	Grade        *Grade        `json:"grade,omitempty" bson:"grade,omitempty"`
+	GradeHistory []GradeChange `json:"gradeHistory,omitempty" bson:"gradeHistory,omitempty"`
+	Meta         Meta          `json:"meta" bson:"meta"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+const (
+	ScaleLetter     = "letter"
+	ScalePercentage = "percentage"
+	ScalePassFail   = "passfail"
+)
+
+// GradingScale maps a mark or a percentage onto grade points. Bands are
+// ordered from the highest to the lowest MinPercent.
+type GradingScale struct {
+	Name  string      `json:"name" bson:"name"`
+	Type  string      `json:"type" bson:"type"`
+	Bands []GradeBand `json:"bands" bson:"bands"`
+}
+
+type GradeBand struct {
+	Mark       string  `json:"mark" bson:"mark"`
+	MinPercent float64 `json:"minPercent" bson:"minPercent"`
+	Points     float64 `json:"points" bson:"points"`
+	Passing    bool    `json:"passing" bson:"passing"`
+}
+
+type Grade struct {
+	Scale   string   `json:"scale" bson:"scale"`
+	Mark    string   `json:"mark" bson:"mark"`
+	Percent *float64 `json:"percent,omitempty" bson:"percent,omitempty"`
+	Points  *float64 `json:"points,omitempty" bson:"points,omitempty"`
+	Passing bool     `json:"passing" bson:"passing"`
+}
+
+type GradeChange struct {
+	Grade     Grade     `json:"grade" bson:"grade"`
+	Previous  *Grade    `json:"previous,omitempty" bson:"previous,omitempty"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	ChangedBy string    `json:"changedBy,omitempty" bson:"changedBy,omitempty"`
+	ChangedAt time.Time `json:"changedAt" bson:"changedAt"`
+}
+
+type GradeRequestPayload struct {
+	Mark    string   `json:"mark"`
+	Percent *float64 `json:"percent"`
+	Reason  string   `json:"reason"`
+}
+
+type Transcript struct {
+	StudentId     string           `json:"studentId"`
+	Terms         []TranscriptTerm `json:"terms"`
+	Credits       float64          `json:"credits"`
+	CumulativeGPA *float64         `json:"cumulativeGpa"`
+}
+
+type TranscriptTerm struct {
+	Term    string             `json:"term"`
+	Courses []TranscriptCourse `json:"courses"`
+	Credits float64            `json:"credits"`
+	GPA     *float64           `json:"gpa"`
+}
+
+type TranscriptCourse struct {
+	CourseId string  `json:"courseId"`
+	Name     string  `json:"name"`
+	Credits  float64 `json:"credits"`
+	Status   string  `json:"status"`
+	Grade    *Grade  `json:"grade,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+const (
+	IdempotencyPending   = "pending"
+	IdempotencyCompleted = "completed"
+)
+
+// IdempotencyRecord is the response stored for an Idempotency-Key. Keys are
+// scoped to the caller, the id joins both. Fingerprint identifies the request
+// the key was first used with. A pending record is locked by the request
+// processing it until LockedUntil, after which a retry may take it over.
+type IdempotencyRecord struct {
+	Id             string            `json:"id" bson:"_id"`
+	Key            string            `json:"key" bson:"key"`
+	Caller         string            `json:"caller" bson:"caller"`
+	Fingerprint    string            `json:"fingerprint" bson:"fingerprint"`
+	Status         string            `json:"status" bson:"status"`
+	ResponseStatus int               `json:"responseStatus,omitempty" bson:"responseStatus,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	Body           []byte            `json:"body,omitempty" bson:"body,omitempty"`
+	LockedUntil    time.Time         `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	ExpiresAt      time.Time         `json:"expiresAt" bson:"expiresAt"`
+	Meta           Meta              `json:"meta" bson:"meta"`
+}
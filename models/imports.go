@@ -0,0 +1,40 @@
+package models
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+type ImportJob struct {
+	Id            string           `json:"id" bson:"id"`
+	Status        string           `json:"status" bson:"status"`
+	DryRun        bool             `json:"dryRun" bson:"dryRun"`
+	TotalRows     int              `json:"totalRows" bson:"totalRows"`
+	ProcessedRows int              `json:"processedRows" bson:"processedRows"`
+	Imported      int              `json:"imported" bson:"imported"`
+	Failed        int              `json:"failed" bson:"failed"`
+	Errors        []ImportRowError `json:"errors" bson:"-"`
+	Detail        string           `json:"detail,omitempty" bson:"detail,omitempty"`
+	Meta          Meta             `json:"meta" bson:"meta"`
+}
+
+// ImportUpload is the uploaded CSV of a background import with the options
+// to read it, kept apart from the job until the import is done.
+type ImportUpload struct {
+	ImportJobId string            `bson:"importJobId"`
+	Body        []byte            `bson:"body"`
+	Delimiter   rune              `bson:"delimiter"`
+	Mapping     map[string]string `bson:"mapping"`
+}
+
+// ImportRowError reports why a row was rejected. Row is the line number in
+// the uploaded file, the header being line 1. The errors of an import are
+// stored apart from it, by ImportJobId.
+type ImportRowError struct {
+	ImportJobId string `json:"-" bson:"importJobId"`
+	Row         int    `json:"row" bson:"row"`
+	Column      string `json:"column,omitempty" bson:"column,omitempty"`
+	Detail      string `json:"detail" bson:"detail"`
+}
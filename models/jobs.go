@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JobDead is the status of a job that failed every attempt. Dead jobs are
+// kept for inspection until they expire and never claimed again.
+const JobDead = "dead"
+
+// Job is a unit of background work in the jobs collection. A worker holds
+// the lease on a running job until LeaseUntil and extends it while running,
+// a job whose lease ran out is claimed again by another worker.
+type Job struct {
+	Id          string          `json:"id" bson:"id"`
+	Type        string          `json:"type" bson:"type"`
+	Payload     json.RawMessage `json:"payload,omitempty" bson:"payload,omitempty"`
+	Status      string          `json:"status" bson:"status"`
+	Progress    JobProgress     `json:"progress" bson:"progress"`
+	Attempts    int             `json:"attempts" bson:"attempts"`
+	MaxAttempts int             `json:"maxAttempts" bson:"maxAttempts"`
+	LastError   string          `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	RunAt       time.Time       `json:"runAt" bson:"runAt"`
+	LeaseOwner  string          `json:"-" bson:"leaseOwner,omitempty"`
+	LeaseUntil  time.Time       `json:"-" bson:"leaseUntil,omitempty"`
+	Meta        Meta            `json:"meta" bson:"meta"`
+	// ExpiresAt is set once the job completed or is dead, mongo removes the
+	// job after.
+	ExpiresAt time.Time `json:"-" bson:"expiresAt,omitempty"`
+}
+
+type JobProgress struct {
+	Completed int    `json:"completed" bson:"completed"`
+	Total     int    `json:"total" bson:"total"`
+	Detail    string `json:"detail,omitempty" bson:"detail,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+const (
+	LedgerInvoice  = "invoice"
+	LedgerPayment  = "payment"
+	LedgerRefund   = "refund"
+	LedgerReversal = "reversal"
+)
+
+const (
+	LedgerPending  = "pending"
+	LedgerPosted   = "posted"
+	LedgerDeclined = "declined"
+)
+
+// LedgerEntry is an immutable line of a student's fees ledger. Amount is in
+// minor currency units and always positive, Delta is the signed effect of the
+// entry on the balance the student owes. Only posted entries count towards
+// the balance. A payment or refund is recorded pending before the gateway is
+// asked, and its outcome, posted or declined, as another entry whose
+// AttemptId is the pending one. Sequence numbers the refunds of a payment.
+type LedgerEntry struct {
+	Id          string `json:"id" bson:"id"`
+	StudentId   string `json:"studentId" bson:"studentId"`
+	Type        string `json:"type" bson:"type"`
+	Status      string `json:"status" bson:"status"`
+	Amount      int64  `json:"amount" bson:"amount"`
+	Delta       int64  `json:"delta" bson:"delta"`
+	Currency    string `json:"currency" bson:"currency"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Reference   string `json:"reference,omitempty" bson:"reference,omitempty"`
+	PaymentId   string `json:"paymentId,omitempty" bson:"paymentId,omitempty"`
+	ReversesId  string `json:"reversesId,omitempty" bson:"reversesId,omitempty"`
+	AttemptId   string `json:"attemptId,omitempty" bson:"attemptId,omitempty"`
+	Sequence    int64  `json:"sequence,omitempty" bson:"sequence,omitempty"`
+	Meta        Meta   `json:"meta" bson:"meta"`
+}
+
+type LedgerRequestPayload struct {
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Description string `json:"description"`
+	Token       string `json:"token"`
+	PaymentId   string `json:"paymentId"`
+}
+
+type Ledger struct {
+	StudentId string        `json:"studentId"`
+	Currency  string        `json:"currency"`
+	Balance   int64         `json:"balance"`
+	Entries   []LedgerEntry `json:"entries"`
+}
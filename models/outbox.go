@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxPending   = "pending"
+	OutboxPublished = "published"
+)
+
+// Event is a domain event as the outbox relay hands it to the sinks.
+// Sequence numbers all events in the order they were committed, Version
+// numbers the events of the aggregate. Data is the aggregate as it was after
+// the change.
+type Event struct {
+	Id          string          `json:"id" bson:"id"`
+	Type        string          `json:"type" bson:"type"`
+	AggregateId string          `json:"aggregateId" bson:"aggregateId"`
+	Sequence    int64           `json:"sequence" bson:"sequence"`
+	Version     int64           `json:"version" bson:"version"`
+	Occurred    time.Time       `json:"occurred" bson:"occurred"`
+	Data        json.RawMessage `json:"data" bson:"data"`
+}
+
+// OutboxEntry is an event waiting in the outbox collection to be published.
+type OutboxEntry struct {
+	Event       `bson:",inline"`
+	Status      string    `json:"status" bson:"status"`
+	Attempts    int       `json:"attempts" bson:"attempts"`
+	LastError   string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	PublishedAt time.Time `json:"publishedAt,omitempty" bson:"publishedAt,omitempty"`
+	// ExpiresAt is set once published, mongo removes the entry after.
+	ExpiresAt time.Time `json:"-" bson:"expiresAt,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	// ScheduleSkip runs only the latest missed tick of a schedule.
+	ScheduleSkip = "skip"
+	// ScheduleCatchUp runs every missed tick of a schedule, oldest first.
+	ScheduleCatchUp = "catchup"
+)
+
+// Schedule is the state of a recurring routine shared by every replica.
+// NextRun is the next tick to run, the replica holding the lease runs it.
+type Schedule struct {
+	Name       string       `json:"name" bson:"name"`
+	Expression string       `json:"expression" bson:"expression"`
+	Policy     string       `json:"policy" bson:"policy"`
+	NextRun    time.Time    `json:"nextRun" bson:"nextRun"`
+	Running    bool         `json:"running" bson:"-"`
+	LeaseOwner string       `json:"-" bson:"leaseOwner,omitempty"`
+	LeaseUntil time.Time    `json:"-" bson:"leaseUntil,omitempty"`
+	LastRun    *ScheduleRun `json:"lastRun,omitempty" bson:"lastRun,omitempty"`
+	Meta       Meta         `json:"meta" bson:"meta"`
+}
+
+// ScheduleRun is the result of the last run of a schedule. Skipped counts
+// the missed ticks left out by the skip policy or the catch-up limit.
+type ScheduleRun struct {
+	Tick     time.Time `json:"tick" bson:"tick"`
+	Started  time.Time `json:"started" bson:"started"`
+	Finished time.Time `json:"finished" bson:"finished"`
+	Status   string    `json:"status" bson:"status"`
+	Error    string    `json:"error,omitempty" bson:"error,omitempty"`
+	Skipped  int       `json:"skipped" bson:"skipped"`
+}
@@ -1,7 +1,26 @@
 package models
 
+import "time"
+
 type Student struct {
-	Id   string `json:"id" bson:"id"`
-	Name string `json:"name" bson:"name"`
-	Meta Meta   `json:"meta" bson:"meta"`
+	Id               string `json:"id" bson:"id"`
+	Name             string `json:"name" bson:"name"`
+	EnrollmentNumber string `json:"enrollmentNumber,omitempty" bson:"enrollmentNumber,omitempty"`
+	Status           string `json:"status" bson:"status"`
+	Meta             Meta   `json:"meta" bson:"meta"`
+}
+
+type StatusTransition struct {
+	Id        string    `json:"id" bson:"id"`
+	StudentId string    `json:"studentId" bson:"studentId"`
+	From      string    `json:"from" bson:"from"`
+	To        string    `json:"to" bson:"to"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	By        string    `json:"by" bson:"by"`
+	At        time.Time `json:"at" bson:"at"`
+}
+
+type TransitionRequestPayload struct {
+	To     string `json:"to"`
+	Reason string `json:"reason"`
 }
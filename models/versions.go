@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// StudentVersion is a superseded version of a student. It was the current
+// version from ValidFrom until ValidTo, when SupersededBy replaced or deleted
+// it.
+type StudentVersion struct {
+	StudentId    string    `json:"studentId" bson:"studentId"`
+	Version      string    `json:"version" bson:"version"`
+	ValidFrom    time.Time `json:"validFrom" bson:"validFrom"`
+	ValidTo      time.Time `json:"validTo" bson:"validTo"`
+	SupersededBy string    `json:"supersededBy" bson:"supersededBy"`
+	Student      Student   `json:"student" bson:"student"`
+}
+
+type StudentVersions struct {
+	StudentId string           `json:"studentId"`
+	Current   *Student         `json:"current,omitempty"`
+	Versions  []StudentVersion `json:"versions"`
+}
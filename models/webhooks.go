@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventStudentCreated = "student.created"
+	EventStudentUpdated = "student.updated"
+	EventStudentDeleted = "student.deleted"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSubscription is an endpoint notified of the listed event types.
+// Secret signs the deliveries, it is only returned when the subscription is
+// created. Endpoints failing DisableAfter attempts in a row are disabled.
+type WebhookSubscription struct {
+	Id                  string    `json:"id" bson:"id"`
+	Url                 string    `json:"url" bson:"url"`
+	Events              []string  `json:"events" bson:"events"`
+	Secret              string    `json:"secret,omitempty" bson:"secret"`
+	Active              bool      `json:"active" bson:"active"`
+	ConsecutiveFailures int       `json:"consecutiveFailures" bson:"consecutiveFailures"`
+	DisabledReason      string    `json:"disabledReason,omitempty" bson:"disabledReason,omitempty"`
+	DisabledAt          time.Time `json:"disabledAt,omitempty" bson:"disabledAt,omitempty"`
+	Meta                Meta      `json:"meta" bson:"meta"`
+}
+
+type WebhookSubscriptionPayload struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// WebhookEvent is the body posted to the subscribed endpoints.
+type WebhookEvent struct {
+	Id       string      `json:"id"`
+	Type     string      `json:"type"`
+	Occurred time.Time   `json:"occurred"`
+	Data     interface{} `json:"data"`
+}
+
+// WebhookDelivery is the log of one event sent to one subscription, with
+// every attempt made.
+type WebhookDelivery struct {
+	Id             string            `json:"id" bson:"id"`
+	SubscriptionId string            `json:"subscriptionId" bson:"subscriptionId"`
+	EventId        string            `json:"eventId" bson:"eventId"`
+	EventType      string            `json:"eventType" bson:"eventType"`
+	Payload        json.RawMessage   `json:"payload" bson:"payload"`
+	Status         string            `json:"status" bson:"status"`
+	Attempts       []DeliveryAttempt `json:"attempts" bson:"attempts"`
+	Meta           Meta              `json:"meta" bson:"meta"`
+}
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	Duration   string    `json:"duration" bson:"duration"`
+}
@@ -0,0 +1,23 @@
+package openapi
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// docsFiles is the documentation UI with a pinned swagger-ui, see
+// docs/README.md, so it is served without fetching anything elsewhere.
+//
+//go:embed docs
+var docsFiles embed.FS
+
+// DocsHandler serves the documentation UI for the document at /openapi.json.
+// It is mounted at /docs/.
+func DocsHandler() http.Handler {
+	files, err := fs.Sub(docsFiles, "docs")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/docs/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,39 @@
+package openapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDocsAreServedWithTheirAssets(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/docs/", DocsHandler())
+
+	for path, contentType := range map[string]string{
+		"/docs/":                     "text/html",
+		"/docs/swagger-ui-bundle.js": "javascript",
+		"/docs/swagger-ui.css":       "text/css",
+		"/docs/docs.js":              "javascript",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), contentType) {
+			t.Fatalf("GET %s = %d %s, want 200 %s", path, rec.Code, rec.Header().Get("Content-Type"), contentType)
+		}
+		if path == "/docs/" {
+			page, _ := ioutil.ReadAll(rec.Body)
+			if strings.Contains(string(page), "://") {
+				t.Fatalf("docs page loads from another origin:\n%s", page)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/docs/" {
+		t.Fatalf("GET /docs = %d to %s, want redirected to /docs/", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,51 @@
+package openapi
+
+import (
+	"net/http"
+
+	"github.com/go-zoo/bone"
+)
+
+// Router registers routes on the mux and records them for the document in
+// one step, so a route cannot be added without being described.
+type Router struct {
+	mux    *bone.Mux
+	prefix string
+}
+
+func NewRouter(mux *bone.Mux, prefix string) *Router {
+	return &Router{mux: mux, prefix: prefix}
+}
+
+func (r *Router) Get(path string, handler http.Handler, doc ...Operation) {
+	r.mux.Get(path, handler)
+	r.register(http.MethodGet, path, doc)
+}
+
+func (r *Router) Post(path string, handler http.Handler, doc ...Operation) {
+	r.mux.Post(path, handler)
+	r.register(http.MethodPost, path, doc)
+}
+
+func (r *Router) Put(path string, handler http.Handler, doc ...Operation) {
+	r.mux.Put(path, handler)
+	r.register(http.MethodPut, path, doc)
+}
+
+func (r *Router) Patch(path string, handler http.Handler, doc ...Operation) {
+	r.mux.Patch(path, handler)
+	r.register(http.MethodPatch, path, doc)
+}
+
+func (r *Router) Delete(path string, handler http.Handler, doc ...Operation) {
+	r.mux.Delete(path, handler)
+	r.register(http.MethodDelete, path, doc)
+}
+
+func (r *Router) register(method, path string, doc []Operation) {
+	var operation Operation
+	if len(doc) > 0 {
+		operation = doc[0]
+	}
+	Register(method, r.prefix+path, operation)
+}
@@ -0,0 +1,266 @@
+// Package openapi describes the registered routes as an OpenAPI 3.1 document.
+// Routes are recorded as they are registered through a Router, so the
+// document follows main.go without being maintained by hand.
+package openapi
+
+import (
+	"awesomeTestProject/shared"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operation documents a route. Everything is optional, a route registered
+// without documentation still shows up with its path parameters and the
+// error responses.
+type Operation struct {
+	Summary     string
+	Description string
+	Query       []Parameter
+	Headers     []Parameter
+	// Request and Response are values of the types the route reads and
+	// writes, their schemas are generated from the struct definitions.
+	Request  interface{}
+	Response interface{}
+	// Status is the success status, 200 when zero.
+	Status int
+	// Consumes and Produces replace the negotiated media types for routes
+	// that read or write raw bodies, like imports and exports.
+	Consumes []string
+	Produces []string
+}
+
+type Parameter struct {
+	Name        string
+	Description string
+	// Type is the schema type of the value, string when empty.
+	Type     string
+	Required bool
+}
+
+type route struct {
+	method    string
+	path      string
+	operation Operation
+}
+
+var (
+	routesLock sync.RWMutex
+	routes     []route
+)
+
+// Register records a route for the document.
+func Register(method, path string, operation Operation) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	routes = append(routes, route{method, path, operation})
+}
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type PathItem map[string]*OperationObject
+
+type OperationObject struct {
+	OperationId string                    `json:"operationId"`
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Parameters  []ParameterObject         `json:"parameters,omitempty"`
+	RequestBody *RequestBody              `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseObject `json:"responses"`
+}
+
+type ParameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type ResponseObject struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+var pathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// Build describes every route registered so far.
+func Build(title, version string) *Document {
+	routesLock.RLock()
+	defer routesLock.RUnlock()
+
+	schemas := newSchemaGenerator()
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]PathItem{},
+	}
+	problemSchema := schemas.schemaOf(shared.Problem{})
+
+	operationIds := map[string]int{}
+	for _, r := range routes {
+		path := pathParam.ReplaceAllString(r.path, "{$1}")
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = PathItem{}
+			doc.Paths[path] = item
+		}
+
+		op := &OperationObject{
+			Summary:     r.operation.Summary,
+			Description: r.operation.Description,
+			Tags:        tags(r.path),
+			Responses:   map[string]ResponseObject{},
+		}
+		op.OperationId = operationId(r.method, r.path)
+		if n := operationIds[op.OperationId]; n > 0 {
+			operationIds[op.OperationId]++
+			op.OperationId += strconv.Itoa(n + 1)
+		} else {
+			operationIds[op.OperationId] = 1
+		}
+
+		for _, m := range pathParam.FindAllStringSubmatch(r.path, -1) {
+			op.Parameters = append(op.Parameters, ParameterObject{
+				Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"},
+			})
+		}
+		for _, q := range r.operation.Query {
+			op.Parameters = append(op.Parameters, parameterObject(q, "query"))
+		}
+		for _, h := range r.operation.Headers {
+			op.Parameters = append(op.Parameters, parameterObject(h, "header"))
+		}
+
+		if r.operation.Request != nil {
+			op.RequestBody = &RequestBody{Required: true, Content: content(shared.SupportedMediaTypes(), schemas.schemaOf(r.operation.Request))}
+		} else if len(r.operation.Consumes) > 0 {
+			op.RequestBody = &RequestBody{Required: true, Content: content(r.operation.Consumes, &Schema{Type: "string", Format: "binary"})}
+		}
+
+		status := r.operation.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := ResponseObject{Description: http.StatusText(status)}
+		if r.operation.Response != nil {
+			success.Content = content(shared.SupportedMediaTypes(), schemas.schemaOf(r.operation.Response))
+		} else if len(r.operation.Produces) > 0 {
+			success.Content = content(r.operation.Produces, &Schema{Type: "string", Format: "binary"})
+		}
+		op.Responses[strconv.Itoa(status)] = success
+
+		for _, code := range errorStatuses(r) {
+			op.Responses[strconv.Itoa(code)] = ResponseObject{
+				Description: http.StatusText(code),
+				Content:     content([]string{shared.MediaTypeProblemJSON}, problemSchema),
+			}
+		}
+
+		item[strings.ToLower(r.method)] = op
+	}
+
+	doc.Components.Schemas = schemas.components
+	return doc
+}
+
+// errorStatuses lists the error responses a route can answer with through
+// ErrorRecovery and the endpoint.
+func errorStatuses(r route) []int {
+	codes := []int{http.StatusInternalServerError}
+	if len(r.operation.Produces) == 0 {
+		codes = append(codes, http.StatusNotAcceptable)
+	}
+	if strings.Contains(r.path, ":") {
+		codes = append(codes, http.StatusNotFound)
+	}
+	if r.operation.Request != nil || len(r.operation.Consumes) > 0 || len(r.operation.Query) > 0 {
+		codes = append(codes, http.StatusBadRequest)
+	}
+	if r.operation.Request != nil {
+		codes = append(codes, http.StatusUnsupportedMediaType)
+	}
+	for _, h := range r.operation.Headers {
+		if h.Name == "Idempotency-Key" {
+			codes = append(codes, http.StatusUnprocessableEntity)
+		}
+	}
+	switch r.method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		codes = append(codes, http.StatusConflict)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+func parameterObject(p Parameter, in string) ParameterObject {
+	t := p.Type
+	if t == "" {
+		t = "string"
+	}
+	return ParameterObject{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: &Schema{Type: t}}
+}
+
+func content(mediaTypes []string, schema *Schema) map[string]MediaType {
+	c := make(map[string]MediaType, len(mediaTypes))
+	for _, m := range mediaTypes {
+		c[m] = MediaType{Schema: schema}
+	}
+	return c
+}
+
+func tags(path string) []string {
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" && !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "v1") && segment != "test" {
+			return []string{segment}
+		}
+	}
+	return nil
+}
+
+// operationId is the method followed by the static path segments, and the
+// last parameter when the path ends with one. POST
+// /v1/test/student/:id/transitions is postStudentTransitions and GET
+// /v1/test/student/:id is getStudentById.
+func operationId(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	segments := strings.Split(path, "/")
+	for _, segment := range segments {
+		if segment == "" || strings.HasPrefix(segment, ":") || segment == "v1" || segment == "test" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	if last := segments[len(segments)-1]; strings.HasPrefix(last, ":") && len(last) > 1 {
+		b.WriteString("By" + strings.ToUpper(last[1:2]) + last[2:])
+	}
+	return b.String()
+}
@@ -0,0 +1,360 @@
+package openapi
+
+import (
+	"awesomeTestProject/shared"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	contractLock   sync.Mutex
+	contract       *Document
+	contractRoutes int
+)
+
+// currentContract is the document for the routes registered so far, built
+// again only when routes were added since.
+func currentContract() *Document {
+	routesLock.RLock()
+	n := len(routes)
+	routesLock.RUnlock()
+
+	contractLock.Lock()
+	defer contractLock.Unlock()
+	if contract == nil || contractRoutes != n {
+		contract = Build("", "")
+		contractRoutes = n
+	}
+	return contract
+}
+
+// matchRoute finds the registered route for a request the way bone does, the
+// first route in registration order whose segments match, and returns the
+// values of its path parameters.
+func matchRoute(method, path string) (*route, map[string]string) {
+	routesLock.RLock()
+	defer routesLock.RUnlock()
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range routes {
+		r := &routes[i]
+		if r.method != method {
+			continue
+		}
+		pattern := strings.Split(strings.Trim(r.path, "/"), "/")
+		if len(pattern) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		matched := true
+		for j, p := range pattern {
+			if strings.HasPrefix(p, ":") {
+				params[p[1:]] = segments[j]
+			} else if p != segments[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+// ValidateRequests checks path parameters, query parameters, headers and the
+// body of each request against the OpenAPI document before next runs, when
+// validate-requests is enabled. Violations are reported as InvalidParamError
+// for parameters and InvalidTypeError for the body. Xml bodies carry no types
+// and are not checked. With validate-responses enabled, meant for tests, a
+// response that does not match the document fails the request so drifting
+// handlers are noticed.
+func ValidateRequests(next shared.EndpointHandler) shared.EndpointHandler {
+	return func(req shared.HttpWebRequest, ctx context.Context, config *shared.MapPropertySource) *shared.ResponseOut {
+		validateRequests := config.GetBool("validate-requests")
+		validateResponses := config.GetBool("validate-responses")
+		if !validateRequests && !validateResponses {
+			return next(req, ctx, config)
+		}
+
+		r, params := matchRoute(req.Method(), req.Raw().URL.Path)
+		if r == nil {
+			return next(req, ctx, config)
+		}
+		op := currentContract().Paths[pathParam.ReplaceAllString(r.path, "{$1}")][strings.ToLower(r.method)]
+		v := &validator{components: currentContract().Components.Schemas}
+
+		if validateRequests {
+			shared.ErrorCheck(v.parameters(op, req, params))
+			shared.ErrorCheck(v.body(op, req, config))
+		}
+
+		resp := next(req, ctx, config)
+
+		if validateResponses {
+			if err := v.response(op, resp); err != nil {
+				shared.Fatal(ctx, fmt.Sprintf("Response of %s %s drifts from the contract: %s", r.method, r.path, err.Error()))
+				panic(shared.Error.Text("response of %s %s drifts from the contract: %s", r.method, r.path, err.Error()))
+			}
+		}
+		return resp
+	}
+}
+
+type validator struct {
+	components map[string]*Schema
+}
+
+func (v *validator) parameters(op *OperationObject, req shared.HttpWebRequest, pathParams map[string]string) error {
+	var errs []error
+	query := req.Raw().URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = pathParams[p.Name]
+			present = value != ""
+		case "query":
+			_, present = query[p.Name]
+			value = query.Get(p.Name)
+		case "header":
+			value = req.Header(p.Name)
+			present = value != ""
+		}
+
+		if !present {
+			if p.Required {
+				errs = append(errs, shared.Error.InvalidParam(p.Name, "a value", "nothing"))
+			}
+			continue
+		}
+		if err := checkParameter(p.Schema.Type, value); err != nil {
+			errs = append(errs, shared.Error.InvalidParam(p.Name, p.Schema.Type, value))
+		}
+	}
+	return shared.Error.Validation(errs)
+}
+
+func checkParameter(t, value string) error {
+	var err error
+	switch t {
+	case "boolean":
+		_, err = strconv.ParseBool(value)
+	case "integer":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "number":
+		_, err = strconv.ParseFloat(value, 64)
+	}
+	return err
+}
+
+// body checks the request body, which is read ahead and put back for the
+// handler. Bodies over validate-max-body-size are passed on unchecked.
+func (v *validator) body(op *OperationObject, req shared.HttpWebRequest, config *shared.MapPropertySource) error {
+	if op.RequestBody == nil || req.Raw().Body == nil {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header("Content-Type"))
+	if mediaType == "" {
+		mediaType = shared.MediaTypeJSON
+	}
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		supported := make([]string, 0, len(op.RequestBody.Content))
+		for m := range op.RequestBody.Content {
+			supported = append(supported, m)
+		}
+		return shared.Error.UnsupportedMediaType(req.Header("Content-Type"), supported)
+	}
+	if content.Schema.Format == "binary" || mediaType == shared.MediaTypeXML {
+		return nil
+	}
+
+	limit := int64(config.GetInt("validate-max-body-size"))
+	raw := req.Raw()
+	b, err := ioutil.ReadAll(io.LimitReader(raw.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > limit {
+		raw.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(b), raw.Body))
+		return nil
+	}
+	raw.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var value interface{}
+	if mediaType == shared.MediaTypeMsgpack {
+		codec, err := shared.CodecFor(mediaType)
+		if err != nil {
+			return err
+		}
+		if err := codec.Unmarshal(b, &value); err != nil {
+			return shared.Error.InvalidType("", "a "+mediaType+" document", err.Error())
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			return shared.Error.InvalidType("", "a JSON document", err.Error())
+		}
+	}
+	return shared.Error.Validation(v.check(content.Schema, value, ""))
+}
+
+func (v *validator) response(op *OperationObject, resp *shared.ResponseOut) error {
+	status := strconv.Itoa(resp.GetStatus())
+	documented, ok := op.Responses[status]
+	if !ok {
+		return fmt.Errorf("status %s is not documented", status)
+	}
+	entity := resp.GetEntity()
+	if entity == nil || documented.Content[shared.MediaTypeJSON].Schema == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	return shared.Error.Validation(v.check(documented.Content[shared.MediaTypeJSON].Schema, value, ""))
+}
+
+// check lists the violations of the schema by a decoded value. Nulls are
+// accepted everywhere, the models use them for absent optional attributes.
+func (v *validator) check(schema *Schema, value interface{}, path string) []error {
+	if schema.Ref != "" {
+		resolved, ok := v.components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return nil
+		}
+		schema = resolved
+	}
+	if value == nil || schema.Type == "" {
+		return nil
+	}
+
+	got := kind(value)
+	invalid := []error{shared.Error.InvalidType(path, schema.Type, got)}
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid
+		}
+		var errs []error
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if s, ok := schema.Properties[name]; ok {
+				errs = append(errs, v.check(s, obj[name], join(path, name))...)
+			} else if schema.AdditionalProperties != nil {
+				errs = append(errs, v.check(schema.AdditionalProperties, obj[name], join(path, name))...)
+			}
+		}
+		return errs
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return invalid
+		}
+		var errs []error
+		for i, item := range arr {
+			errs = append(errs, v.check(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return invalid
+		}
+		switch schema.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []error{shared.Error.InvalidType(path, "an RFC3339 date-time", s)}
+			}
+		case "byte":
+			if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+				return []error{shared.Error.InvalidType(path, "base64", s)}
+			}
+		}
+	case "integer":
+		if got != "number" || !integral(value) {
+			return invalid
+		}
+	case "number":
+		if got != "number" {
+			return invalid
+		}
+	case "boolean":
+		if got != "boolean" {
+			return invalid
+		}
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func kind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+func integral(value interface{}) bool {
+	switch n := value.(type) {
+	case json.Number:
+		_, err := n.Int64()
+		return err == nil
+	case float32:
+		return float32(int64(n)) == n
+	case float64:
+		return float64(int64(n)) == n
+	}
+	return true
+}
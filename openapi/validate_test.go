@@ -0,0 +1,166 @@
+package openapi
+
+import (
+	"awesomeTestProject/shared"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type gadget struct {
+	Name    string    `json:"name"`
+	Count   int       `json:"count"`
+	Created time.Time `json:"created"`
+}
+
+func init() {
+	Register(http.MethodPost, "/v1/test/gadget/:id", Operation{
+		Query:    []Parameter{{Name: "limit", Type: "integer"}},
+		Headers:  []Parameter{{Name: "X-Tenant", Required: true}},
+		Request:  gadget{},
+		Response: gadget{},
+		Status:   http.StatusCreated,
+	})
+}
+
+func validateConfig(requests, responses bool) *shared.MapPropertySource {
+	return &shared.MapPropertySource{Data: map[string]interface{}{
+		"validate-requests":      requests,
+		"validate-responses":     responses,
+		"validate-max-body-size": 1024,
+	}}
+}
+
+// serve posts body to target through ValidateRequests into next and returns
+// the response, or the error it panicked with.
+func serve(next shared.EndpointHandler, target, body string, config *shared.MapPropertySource) (*shared.ResponseOut, error) {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", shared.MediaTypeJSON)
+	req.Header.Set("X-Tenant", "t1")
+	return serveRequest(next, req, config)
+}
+
+func serveRequest(next shared.EndpointHandler, req *http.Request, config *shared.MapPropertySource) (resp *shared.ResponseOut, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	return ValidateRequests(next)(shared.HttpWebRequest{Req: req}, context.Background(), config), nil
+}
+
+func created(entity interface{}) shared.EndpointHandler {
+	return func(req shared.HttpWebRequest, ctx context.Context, config *shared.MapPropertySource) *shared.ResponseOut {
+		return shared.NewResponseOut().Status(http.StatusCreated).Entity(entity)
+	}
+}
+
+const validGadget = `{"name":"cog","count":3,"created":"2026-10-19T10:00:00Z"}`
+
+func TestValidRequestReachesTheHandler(t *testing.T) {
+	var got gadget
+	handler := func(req shared.HttpWebRequest, ctx context.Context, config *shared.MapPropertySource) *shared.ResponseOut {
+		if err := req.Decode(&got); err != nil {
+			t.Fatalf("decode the body put back by the validator: %v", err)
+		}
+		return shared.NewResponseOut().Status(http.StatusCreated).Entity(got)
+	}
+
+	resp, err := serve(handler, "/v1/test/gadget/1?limit=5", validGadget, validateConfig(true, true))
+	if err != nil {
+		t.Fatalf("valid request refused: %v", err)
+	}
+	if resp.GetStatus() != http.StatusCreated || got.Name != "cog" {
+		t.Fatalf("status = %d, body = %+v, want the handler to see the request", resp.GetStatus(), got)
+	}
+}
+
+func TestInvalidQueryParameter(t *testing.T) {
+	_, err := serve(created(gadget{}), "/v1/test/gadget/1?limit=many", validGadget, validateConfig(true, false))
+	var invalid *shared.InvalidParamError
+	if !errors.As(err, &invalid) || invalid.Name != "limit" {
+		t.Fatalf("err = %v, want InvalidParamError for limit", err)
+	}
+	if shared.ErrorStatus(err) != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", shared.ErrorStatus(err))
+	}
+}
+
+func TestMissingRequiredHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/test/gadget/1", strings.NewReader(validGadget))
+	req.Header.Set("Content-Type", shared.MediaTypeJSON)
+
+	_, err := serveRequest(created(gadget{}), req, validateConfig(true, false))
+	var invalid *shared.InvalidParamError
+	if !errors.As(err, &invalid) || invalid.Name != "X-Tenant" {
+		t.Fatalf("err = %v, want InvalidParamError for X-Tenant", err)
+	}
+}
+
+func TestInvalidBodyTypes(t *testing.T) {
+	body := `{"name":"cog","count":"three","created":"yesterday"}`
+	_, err := serve(created(gadget{}), "/v1/test/gadget/1", body, validateConfig(true, false))
+
+	var validation *shared.ValidationError
+	if !errors.As(err, &validation) || len(validation.Errors) != 2 {
+		t.Fatalf("err = %v, want a ValidationError with two violations", err)
+	}
+	paths := []string{}
+	for _, violation := range validation.Errors {
+		var invalid *shared.InvalidTypeError
+		if !errors.As(violation, &invalid) {
+			t.Fatalf("violation %v, want InvalidTypeError", violation)
+		}
+		paths = append(paths, invalid.Path)
+	}
+	if strings.Join(paths, ",") != "count,created" {
+		t.Fatalf("violations at %v, want count and created", paths)
+	}
+}
+
+func TestMalformedBody(t *testing.T) {
+	_, err := serve(created(gadget{}), "/v1/test/gadget/1", `{"name":`, validateConfig(true, false))
+	var invalid *shared.InvalidTypeError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want InvalidTypeError", err)
+	}
+}
+
+func TestRequestsAreNotValidatedWhenDisabled(t *testing.T) {
+	_, err := serve(created(gadget{}), "/v1/test/gadget/1?limit=many", `{"count":"three"}`, validateConfig(false, false))
+	if err != nil {
+		t.Fatalf("request refused with validation disabled: %v", err)
+	}
+}
+
+func TestDriftingResponseIsFlagged(t *testing.T) {
+	drifted := map[string]interface{}{"name": "cog", "count": "three"}
+	_, err := serve(created(drifted), "/v1/test/gadget/1", validGadget, validateConfig(false, true))
+	if err == nil || !strings.Contains(err.Error(), "drifts from the contract") {
+		t.Fatalf("err = %v, want the drifting response flagged", err)
+	}
+	if shared.ErrorStatus(err) != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", shared.ErrorStatus(err))
+	}
+}
+
+func TestUndocumentedResponseStatusIsFlagged(t *testing.T) {
+	handler := func(req shared.HttpWebRequest, ctx context.Context, config *shared.MapPropertySource) *shared.ResponseOut {
+		return shared.NewResponseOut().Status(http.StatusAccepted)
+	}
+	_, err := serve(handler, "/v1/test/gadget/1", validGadget, validateConfig(false, true))
+	if err == nil || !strings.Contains(err.Error(), "status 202 is not documented") {
+		t.Fatalf("err = %v, want the undocumented status flagged", err)
+	}
+}
+
+func TestMatchingResponsePasses(t *testing.T) {
+	_, err := serve(created(gadget{Name: "cog", Count: 3, Created: time.Now()}), "/v1/test/gadget/1", validGadget, validateConfig(false, true))
+	if err != nil {
+		t.Fatalf("matching response flagged: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Package outbox publishes domain events reliably. An event is stored in the
+// outbox collection by the transaction making the change it describes, and a
+// relay hands the stored events to the configured sinks afterwards: at least
+// once, and in order for the events of one aggregate.
+package outbox
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sequenceCounter is the counter numbering all events, aggregate counters
+// are named after the aggregate.
+const sequenceCounter = "*"
+
+// increment adds one to the counter and returns its new value. Within a
+// transaction the counter stays locked until the transaction ends, so the
+// values are handed out in the order the transactions commit.
+func increment(ctx context.Context, name string, config *MapPropertySource) (int64, error) {
+	collection := config.GetString("outbox-counters-collection")
+	filter := bson.D{{"name", name}}
+	err := datastore.GetDatastore().Upsert(ctx, collection, filter, bson.D{{"$inc", bson.D{{"value", int64(1)}}}})
+	if err != nil {
+		return 0, err
+	}
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err = datastore.GetDatastore().GetById(ctx, collection, filter, &counter)
+	return counter.Value, err
+}
+
+// Record stores an event about the aggregate in the outbox. It is called with
+// the context of the transaction making the change, the event is only stored
+// if the transaction commits. The counters numbering the event are incremented
+// by the same transaction.
+func Record(ctx context.Context, eventType, aggregateId string, data interface{}, config *MapPropertySource) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	version, err := increment(ctx, aggregateId, config)
+	if err != nil {
+		return err
+	}
+	sequence, err := increment(ctx, sequenceCounter, config)
+	if err != nil {
+		return err
+	}
+	entry := &models.OutboxEntry{
+		Event: models.Event{
+			Id:          uuid.NewV4().String(),
+			Type:        eventType,
+			AggregateId: aggregateId,
+			Sequence:    sequence,
+			Version:     version,
+			Occurred:    time.Now(),
+			Data:        b,
+		},
+		Status: models.OutboxPending,
+	}
+	return datastore.GetDatastore().Save(ctx, config.GetString("outbox-collection"), entry)
+}
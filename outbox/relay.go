@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const relayLease = "outbox-relay"
+
+// Start runs the relay until ctx is done. Only the replica holding the relay
+// lease publishes, which keeps the order of the events. The in-process bus is
+// always fed, whatever outbox-sinks names, as webhooks and event streams
+// subscribe to it.
+func Start(ctx context.Context, config *MapPropertySource) error {
+	configured := []Sink{DefaultBus}
+	for _, name := range strings.Split(config.GetString("outbox-sinks"), ",") {
+		if name = strings.TrimSpace(name); name == "" || name == "bus" {
+			continue
+		}
+		factory, ok := getSink(name)
+		if !ok {
+			return Error.InvalidParam("outbox-sinks", "registered sinks", name)
+		}
+		sink, err := factory(config)
+		if err != nil {
+			return err
+		}
+		configured = append(configured, sink)
+	}
+
+	collection := config.GetString("outbox-collection")
+	if err := datastore.GetDatastore().EnsureExpiry(ctx, collection, "expiresAt"); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to create the expiry index of %s: %s", collection, err.Error()))
+	}
+	// a second counter or lease of the same name would number events twice
+	// or let two replicas relay
+	for _, named := range []string{config.GetString("outbox-counters-collection"), config.GetString("leases-collection")} {
+		if err := datastore.GetDatastore().EnsureUnique(ctx, named, bson.D{{"name", 1}}, nil); err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the unique index of %s: %s", named, err.Error()))
+		}
+	}
+
+	owner := uuid.NewV4().String()
+	poll := time.Duration(config.GetInt("outbox-poll-interval")) * time.Second
+	go func() {
+		for ctx.Err() == nil {
+			if leased(ctx, owner, config) {
+				relay(ctx, configured, owner, config)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(poll):
+			}
+		}
+	}()
+	return nil
+}
+
+// leased takes or extends the relay lease for owner.
+func leased(ctx context.Context, owner string, config *MapPropertySource) bool {
+	collection := config.GetString("leases-collection")
+	now := time.Now().UTC()
+	err := datastore.GetDatastore().Upsert(ctx, collection, bson.D{{"name", relayLease}},
+		bson.D{{"$setOnInsert", bson.D{{"name", relayLease}}}})
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to store the %s lease: %s", relayLease, err.Error()))
+		return false
+	}
+
+	filter := bson.D{
+		{"name", relayLease},
+		{"$or", bson.A{
+			bson.D{{"owner", owner}},
+			bson.D{{"until", bson.D{{"$exists", false}}}},
+			bson.D{{"until", bson.D{{"$lt", now}}}},
+		}},
+	}
+	update := leaseUpdate(owner, config)
+	var lease bson.M
+	err = datastore.GetDatastore().FindOneAndUpdate(ctx, collection, filter, update, bson.D{}, &lease)
+	if err != nil && !datastore.IsNotFound(err) {
+		Warn(ctx, fmt.Sprintf("Unable to take the %s lease: %s", relayLease, err.Error()))
+	}
+	return err == nil
+}
+
+func leaseUpdate(owner string, config *MapPropertySource) bson.D {
+	return bson.D{{"$set", bson.D{
+		{"owner", owner},
+		{"until", time.Now().UTC().Add(time.Duration(config.GetInt("outbox-lease")) * time.Second)},
+	}}}
+}
+
+// renewed extends the relay lease owner holds, false once it is lost to
+// another replica or can not be extended.
+func renewed(ctx context.Context, owner string, config *MapPropertySource) bool {
+	filter := bson.D{{"name", relayLease}, {"owner", owner}}
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("leases-collection"), filter, leaseUpdate(owner, config))
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to extend the %s lease: %s", relayLease, err.Error()))
+		return false
+	}
+	if matched == 0 {
+		Warn(ctx, fmt.Sprintf("The %s lease was lost, stopping the batch", relayLease))
+	}
+	return matched > 0
+}
+
+// relay publishes a batch of pending events in sequence order. Once an event
+// of an aggregate fails, the later events of that aggregate wait for the next
+// round, so they are never published before it. The lease is extended before
+// every event, the batch stops as soon as it is lost.
+func relay(ctx context.Context, configured []Sink, owner string, config *MapPropertySource) {
+	collection := config.GetString("outbox-collection")
+	entries := make([]models.OutboxEntry, 0)
+	opt := options.Find().SetSort(bson.D{{"sequence", 1}}).SetLimit(int64(config.GetInt("outbox-batch-size")))
+	err := datastore.GetDatastore().GetAll(ctx, collection, bson.D{{"status", models.OutboxPending}}, opt, &entries)
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to read the outbox: %s", err.Error()))
+		return
+	}
+
+	blocked := map[string]bool{}
+	for _, entry := range entries {
+		if blocked[entry.AggregateId] {
+			continue
+		}
+		if !renewed(ctx, owner, config) {
+			return
+		}
+
+		err := publish(ctx, configured, entry.Event)
+		if err != nil {
+			blocked[entry.AggregateId] = true
+			Warn(ctx, fmt.Sprintf("Unable to publish Event(%s) %s of %s, attempt %d: %s", entry.Id, entry.Type, entry.AggregateId, entry.Attempts+1, err.Error()))
+			query := bson.D{
+				{"$set", bson.D{{"lastError", err.Error()}}},
+				{"$inc", bson.D{{"attempts", 1}}},
+			}
+			if err := datastore.GetDatastore().Update(ctx, collection, bson.D{{"id", entry.Id}}, query); err != nil {
+				Warn(ctx, fmt.Sprintf("Unable to record the failure of Event(%s): %s", entry.Id, err.Error()))
+			}
+			continue
+		}
+
+		now := time.Now().UTC()
+		query := bson.D{{"$set", bson.D{
+			{"status", models.OutboxPublished},
+			{"publishedAt", now},
+			{"expiresAt", now.Add(time.Duration(config.GetInt("outbox-retention")) * time.Second)},
+		}}}
+		if err := datastore.GetDatastore().Update(ctx, collection, bson.D{{"id", entry.Id}}, query); err != nil {
+			// the event is published again next round, sinks see an event at least once
+			Warn(ctx, fmt.Sprintf("Unable to mark Event(%s) published: %s", entry.Id, err.Error()))
+			blocked[entry.AggregateId] = true
+		}
+	}
+}
+
+func publish(ctx context.Context, configured []Sink, event models.Event) error {
+	for _, sink := range configured {
+		if err := sink.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
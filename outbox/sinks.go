@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink is where the relay publishes events. An error leaves the event in the
+// outbox to be published again, so sinks see an event at least once.
+type Sink interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+// SinkFactory builds a sink from the configuration.
+type SinkFactory func(config *MapPropertySource) (Sink, error)
+
+var (
+	sinksLock sync.RWMutex
+	sinks     = map[string]SinkFactory{}
+)
+
+// RegisterSink makes a sink available under the name outbox-sinks refers to.
+func RegisterSink(name string, factory SinkFactory) {
+	sinksLock.Lock()
+	defer sinksLock.Unlock()
+	sinks[name] = factory
+}
+
+func getSink(name string) (SinkFactory, bool) {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+	f, ok := sinks[name]
+	return f, ok
+}
+
+func init() {
+	RegisterSink("bus", func(config *MapPropertySource) (Sink, error) {
+		return DefaultBus, nil
+	})
+	RegisterSink("file", func(config *MapPropertySource) (Sink, error) {
+		return NewFileSink(config.GetString("outbox-file-path")), nil
+	})
+	RegisterSink("http", func(config *MapPropertySource) (Sink, error) {
+		if config.GetString("outbox-http-url") == "" {
+			return nil, Error.MissingRequiredProperty("outbox-http-url")
+		}
+		return NewHTTPSink(config.GetString("outbox-http-url"), time.Duration(config.GetInt("outbox-http-timeout"))*time.Second), nil
+	})
+}
+
+// Subscriber handles the events of the in-process bus.
+type Subscriber func(ctx context.Context, event models.Event) error
+
+// Bus is the in-process sink, it hands every event to the subscribers of its
+// type and to those of "*". A failing subscriber fails the publication, the
+// subscribers before it will see the event again.
+type Bus struct {
+	lock        sync.RWMutex
+	last        int
+	subscribers map[string][]subscription
+}
+
+type subscription struct {
+	id         int
+	subscriber Subscriber
+}
+
+var DefaultBus = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[string][]subscription{}}
+}
+
+// Subscribe adds a subscriber for the events of the type, or of every type
+// with "*". The returned function removes the subscriber again.
+func Subscribe(eventType string, subscriber Subscriber) func() {
+	return DefaultBus.Subscribe(eventType, subscriber)
+}
+
+func (b *Bus) Subscribe(eventType string, subscriber Subscriber) func() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.last++
+	id := b.last
+	b.subscribers[eventType] = append(b.subscribers[eventType], subscription{id, subscriber})
+	return func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		subscriptions := b.subscribers[eventType]
+		for i, s := range subscriptions {
+			if s.id == id {
+				b.subscribers[eventType] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, event models.Event) error {
+	b.lock.RLock()
+	subscriptions := append(append([]subscription{}, b.subscribers[event.Type]...), b.subscribers["*"]...)
+	b.lock.RUnlock()
+
+	for _, s := range subscriptions {
+		if err := s.subscriber(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileSink appends every event as a line of JSON to a file.
+type FileSink struct {
+	lock sync.Mutex
+	path string
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Publish(ctx context.Context, event models.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HTTPSink posts every event as JSON to a URL, any status but 2xx fails the
+// publication.
+type HTTPSink struct {
+	url     string
+	timeout time.Duration
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, timeout: timeout}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, event models.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	resp, err := HttpPost(ctx, s.url, MediaTypeJSON, bytes.NewReader(b), map[string]string{
+		"X-Event-Id":   event.Id,
+		"X-Event-Type": event.Type,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Error.Text("event sink %s answered %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
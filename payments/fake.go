@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	FakeTokenDeclined = "tok_declined"
+	FakeTokenError    = "tok_error"
+)
+
+// FakeGateway approves every capture and refund locally, except for the
+// FakeTokenDeclined token which is declined and FakeTokenError which fails as
+// if the gateway was unreachable. It keeps the captured amounts so refunds can
+// be checked against them. It moves no money and is not registered, tests
+// register it under the name they configure.
+type FakeGateway struct {
+	mu       sync.Mutex
+	captured map[string]int64
+	refunded map[string]int64
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		captured: map[string]int64{},
+		refunded: map[string]int64{},
+	}
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, req CaptureRequest) (*Result, error) {
+	switch req.Token {
+	case FakeTokenDeclined:
+		return &Result{Approved: false, Reason: "card declined"}, nil
+	case FakeTokenError:
+		return nil, context.DeadlineExceeded
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ref := "fake_" + uuid.NewV4().String()
+	g.captured[ref] = req.Amount
+	return &Result{Reference: ref, Approved: true}, nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, req RefundRequest) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	captured, ok := g.captured[req.Reference]
+	if !ok {
+		return &Result{Approved: false, Reason: "unknown payment reference"}, nil
+	}
+	if g.refunded[req.Reference]+req.Amount > captured {
+		return &Result{Approved: false, Reason: "refund exceeds captured amount"}, nil
+	}
+	g.refunded[req.Reference] += req.Amount
+	return &Result{Reference: "fake_" + uuid.NewV4().String(), Approved: true}, nil
+}
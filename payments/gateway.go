@@ -0,0 +1,58 @@
+package payments
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	lock     sync.RWMutex
+	gateways = map[string]Gateway{}
+)
+
+// CaptureRequest charges a payment. EntryId is the pending ledger entry the
+// payment is recorded as, gateways can keep it to find a capture whose answer
+// got lost.
+type CaptureRequest struct {
+	EntryId     string
+	StudentId   string
+	Amount      int64
+	Currency    string
+	Token       string
+	Description string
+}
+
+type RefundRequest struct {
+	Reference string
+	Amount    int64
+	Currency  string
+}
+
+// Result is what the gateway reports back for a capture or a refund.
+// Reference identifies the transaction on the gateway side and is stored on
+// the ledger entry so refunds can point back to it.
+type Result struct {
+	Reference string
+	Approved  bool
+	Reason    string
+}
+
+type Gateway interface {
+	Capture(ctx context.Context, req CaptureRequest) (*Result, error)
+	Refund(ctx context.Context, req RefundRequest) (*Result, error)
+}
+
+// Register makes a gateway available under the given name, which is what the
+// payment-gateway configuration refers to.
+func Register(name string, gateway Gateway) {
+	lock.Lock()
+	defer lock.Unlock()
+	gateways[name] = gateway
+}
+
+func GetGateway(name string) (Gateway, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	g, ok := gateways[name]
+	return g, ok
+}
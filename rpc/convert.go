@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/rpc/studentpb"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toStudentProto(student *models.Student) *studentpb.Student {
+	return &studentpb.Student{
+		Id:               student.Id,
+		Name:             student.Name,
+		EnrollmentNumber: student.EnrollmentNumber,
+		Status:           student.Status,
+		Meta: &studentpb.Meta{
+			ResourceType: student.Meta.ResourceType,
+			Created:      toTimestamp(student.Meta.Created),
+			LastModified: toTimestamp(student.Meta.LastModified),
+			Version:      student.Meta.Version,
+		},
+	}
+}
+
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// listParams turns a list request into the parameters GetFilter understands,
+// the same ones the REST list reads from the query string.
+func listParams(req *studentpb.ListStudentsRequest) map[string]interface{} {
+	params := make(map[string]interface{}, 0)
+	if req.GetId() != "" {
+		params["id"] = req.GetId()
+	}
+	if req.GetName() != "" {
+		params["name"] = req.GetName()
+	}
+	if req.GetSortOrder() != "" {
+		params["sortorder"] = req.GetSortOrder()
+	}
+	if req.GetSortField() != "" {
+		params["sortfield"] = req.GetSortField()
+	}
+	if req.GetAttributes() != "" {
+		params["attributes"] = req.GetAttributes()
+	}
+	if req.GetLimit() > 0 {
+		params["limit"] = int(req.GetLimit())
+	}
+	if req.GetOffset() > 0 {
+		params["offset"] = int(req.GetOffset())
+	}
+	return params
+}
@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const correlationIdKey = "x-correlation-id"
+
+// requestScopeInterceptor is InjectRequestScope for gRPC: the correlation id
+// comes from the x-correlation-id metadata or is generated, and is sent back
+// in the response header.
+func requestScopeInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestId := metadataValue(ctx, correlationIdKey)
+	if requestId == "" {
+		requestId = uuid.NewV4().String()
+	}
+	ctx = context.WithValue(ctx, RequestId{}, requestId)
+	ctx = context.WithValue(ctx, RequestTimestamp{}, time.Now().Unix())
+	_ = grpc.SetHeader(ctx, metadata.Pairs(correlationIdKey, requestId))
+
+	Info(ctx, "gRPC Requested ", info.FullMethod)
+
+	t := time.Now()
+	resp, err := handler(ctx, req)
+	Info(ctx, "Completed Req ", info.FullMethod, " time taken ( ", time.Now().Sub(t), "s )", " code: ", status.Code(err))
+	return resp, err
+}
+
+// errorRecoveryInterceptor turns the shared error types, returned or
+// panicked, into gRPC statuses, logged at the level their mapping asks for.
+func errorRecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				e = Error.Text("%v", r)
+			}
+			e = ContextError(ctx, e)
+			ErrorMappingOf(e).Log(ctx, fmt.Sprintf("%s panicked: %s", info.FullMethod, e.Error()))
+			resp, err = nil, toStatus(e)
+		}
+	}()
+
+	resp, err = handler(ctx, req)
+	if err != nil {
+		err = ContextError(ctx, err)
+		ErrorMappingOf(err).Log(ctx, fmt.Sprintf("%s failed: %s", info.FullMethod, err.Error()))
+		return nil, toStatus(err)
+	}
+	return resp, nil
+}
+
+func authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := Authenticate(ctx, func(name string) string {
+		return metadataValue(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func metadataValue(ctx context.Context, name string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// statusCodes are the gRPC codes closest to the http statuses of the error
+// mappings.
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusPaymentRequired:       codes.FailedPrecondition,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusNotAcceptable:         codes.InvalidArgument,
+	http.StatusConflict:              codes.Aborted,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusUnsupportedMediaType:  codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	StatusClientClosedRequest:        codes.Canceled,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// toStatus maps an error to the gRPC code closest to the http status
+// ErrorRecovery answers with, so errors registered with RegisterError map
+// too.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	mapping := ErrorMappingOf(err)
+	code, ok := statusCodes[mapping.Status]
+	switch {
+	case ok:
+	case mapping.Status < http.StatusInternalServerError:
+		code = codes.FailedPrecondition
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
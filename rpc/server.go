@@ -0,0 +1,133 @@
+//go:generate protoc -I ../proto --go_out=studentpb --go_opt=paths=source_relative --go-grpc_out=studentpb --go-grpc_opt=paths=source_relative students.proto
+
+// Package rpc serves the student api over gRPC next to the REST routes,
+// through the same service layer.
+package rpc
+
+import (
+	"awesomeTestProject/models"
+	"awesomeTestProject/rpc/studentpb"
+	"awesomeTestProject/services"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type StudentServer struct {
+	studentpb.UnimplementedStudentServiceServer
+	config *MapPropertySource
+}
+
+// NewServer builds the gRPC server with the student service, the health
+// service and server reflection registered.
+func NewServer(config *MapPropertySource) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		requestScopeInterceptor,
+		errorRecoveryInterceptor,
+		authInterceptor,
+	))
+	studentpb.RegisterStudentServiceServer(server, &StudentServer{config: config})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(studentpb.StudentService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+	return server
+}
+
+func (s *StudentServer) CreateStudent(ctx context.Context, req *studentpb.CreateStudentRequest) (*studentpb.Student, error) {
+	Info(ctx, "Create Student")
+	student := &models.Student{Name: req.GetName(), EnrollmentNumber: req.GetEnrollmentNumber()}
+	student, err := service.SaveStudent(ctx, student, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	Info(ctx, fmt.Sprintf("Student(%s) created.", student.Id))
+	return toStudentProto(student), nil
+}
+
+func (s *StudentServer) GetStudent(ctx context.Context, req *studentpb.GetStudentRequest) (*studentpb.Student, error) {
+	var student models.Student
+	student.Id = req.GetId()
+	Info(ctx, fmt.Sprintf("Get Student(%s)", student.Id))
+	err := service.GetStudent(ctx, &student, s.config)
+	if err != nil {
+		return nil, err
+	}
+	return toStudentProto(&student), nil
+}
+
+func (s *StudentServer) ListStudents(ctx context.Context, req *studentpb.ListStudentsRequest) (*studentpb.ListStudentsResponse, error) {
+	Info(ctx, "Getting Students")
+	students, err := service.GetStudents(ctx, listParams(req), s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &studentpb.ListStudentsResponse{Students: make([]*studentpb.Student, 0, len(students))}
+	for i := range students {
+		resp.Students = append(resp.Students, toStudentProto(&students[i]))
+	}
+	Info(ctx, fmt.Sprintf("Got %d Students.", len(students)))
+	return resp, nil
+}
+
+func (s *StudentServer) ReplaceStudent(ctx context.Context, req *studentpb.ReplaceStudentRequest) (*studentpb.ReplaceStudentResponse, error) {
+	if req.GetStudent().GetId() == "" {
+		return nil, Error.MissingRequiredProperty("student.id")
+	}
+	student := &models.Student{
+		Id:               req.GetStudent().GetId(),
+		Name:             req.GetStudent().GetName(),
+		EnrollmentNumber: req.GetStudent().GetEnrollmentNumber(),
+	}
+
+	Info(ctx, fmt.Sprintf("Update Student(%s)", student.Id))
+	student, created, err := service.PutStudent(ctx, student, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	Info(ctx, fmt.Sprintf("Student(%s) updated.", student.Id))
+	return &studentpb.ReplaceStudentResponse{Student: toStudentProto(student), Created: created}, nil
+}
+
+func (s *StudentServer) PatchStudent(ctx context.Context, req *studentpb.PatchStudentRequest) (*studentpb.Student, error) {
+	patchPayload := &models.PatchRequestPayload{Id: req.GetId()}
+	for _, op := range req.GetOperations() {
+		patchPayload.Operations = append(patchPayload.Operations, models.Operations{
+			Op:    op.GetOp(),
+			Path:  op.GetPath(),
+			Value: op.GetValue().AsInterface(),
+		})
+	}
+
+	Info(ctx, fmt.Sprintf("Patch Student(%s)", patchPayload.Id))
+	student, err := service.PatchStudent(ctx, patchPayload, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	Info(ctx, fmt.Sprintf("Student(%s) patched.", student.Id))
+	return toStudentProto(student), nil
+}
+
+func (s *StudentServer) DeleteStudent(ctx context.Context, req *studentpb.DeleteStudentRequest) (*emptypb.Empty, error) {
+	Info(ctx, fmt.Sprintf("Delete Student(%s)", req.GetId()))
+	err := service.DeleteStudent(ctx, req.GetId(), s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	Info(ctx, fmt.Sprintf("Deleted Student(%s).", req.GetId()))
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,300 @@
+// Package scheduler runs registered routines on the cron expressions of the
+// schedules configuration. Schedules are kept in Mongo and each tick is run
+// by the one replica that holds the lease on the schedule.
+package scheduler
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recordTimeout bounds recording the run of a schedule, which is done apart
+// from the context of the replica so it is recorded while shutting down.
+const recordTimeout = 5 * time.Second
+
+var (
+	lock     sync.RWMutex
+	routines = map[string]RoutineHandler{}
+)
+
+// Register makes a routine available under the name the schedules
+// configuration refers to. The routine is handed the time of the tick it
+// runs for.
+func Register(name string, routine RoutineHandler) {
+	lock.Lock()
+	defer lock.Unlock()
+	routines[name] = routine
+}
+
+func getRoutine(name string) (RoutineHandler, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	r, ok := routines[name]
+	return r, ok
+}
+
+type entry struct {
+	name       string
+	expression string
+	policy     string
+	schedule   cron.Schedule
+}
+
+// schedules reads the schedules configuration, written as
+// "routine=expression|policy;routine=expression". Expressions are standard
+// five field cron expressions or descriptors like @hourly, the policy for
+// missed ticks is skip or catchup and defaults to schedule-missed-policy.
+func schedules(config *MapPropertySource) ([]entry, error) {
+	for _, name := range []string{"schedule-catchup-max", "schedule-lease"} {
+		if n := config.GetInt(name); n < 1 {
+			return nil, Error.InvalidParam(name, "a positive integer", fmt.Sprint(n))
+		}
+	}
+	var entries []entry
+	for _, rule := range strings.Split(config.GetString("schedules"), ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return nil, Error.InvalidParam("schedules", "routine=expression pairs", rule)
+		}
+		e := entry{name: strings.TrimSpace(parts[0]), policy: config.GetString("schedule-missed-policy")}
+		e.expression = parts[1]
+		if i := strings.LastIndex(parts[1], "|"); i >= 0 {
+			e.expression, e.policy = parts[1][:i], strings.TrimSpace(parts[1][i+1:])
+		}
+		e.expression = strings.TrimSpace(e.expression)
+		if e.policy != models.ScheduleSkip && e.policy != models.ScheduleCatchUp {
+			return nil, Error.InvalidParam(e.name, "skip or catchup", e.policy)
+		}
+		schedule, err := cron.ParseStandard(e.expression)
+		if err != nil {
+			return nil, Error.InvalidParam(e.name, "a cron expression", e.expression)
+		}
+		e.schedule = schedule
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Start checks the schedules every schedule-poll-interval seconds until ctx
+// is done and runs the ticks that are due.
+func Start(ctx context.Context, config *MapPropertySource) error {
+	entries, err := schedules(config)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, ok := getRoutine(e.name); !ok {
+			Warn(ctx, fmt.Sprintf("Schedule %s has no registered routine and is not run", e.name))
+		}
+	}
+	// two documents of a schedule could each be leased by another replica
+	collection := config.GetString("schedules-collection")
+	if err := datastore.GetDatastore().EnsureUnique(ctx, collection, bson.D{{"name", 1}}, nil); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to create the unique index of %s: %s", collection, err.Error()))
+	}
+
+	owner := uuid.NewV4().String()
+	poll := time.Duration(config.GetInt("schedule-poll-interval")) * time.Second
+	go func() {
+		for ctx.Err() == nil {
+			for _, e := range entries {
+				if _, ok := getRoutine(e.name); ok {
+					tick(ctx, e, owner, config)
+				}
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(poll):
+			}
+		}
+	}()
+	return nil
+}
+
+// tick runs the schedule when it is due and this replica gets the lease.
+func tick(ctx context.Context, e entry, owner string, config *MapPropertySource) {
+	collection := config.GetString("schedules-collection")
+	now := time.Now().UTC()
+
+	// the first tick of a new schedule is the first one after it was seen
+	err := datastore.GetDatastore().Upsert(ctx, collection, bson.D{{"name", e.name}}, bson.D{
+		{"$set", bson.D{{"expression", e.expression}, {"policy", e.policy}}},
+		{"$setOnInsert", bson.D{
+			{"nextRun", e.schedule.Next(now)},
+			{"meta", models.Meta{ResourceType: "Schedule", Created: now, LastModified: now}},
+		}},
+	})
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to store Schedule(%s): %s", e.name, err.Error()))
+		return
+	}
+
+	lease := time.Duration(config.GetInt("schedule-lease")) * time.Second
+	filter := bson.D{
+		{"name", e.name},
+		{"nextRun", bson.D{{"$lte", now}}},
+		{"$or", bson.A{
+			bson.D{{"leaseUntil", bson.D{{"$exists", false}}}},
+			bson.D{{"leaseUntil", bson.D{{"$lt", now}}}},
+		}},
+	}
+	claim := bson.D{{"$set", bson.D{{"leaseOwner", owner}, {"leaseUntil", now.Add(lease)}}}}
+	var schedule models.Schedule
+	err = datastore.GetDatastore().FindOneAndUpdate(ctx, collection, filter, claim, bson.D{}, &schedule)
+	if datastore.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to lease Schedule(%s): %s", e.name, err.Error()))
+		return
+	}
+
+	ticks := dueTicks(e, schedule.NextRun, now, config.GetInt("schedule-catchup-max"))
+	routine, _ := getRoutine(e.name)
+	running, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := keepLease(running, cancel, e.name, owner, lease, config)
+	var last *models.ScheduleRun
+	for _, t := range ticks.run {
+		last = run(running, e.name, t, routine)
+		if last.Status == "failed" || running.Err() != nil {
+			break
+		}
+	}
+	stop()
+
+	done := time.Now().UTC()
+	next := e.schedule.Next(done)
+	set := bson.D{{"meta.lastModified", done}}
+	if last != nil {
+		last.Skipped = ticks.skipped
+		set = append(set, bson.E{"lastRun", last})
+		// a failed tick is caught up again on the next poll
+		if last.Status == "failed" && e.policy == models.ScheduleCatchUp {
+			next = last.Tick
+		}
+	}
+	set = append(set, bson.E{"nextRun", next})
+	update := bson.D{
+		{"$set", set},
+		{"$unset", bson.D{{"leaseOwner", ""}, {"leaseUntil", ""}}},
+	}
+	// recorded even when the replica is shutting down
+	store, cancelStore := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancelStore()
+	matched, err := datastore.GetDatastore().UpdateMatched(store, collection, bson.D{{"name", e.name}, {"leaseOwner", owner}}, update)
+	if err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to record the run of Schedule(%s): %s", e.name, err.Error()))
+	} else if matched == 0 {
+		Warn(ctx, fmt.Sprintf("Schedule(%s) lease was lost while running", e.name))
+	}
+}
+
+// keepLease extends the lease on the schedule while its routine runs, until
+// the returned function is called. Once the lease is lost to another replica
+// cancel stops the routine.
+func keepLease(ctx context.Context, cancel context.CancelFunc, name, owner string, lease time.Duration, config *MapPropertySource) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("schedules-collection"), bson.D{{"name", name}, {"leaseOwner", owner}},
+					bson.D{{"$set", bson.D{{"leaseUntil", time.Now().UTC().Add(lease)}}}})
+				if err != nil {
+					// the lease may still be extended before it runs out
+					Warn(ctx, fmt.Sprintf("Unable to extend the lease of Schedule(%s): %s", name, err.Error()))
+				} else if matched == 0 {
+					Warn(ctx, fmt.Sprintf("Lease on Schedule(%s) was lost, stopping it", name))
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+type due struct {
+	run     []time.Time
+	skipped int
+}
+
+// dueTicks lists the ticks from next up to now. The skip policy runs only the
+// latest, catchup runs them all oldest first, but not more than max.
+func dueTicks(e entry, next, now time.Time, max int) due {
+	var ticks []time.Time
+	for t := next; !t.After(now); t = e.schedule.Next(t) {
+		ticks = append(ticks, t)
+	}
+	if e.policy == models.ScheduleSkip {
+		return due{run: ticks[len(ticks)-1:], skipped: len(ticks) - 1}
+	}
+	if len(ticks) > max {
+		return due{run: ticks[len(ticks)-max:], skipped: len(ticks) - max}
+	}
+	return due{run: ticks}
+}
+
+// run runs the routine for one tick in its own service scope, so a panic is
+// recovered and logged with the trace id of the run. The routine is cancelled
+// with ctx.
+func run(ctx context.Context, name string, t time.Time, routine RoutineHandler) *models.ScheduleRun {
+	result := &models.ScheduleRun{Tick: t, Started: time.Now().UTC(), Status: "failed"}
+	InjectServiceScopeWith(ctx, t.Unix(), func(t int64, ctx context.Context) {
+		Info(ctx, fmt.Sprintf("Schedule(%s) running for tick %s", name, result.Tick.Format(time.RFC3339)))
+		defer func() {
+			result.Finished = time.Now().UTC()
+			if r := recover(); r != nil {
+				err, ok := r.(error)
+				if !ok {
+					err = Error.Text("%v", r)
+				}
+				result.Error = err.Error()
+				panic(err)
+			}
+		}()
+		routine(t, ctx)
+		result.Status = "succeeded"
+		Info(ctx, fmt.Sprintf("Schedule(%s) completed in %s", name, time.Now().Sub(result.Started)))
+	})
+	return result
+}
+
+// List returns the state of every schedule that ran on any replica.
+func List(ctx context.Context, config *MapPropertySource) ([]models.Schedule, error) {
+	schedules := make([]models.Schedule, 0)
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("schedules-collection"), bson.D{}, nil, &schedules)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range schedules {
+		schedules[i].Running = schedules[i].LeaseUntil.After(now)
+	}
+	return schedules, nil
+}
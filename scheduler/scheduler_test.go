@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/datastore/datastoretest"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func hourly(t *testing.T, policy string) entry {
+	schedule, err := cron.ParseStandard("@hourly")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return entry{name: "test-report", expression: "@hourly", policy: policy, schedule: schedule}
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestDueTicksSkipRunsOnlyTheLatest(t *testing.T) {
+	ticks := dueTicks(hourly(t, models.ScheduleSkip), at(10, 0), at(13, 30), 10)
+
+	if len(ticks.run) != 1 || !ticks.run[0].Equal(at(13, 0)) || ticks.skipped != 3 {
+		t.Fatalf("due = %v, skipped %d, want 13:00 with 3 skipped", ticks.run, ticks.skipped)
+	}
+}
+
+func TestDueTicksCatchUpRunsTheMissedTicks(t *testing.T) {
+	e := hourly(t, models.ScheduleCatchUp)
+
+	ticks := dueTicks(e, at(10, 0), at(13, 30), 10)
+	if len(ticks.run) != 4 || !ticks.run[0].Equal(at(10, 0)) || !ticks.run[3].Equal(at(13, 0)) || ticks.skipped != 0 {
+		t.Fatalf("due = %v, skipped %d, want 10:00 to 13:00 oldest first", ticks.run, ticks.skipped)
+	}
+	// at most schedule-catchup-max, the latest ones
+	ticks = dueTicks(e, at(10, 0), at(13, 30), 2)
+	if len(ticks.run) != 2 || !ticks.run[0].Equal(at(12, 0)) || ticks.skipped != 2 {
+		t.Fatalf("due = %v, skipped %d, want 12:00 and 13:00 with 2 skipped", ticks.run, ticks.skipped)
+	}
+	// a tick due right now is run
+	ticks = dueTicks(e, at(13, 0), at(13, 0), 2)
+	if len(ticks.run) != 1 || ticks.skipped != 0 {
+		t.Fatalf("due = %v, skipped %d, want the tick at now", ticks.run, ticks.skipped)
+	}
+}
+
+func scheduleTest(t *testing.T) *MapPropertySource {
+	InitConfigs()
+	config := GetConfigs()
+	datastoretest.Use(t)
+	return config
+}
+
+// routine registers the test-report routine, failing for the ticks in
+// failing, and returns the ticks it ran for.
+func routine(failing ...time.Time) func() []time.Time {
+	var mu sync.Mutex
+	var ran []time.Time
+	Register("test-report", func(t int64, ctx context.Context) {
+		tick := time.Unix(t, 0).UTC()
+		mu.Lock()
+		ran = append(ran, tick)
+		mu.Unlock()
+		for _, f := range failing {
+			if f.Equal(tick) {
+				panic(errors.New("report failed"))
+			}
+		}
+	})
+	return func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return ran
+	}
+}
+
+func stored(t *testing.T, config *MapPropertySource) models.Schedule {
+	var schedule models.Schedule
+	err := datastore.GetDatastore().GetById(context.Background(), config.GetString("schedules-collection"), bson.D{{"name", "test-report"}}, &schedule)
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	return schedule
+}
+
+// missed moves the next run of the schedule back by n hours.
+func missed(t *testing.T, n int, config *MapPropertySource) time.Time {
+	next := time.Now().UTC().Truncate(time.Hour).Add(-time.Duration(n-1) * time.Hour)
+	err := datastore.GetDatastore().Update(context.Background(), config.GetString("schedules-collection"), bson.D{{"name", "test-report"}},
+		bson.D{{"$set", bson.D{{"nextRun", next}}}})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	return next
+}
+
+func TestNewScheduleWaitsForItsFirstTick(t *testing.T) {
+	config := scheduleTest(t)
+	ran := routine()
+
+	tick(context.Background(), hourly(t, models.ScheduleSkip), "r1", config)
+	if len(ran()) != 0 {
+		t.Fatalf("new schedule ran for %v", ran())
+	}
+	if schedule := stored(t, config); !schedule.NextRun.After(time.Now()) || schedule.Meta.ResourceType != "Schedule" {
+		t.Fatalf("schedule = %+v, want the next hour as its first run", schedule)
+	}
+}
+
+func TestMissedTicksAreSkipped(t *testing.T) {
+	config := scheduleTest(t)
+	ran := routine()
+	e := hourly(t, models.ScheduleSkip)
+	tick(context.Background(), e, "r1", config)
+	missed(t, 3, config)
+
+	tick(context.Background(), e, "r1", config)
+	latest := time.Now().UTC().Truncate(time.Hour)
+	if got := ran(); len(got) != 1 || !got[0].Equal(latest) {
+		t.Fatalf("ran for %v, want only %s", got, latest)
+	}
+	schedule := stored(t, config)
+	if schedule.LastRun == nil || schedule.LastRun.Skipped != 2 || schedule.LastRun.Status != "succeeded" {
+		t.Fatalf("last run = %+v, want succeeded with 2 skipped", schedule.LastRun)
+	}
+	if !schedule.NextRun.After(time.Now()) || schedule.LeaseOwner != "" {
+		t.Fatalf("schedule = %+v, want released until the next hour", schedule)
+	}
+}
+
+func TestMissedTicksAreCaughtUpUntilOneFails(t *testing.T) {
+	config := scheduleTest(t)
+	config.Data["schedule-catchup-max"] = 10
+	first := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	ran := routine(first.Add(time.Hour))
+	e := hourly(t, models.ScheduleCatchUp)
+	tick(context.Background(), e, "r1", config)
+	missed(t, 3, config)
+
+	tick(context.Background(), e, "r1", config)
+	if got := ran(); len(got) != 2 || !got[0].Equal(first) {
+		t.Fatalf("ran for %v, want %s and the failing tick after it", got, first)
+	}
+	// the failed tick is due again
+	schedule := stored(t, config)
+	if schedule.LastRun == nil || schedule.LastRun.Status != "failed" || !schedule.NextRun.Equal(first.Add(time.Hour)) {
+		t.Fatalf("schedule = %+v, want the failed tick next", schedule)
+	}
+}
+
+func TestLeasedScheduleIsNotRunTwice(t *testing.T) {
+	config := scheduleTest(t)
+	ran := routine()
+	e := hourly(t, models.ScheduleSkip)
+	tick(context.Background(), e, "r1", config)
+	missed(t, 1, config)
+	// another replica is running it
+	_ = datastore.GetDatastore().Update(context.Background(), config.GetString("schedules-collection"), bson.D{{"name", "test-report"}},
+		bson.D{{"$set", bson.D{{"leaseOwner", "r2"}, {"leaseUntil", time.Now().UTC().Add(time.Minute)}}}})
+
+	tick(context.Background(), e, "r1", config)
+	if len(ran()) != 0 {
+		t.Fatalf("schedule leased by another replica ran for %v", ran())
+	}
+}
+
+func TestSchedulesRefuseANonPositiveLease(t *testing.T) {
+	config := scheduleTest(t)
+	config.Data["schedule-lease"] = 0
+
+	var invalid *InvalidParamError
+	if _, err := schedules(config); !errors.As(err, &invalid) || invalid.Name != "schedule-lease" {
+		t.Fatalf("schedules: %v, want InvalidParamError for schedule-lease", err)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+var attendanceIndex sync.Once
+
+// ensureAttendanceIndex makes mongo refuse a second mark of a student for a
+// session, which the upsert in MarkAttendance alone can not do for concurrent
+// requests.
+func ensureAttendanceIndex(ctx context.Context, config *MapPropertySource) {
+	attendanceIndex.Do(func() {
+		collection := config.GetString("attendance-collection")
+		keys := bson.D{{"studentId", 1}, {"courseId", 1}, {"date", 1}}
+		err := datastore.GetDatastore().EnsureUnique(ctx, collection, keys, nil)
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the session index of %s: %s", collection, err.Error()))
+		}
+	})
+}
+
+var attendanceStatuses = map[string]bool{
+	models.AttendancePresent: true,
+	models.AttendanceAbsent:  true,
+	models.AttendanceLate:    true,
+	models.AttendanceExcused: true,
+}
+
+var attendanceGroups = map[string]interface{}{
+	"student": "$studentId",
+	"course":  "$courseId",
+	"date":    bson.D{{"$dateToString", bson.D{{"format", "%Y-%m-%d"}, {"date", "$date"}}}},
+}
+
+func ParseAttendanceBulk(ctx context.Context, req HttpWebRequest) (*models.AttendanceBulkPayload, error) {
+	var payload models.AttendanceBulkPayload
+
+	err := req.Decode(&payload)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+
+	payload.CourseId = req.Param("id")
+	return &payload, nil
+}
+
+// ParseAttendanceRange reads the optional from and to parameters, either as
+// RFC3339 timestamps or as plain dates.
+func ParseAttendanceRange(ctx context.Context, req HttpWebRequest) (*time.Time, *time.Time, error) {
+	from, err := parseDateParam("from", req.Param("from"))
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := parseDateParam("to", req.Param("to"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+func parseDateParam(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, Error.InvalidParam(name, "a date (2006-01-02) or RFC3339 timestamp", value)
+	}
+	return &t, nil
+}
+
+// MarkAttendance records the attendance of a whole class for one session.
+// Marking the same student twice for a session overwrites the earlier mark.
+func MarkAttendance(ctx context.Context, payload *models.AttendanceBulkPayload, config *MapPropertySource) ([]models.Attendance, error) {
+	if payload.Date.IsZero() {
+		return nil, Error.MissingRequiredProperty("date")
+	}
+	if payload.DefaultStatus != "" && !attendanceStatuses[payload.DefaultStatus] {
+		return nil, Error.InvalidParam("defaultStatus", "present, absent, late or excused", payload.DefaultStatus)
+	}
+
+	var course models.Course
+	course.Id = payload.CourseId
+	err := GetCourse(ctx, &course, config)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollments, err := GetCourseEnrollments(ctx, course.Id, config)
+	if err != nil {
+		return nil, err
+	}
+	enrolled := map[string]bool{}
+	for _, e := range enrollments {
+		if e.Status == models.EnrollmentEnrolled {
+			enrolled[e.StudentId] = true
+		}
+	}
+
+	records := map[string]models.AttendanceRecord{}
+	for _, r := range payload.Records {
+		if !attendanceStatuses[r.Status] {
+			return nil, Error.InvalidParam("status", "present, absent, late or excused", r.Status)
+		}
+		if !enrolled[r.StudentId] {
+			return nil, Error.InvalidParam("studentId", "a student enrolled in the course", r.StudentId)
+		}
+		records[r.StudentId] = r
+	}
+	if payload.DefaultStatus != "" {
+		for studentId := range enrolled {
+			if _, ok := records[studentId]; !ok {
+				records[studentId] = models.AttendanceRecord{StudentId: studentId, Status: payload.DefaultStatus}
+			}
+		}
+	}
+
+	ensureAttendanceIndex(ctx, config)
+	session := payload.Date.UTC().Truncate(24 * time.Hour)
+	now := time.Now()
+	marked := make([]models.Attendance, 0, len(records))
+	for _, r := range records {
+		filter := bson.D{{"studentId", r.StudentId}, {"courseId", course.Id}, {"date", session}}
+		query := bson.D{
+			{"$set", bson.D{
+				{"status", r.Status},
+				{"note", r.Note},
+				{"meta.lastModified", now},
+			}},
+			{"$setOnInsert", bson.D{
+				{"id", uuid.NewV4().String()},
+				{"meta.resourceType", "Attendance"},
+				{"meta.created", now},
+			}},
+		}
+		err = datastore.GetDatastore().Upsert(ctx, config.GetString("attendance-collection"), filter, query)
+		if mongo.IsDuplicateKeyError(err) {
+			// a concurrent mark inserted the session first, this one updates it
+			err = datastore.GetDatastore().Upsert(ctx, config.GetString("attendance-collection"), filter, query)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var attendance models.Attendance
+		err = datastore.GetDatastore().GetById(ctx, config.GetString("attendance-collection"), filter, &attendance)
+		if err != nil {
+			return nil, err
+		}
+		marked = append(marked, attendance)
+	}
+
+	alert, err := GetAttendanceAlerts(ctx, course.Id, nil, nil, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range alert.Students {
+		Warn(ctx, fmt.Sprintf("Student(%s) attendance in Course(%s) is %.2f, below %.2f", s.Key, course.Id, *s.Rate, alert.Threshold))
+	}
+
+	return marked, nil
+}
+
+// GetAttendanceReport aggregates attendance matching the filters, grouped by
+// student, course or date. The rate counts late as attended and leaves excused
+// sessions out of the total.
+func GetAttendanceReport(ctx context.Context, filters bson.D, groupBy string, from, to *time.Time, config *MapPropertySource) (*models.AttendanceReport, error) {
+	group, ok := attendanceGroups[groupBy]
+	if !ok {
+		return nil, Error.InvalidParam("groupBy", "student, course or date", groupBy)
+	}
+
+	match := filters
+	if from != nil || to != nil {
+		dates := bson.D{}
+		if from != nil {
+			dates = append(dates, bson.E{Key: "$gte", Value: *from})
+		}
+		if to != nil {
+			dates = append(dates, bson.E{Key: "$lte", Value: *to})
+		}
+		match = append(match, bson.E{Key: "date", Value: dates})
+	}
+
+	countOf := func(status string) bson.D {
+		return bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$status", status}}}, 1, 0}}}}}
+	}
+	attended := bson.D{{"$add", bson.A{"$present", "$late"}}}
+	counted := bson.D{{"$subtract", bson.A{"$total", "$excused"}}}
+
+	pipeline := bson.A{
+		bson.D{{"$match", match}},
+		bson.D{{"$group", bson.D{
+			{"_id", group},
+			{"total", bson.D{{"$sum", 1}}},
+			{"present", countOf(models.AttendancePresent)},
+			{"absent", countOf(models.AttendanceAbsent)},
+			{"late", countOf(models.AttendanceLate)},
+			{"excused", countOf(models.AttendanceExcused)},
+		}}},
+		bson.D{{"$addFields", bson.D{
+			{"rate", bson.D{{"$cond", bson.A{
+				bson.D{{"$gt", bson.A{counted, 0}}},
+				bson.D{{"$divide", bson.A{attended, counted}}},
+				nil,
+			}}}},
+		}}},
+		bson.D{{"$sort", bson.D{{"_id", 1}}}},
+	}
+
+	rows := make([]models.AttendanceSummary, 0)
+	err := datastore.GetDatastore().Aggregate(ctx, config.GetString("attendance-collection"), pipeline, &rows)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AttendanceReport{GroupBy: groupBy, From: from, To: to, Rows: rows}, nil
+}
+
+// GetAttendanceAlerts lists the students of a course whose attendance rate is
+// below the configured attendance-alert-threshold.
+func GetAttendanceAlerts(ctx context.Context, courseId string, from, to *time.Time, config *MapPropertySource) (*models.AttendanceAlert, error) {
+	report, err := GetAttendanceReport(ctx, bson.D{{"courseId", courseId}}, "student", from, to, config)
+	if err != nil {
+		return nil, err
+	}
+
+	alert := &models.AttendanceAlert{
+		CourseId:  courseId,
+		Threshold: config.GetFloat("attendance-alert-threshold"),
+		Students:  make([]models.AttendanceSummary, 0),
+	}
+	for _, row := range report.Rows {
+		if row.Rate != nil && *row.Rate < alert.Threshold {
+			alert.Students = append(alert.Students, row)
+		}
+	}
+	return alert, nil
+}
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var bulkIdReference = regexp.MustCompile(`bulkId:([A-Za-z0-9._~-]+)`)
+
+var errBulkRolledBack = errors.New("bulk request rolled back")
+
+// ParseBulk reads a SCIM bulk request, refusing it when it is larger than the
+// configured bulk-max-payload-size or bulk-max-operations.
+func ParseBulk(ctx context.Context, req HttpWebRequest, config *MapPropertySource) (*models.BulkRequest, error) {
+	var bulk models.BulkRequest
+
+	codec, err := CodecFor(req.Header("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxPayload := config.GetInt("bulk-max-payload-size")
+	b, err := ioutil.ReadAll(io.LimitReader(req.Raw().Body, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxPayload {
+		return nil, Error.PayloadTooLarge("payload size", maxPayload)
+	}
+
+	err = codec.Unmarshal(b, &bulk)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+
+	maxOperations := config.GetInt("bulk-max-operations")
+	if len(bulk.Operations) > maxOperations {
+		return nil, Error.PayloadTooLarge("number of operations", maxOperations)
+	}
+	if len(bulk.Operations) == 0 {
+		return nil, Error.MissingRequiredProperty("Operations")
+	}
+	seen := map[string]bool{}
+	for _, op := range bulk.Operations {
+		if op.BulkId == "" {
+			continue
+		}
+		if seen[op.BulkId] {
+			return nil, Error.InvalidParam("bulkId", "a bulkId unique within the request", op.BulkId)
+		}
+		seen[op.BulkId] = true
+	}
+	return &bulk, nil
+}
+
+// ProcessBulk runs the operations of a bulk request in order and reports the
+// outcome of each one. Consecutive POSTs are inserted together through
+// SaveMany unless failOnErrors asks to stop at a given error count, in which
+// case operations run one by one. A POST referring to the bulkId of a POST in
+// the same batch starts a new batch, so the reference resolves as it would
+// one by one. With bulk-transactional enabled and
+// failOnErrors set to 1 the whole request runs in a transaction, and when an
+// operation fails the operations before it are reported as rolled back.
+func ProcessBulk(ctx context.Context, bulk *models.BulkRequest, location string, config *MapPropertySource) (*models.BulkResponse, error) {
+	response := &models.BulkResponse{Schemas: []string{models.BulkResponseSchema}}
+
+	if !config.GetBool("bulk-transactional") || bulk.FailOnErrors != 1 {
+		response.Operations = processBulkOperations(ctx, bulk, location, config)
+		return response, nil
+	}
+
+	err := datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		response.Operations = processBulkOperations(ctx, bulk, location, config)
+		if failedOperation(response.Operations) >= 0 {
+			return errBulkRolledBack
+		}
+		return nil
+	})
+	if err != nil && err != errBulkRolledBack {
+		return nil, err
+	}
+
+	if failed := failedOperation(response.Operations); failed >= 0 {
+		for i := 0; i < failed; i++ {
+			op := &response.Operations[i]
+			op.Location = ""
+			op.Status = strconv.Itoa(http.StatusFailedDependency)
+			op.Response = ScimError(http.StatusFailedDependency, "", fmt.Sprintf("Rolled back because operation %d failed", failed))
+		}
+	}
+	return response, nil
+}
+
+func processBulkOperations(ctx context.Context, bulk *models.BulkRequest, location string, config *MapPropertySource) []models.BulkOperationResponse {
+	results := make([]models.BulkOperationResponse, 0, len(bulk.Operations))
+	bulkIds := map[string]string{}
+	failures := 0
+
+	for i := 0; i < len(bulk.Operations); {
+		if bulk.FailOnErrors > 0 && failures >= bulk.FailOnErrors {
+			break
+		}
+
+		end := i + 1
+		if strings.EqualFold(bulk.Operations[i].Method, http.MethodPost) && bulk.FailOnErrors == 0 {
+			pending := map[string]bool{bulk.Operations[i].BulkId: true}
+			for end < len(bulk.Operations) && strings.EqualFold(bulk.Operations[end].Method, http.MethodPost) &&
+				!referencesBulkIds(bulk.Operations[end], pending) {
+				pending[bulk.Operations[end].BulkId] = true
+				end++
+			}
+		}
+
+		var batch []models.BulkOperationResponse
+		if strings.EqualFold(bulk.Operations[i].Method, http.MethodPost) {
+			batch = postBulkStudents(ctx, bulk.Operations[i:end], bulkIds, location, config)
+		} else {
+			batch = []models.BulkOperationResponse{runBulkOperation(ctx, bulk.Operations[i], bulkIds, location, config)}
+		}
+
+		for _, r := range batch {
+			if isFailure(r.Status) {
+				failures++
+			}
+		}
+		results = append(results, batch...)
+		i = end
+	}
+	return results
+}
+
+// postBulkStudents creates every student of the batch with one SaveMany call,
+// in one transaction with their events, and matches the documents that failed
+// back to their operations.
+func postBulkStudents(ctx context.Context, ops []models.BulkOperation, bulkIds map[string]string, location string, config *MapPropertySource) []models.BulkOperationResponse {
+	results := make([]models.BulkOperationResponse, len(ops))
+	students := make([]*models.Student, len(ops))
+	indexes := make([]int, 0, len(ops))
+
+	for i, op := range ops {
+		results[i] = models.BulkOperationResponse{Method: http.MethodPost, BulkId: op.BulkId}
+		if op.BulkId == "" {
+			results[i] = bulkFailure(results[i], Error.MissingRequiredProperty("bulkId"))
+			continue
+		}
+		if strings.Trim(op.Path, "/") != "student" {
+			results[i] = bulkFailure(results[i], Error.InvalidPath(op.Path, "POST is only supported on /student"))
+			continue
+		}
+
+		data, err := resolveBulkIds(string(op.Data), bulkIds)
+		if err != nil {
+			results[i] = bulkFailure(results[i], err)
+			continue
+		}
+
+		var student models.Student
+		err = json.Unmarshal([]byte(data), &student)
+		if err != nil {
+			results[i] = bulkFailure(results[i], Error.InvalidParam("data", "a student", err.Error()))
+			continue
+		}
+		students[i] = &student
+		indexes = append(indexes, i)
+	}
+
+	failed := map[int]error{}
+	parsed := make([]*models.Student, len(indexes))
+	for k, i := range indexes {
+		parsed[k] = students[i]
+	}
+	duplicates, err := DuplicateEnrollmentNumbers(ctx, parsed, config)
+	if err != nil {
+		duplicates = map[int]error{}
+		for k := range parsed {
+			duplicates[k] = err
+		}
+	}
+	saved := make([]int, 0, len(indexes))
+	created := make([]*models.Student, 0, len(indexes))
+	for k, i := range indexes {
+		if err, ok := duplicates[k]; ok {
+			failed[i] = err
+			continue
+		}
+		NewStudent(students[i], config)
+		created = append(created, students[i])
+		saved = append(saved, i)
+	}
+
+	if len(created) > 0 {
+		rejected, err := saveStudentBatch(ctx, created, config)
+		for k, i := range saved {
+			if err != nil {
+				failed[i] = err
+			} else if err, ok := rejected[k]; ok {
+				failed[i] = err
+			}
+		}
+	}
+
+	for _, i := range indexes {
+		if err, ok := failed[i]; ok {
+			results[i] = bulkFailure(results[i], err)
+			continue
+		}
+		bulkIds[ops[i].BulkId] = students[i].Id
+		results[i].Status = strconv.Itoa(http.StatusCreated)
+		results[i].Location = location + "/student/" + students[i].Id
+		results[i].Response = students[i]
+	}
+	return results
+}
+
+func runBulkOperation(ctx context.Context, op models.BulkOperation, bulkIds map[string]string, location string, config *MapPropertySource) models.BulkOperationResponse {
+	method := strings.ToUpper(op.Method)
+	result := models.BulkOperationResponse{Method: method, BulkId: op.BulkId, Version: op.Version}
+
+	path, err := resolveBulkIds(op.Path, bulkIds)
+	if err != nil {
+		return bulkFailure(result, err)
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(path, "/"), "student/")
+	if id == "" || strings.Contains(id, "/") || id == strings.TrimPrefix(path, "/") {
+		return bulkFailure(result, Error.InvalidPath(op.Path, "expected /student/{id}"))
+	}
+	result.Location = location + "/student/" + id
+
+	data, err := resolveBulkIds(string(op.Data), bulkIds)
+	if err != nil {
+		return bulkFailure(result, err)
+	}
+
+	switch method {
+	case http.MethodPut:
+		var student models.Student
+		err = json.Unmarshal([]byte(data), &student)
+		if err != nil {
+			return bulkFailure(result, Error.InvalidParam("data", "a student", err.Error()))
+		}
+		student.Id = id
+		updated, created, err := PutStudent(ctx, &student, config)
+		if err != nil {
+			return bulkFailure(result, err)
+		}
+		result.Status = strconv.Itoa(http.StatusOK)
+		if created {
+			result.Status = strconv.Itoa(http.StatusCreated)
+		}
+		result.Response = updated
+
+	case http.MethodPatch:
+		var patchPayload models.PatchRequestPayload
+		err = json.Unmarshal([]byte(data), &patchPayload)
+		if err != nil {
+			return bulkFailure(result, Error.InvalidParam("data", "a patch request", err.Error()))
+		}
+		patchPayload.Id = id
+		patched, err := PatchStudent(ctx, &patchPayload, config)
+		if err != nil {
+			return bulkFailure(result, err)
+		}
+		result.Status = strconv.Itoa(http.StatusOK)
+		result.Response = patched
+
+	case http.MethodDelete:
+		err = DeleteStudent(ctx, id, config)
+		if err != nil {
+			return bulkFailure(result, err)
+		}
+		result.Status = strconv.Itoa(http.StatusNoContent)
+
+	default:
+		return bulkFailure(result, Error.InvalidParam("method", "POST, PUT, PATCH or DELETE", op.Method))
+	}
+	return result
+}
+
+// resolveBulkIds replaces every "bulkId:<id>" reference with the id of the
+// resource created by the operation carrying that bulkId.
+func resolveBulkIds(s string, bulkIds map[string]string) (string, error) {
+	var unresolved error
+	resolved := bulkIdReference.ReplaceAllStringFunc(s, func(ref string) string {
+		bulkId := strings.TrimPrefix(ref, "bulkId:")
+		if id, ok := bulkIds[bulkId]; ok {
+			return id
+		}
+		if unresolved == nil {
+			unresolved = Error.InvalidParam("bulkId", "a reference to a successful earlier operation", bulkId)
+		}
+		return ref
+	})
+	return resolved, unresolved
+}
+
+// referencesBulkIds tells whether the path or data of the operation refer to
+// any of the bulkIds.
+func referencesBulkIds(op models.BulkOperation, bulkIds map[string]bool) bool {
+	for _, ref := range bulkIdReference.FindAllStringSubmatch(op.Path+" "+string(op.Data), -1) {
+		if bulkIds[ref[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+func bulkFailure(result models.BulkOperationResponse, err error) models.BulkOperationResponse {
+	status := ErrorStatus(err)
+	result.Location = ""
+	result.Status = strconv.Itoa(status)
+	result.Response = ScimError(status, ErrorCode(err), err.Error())
+	return result
+}
+
+// scimTypes are the SCIM error types of the error codes that have one.
+var scimTypes = map[string]string{
+	"duplicate":                 "uniqueness",
+	"invalid_path":              "invalidPath",
+	"invalid_filter":            "invalidFilter",
+	"invalid_param":             "invalidValue",
+	"invalid_type":              "invalidValue",
+	"missing_required_property": "invalidValue",
+	"validation_failed":         "invalidValue",
+	"no_attribute":              "invalidPath",
+	"mutability_violation":      "mutability",
+	"payload_too_large":         "tooMany",
+}
+
+// ScimError is the SCIM error response for an error of the given code.
+func ScimError(status int, code, detail string) *models.BulkError {
+	return &models.BulkError{
+		Schemas:  []string{models.ErrorSchema},
+		ScimType: scimTypes[code],
+		Detail:   detail,
+		Status:   strconv.Itoa(status),
+	}
+}
+
+func isFailure(status string) bool {
+	code, _ := strconv.Atoi(status)
+	return code >= 400
+}
+
+func failedOperation(results []models.BulkOperationResponse) int {
+	for i, r := range results {
+		if isFailure(r.Status) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func bulkTest(t *testing.T) (*memoryStore, *MapPropertySource) {
+	InitConfigs()
+	store := useMemoryStore(t)
+	studentIndex = sync.Once{}
+	return store, GetConfigs()
+}
+
+func bulkPost(names ...string) *models.BulkRequest {
+	bulk := &models.BulkRequest{Schemas: []string{models.BulkRequestSchema}}
+	for i, name := range names {
+		data, _ := json.Marshal(models.Student{Name: name, EnrollmentNumber: fmt.Sprintf("E%d", i)})
+		bulk.Operations = append(bulk.Operations, models.BulkOperation{
+			Method: http.MethodPost,
+			BulkId: fmt.Sprintf("b%d", i),
+			Path:   "/student",
+			Data:   data,
+		})
+	}
+	return bulk
+}
+
+func outboxEntries(store *memoryStore, config *MapPropertySource) []models.OutboxEntry {
+	var entries []models.OutboxEntry
+	store.All(config.GetString("outbox-collection"), &entries)
+	return entries
+}
+
+func students(store *memoryStore, config *MapPropertySource) []models.Student {
+	var stored []models.Student
+	store.All(config.GetString("students-collection"), &stored)
+	return stored
+}
+
+func TestBulkStudentsAreCreatedWithTheirEvents(t *testing.T) {
+	store, config := bulkTest(t)
+
+	response, err := ProcessBulk(context.Background(), bulkPost("Ada", "Grace", "Linus"), "", config)
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	for _, op := range response.Operations {
+		if op.Status != strconv.Itoa(http.StatusCreated) {
+			t.Fatalf("operation %s = %s, want 201", op.BulkId, op.Status)
+		}
+	}
+	entries := outboxEntries(store, config)
+	if len(entries) != 3 {
+		t.Fatalf("%d events for 3 students, want 3", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Sequence != int64(i+1) || entry.Version != 1 {
+			t.Fatalf("event %d numbered %d, version %d, want %d, version 1", i, entry.Sequence, entry.Version, i+1)
+		}
+	}
+
+	// the next change of a student continues both counters
+	created := students(store, config)[0]
+	if err := recordStudentEvent(context.Background(), models.EventStudentUpdated, &created, config); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	entries = outboxEntries(store, config)
+	if last := entries[3]; last.Sequence != 4 || last.Version != 2 || last.AggregateId != created.Id {
+		t.Fatalf("update event numbered %d, version %d, want 4, version 2", last.Sequence, last.Version)
+	}
+}
+
+func TestBulkStudentsAreNotCreatedWithoutTheirEvents(t *testing.T) {
+	store, config := bulkTest(t)
+	store.Fail("Save", config.GetString("outbox-collection"), nil, errors.New("outbox down"))
+
+	response, err := ProcessBulk(context.Background(), bulkPost("Ada", "Grace"), "", config)
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	for _, op := range response.Operations {
+		if !isFailure(op.Status) {
+			t.Fatalf("operation %s = %s, want it failed with the event", op.BulkId, op.Status)
+		}
+	}
+	if stored := students(store, config); len(stored) != 0 {
+		t.Fatalf("%d students created without their events", len(stored))
+	}
+	if entries := outboxEntries(store, config); len(entries) != 0 {
+		t.Fatalf("%d events kept of a rolled back batch", len(entries))
+	}
+}
+
+func TestBulkStudentRejectedByTheIndexLeavesTheOthers(t *testing.T) {
+	store, config := bulkTest(t)
+	// another request took the enrollment number after it was checked
+	store.Fail("Save", config.GetString("students-collection"), mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{
+		WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error index: enrollmentNumber_1"},
+	}}})
+
+	response, err := ProcessBulk(context.Background(), bulkPost("Ada", "Grace", "Linus"), "", config)
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	want := []int{http.StatusCreated, http.StatusConflict, http.StatusCreated}
+	for i, op := range response.Operations {
+		if op.Status != strconv.Itoa(want[i]) {
+			t.Fatalf("operation %s = %s, want %d", op.BulkId, op.Status, want[i])
+		}
+	}
+	if stored := students(store, config); len(stored) != 2 {
+		t.Fatalf("%d students created, want 2", len(stored))
+	}
+	if entries := outboxEntries(store, config); len(entries) != 2 || entries[1].Sequence != 2 {
+		t.Fatalf("events = %+v, want one for each created student", entries)
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+func ParseCourse(ctx context.Context, req HttpWebRequest) (*models.Course, error) {
+	var course models.Course
+
+	err := req.Decode(&course)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+
+	id := req.Param("id")
+	if id != "" {
+		course.Id = id
+	}
+	return &course, nil
+}
+
+func SaveCourse(ctx context.Context, course *models.Course, config *MapPropertySource) (*models.Course, error) {
+	if course.Capacity < 0 {
+		return nil, Error.InvalidParam("capacity", "a non negative number", "a negative number")
+	}
+	if course.GradingScale == "" {
+		course.GradingScale = config.GetString("default-grading-scale")
+	}
+	if _, ok := GetGradingScale(course.GradingScale); !ok {
+		return nil, Error.InvalidParam("gradingScale", "a registered grading scale", course.GradingScale)
+	}
+	course.Id = uuid.NewV4().String()
+	course.Enrolled = 0
+	course.Meta.ResourceType = "Course"
+	course.Meta.Created = time.Now()
+	course.Meta.LastModified = time.Now()
+
+	err := datastore.GetDatastore().Save(ctx, config.GetString("courses-collection"), course)
+	if err != nil {
+		return nil, err
+	}
+	return course, nil
+}
+
+func GetCourse(ctx context.Context, course *models.Course, config *MapPropertySource) error {
+	filter := bson.D{{"id", course.Id}}
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("courses-collection"), filter, course)
+	if datastore.IsNotFound(err) {
+		return Error.ResourceNotFound(course.Id, "")
+	}
+	return err
+}
+
+// GetCourses loads the courses with the given ids with a single query. Ids
+// without a course are left out.
+func GetCourses(ctx context.Context, ids []string, config *MapPropertySource) ([]models.Course, error) {
+	courses := make([]models.Course, 0)
+	if len(ids) == 0 {
+		return courses, nil
+	}
+	in := bson.A{}
+	for _, id := range ids {
+		in = append(in, id)
+	}
+	filter := bson.D{{"id", bson.D{{"$in", in}}}}
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("courses-collection"), filter, nil, &courses)
+	if err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+// claimSeat atomically takes one seat in the course if there is one left. The
+// capacity check is part of the update filter so concurrent enrollments can
+// never push the course over its capacity.
+func claimSeat(ctx context.Context, courseId string, config *MapPropertySource) (bool, error) {
+	filter := bson.D{
+		{"id", courseId},
+		{"$expr", bson.D{{"$lt", bson.A{"$enrolled", "$capacity"}}}},
+	}
+	query := bson.D{
+		{"$inc", bson.D{{"enrolled", 1}}},
+		{"$set", bson.D{{"meta.lastModified", time.Now()}}},
+	}
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("courses-collection"), filter, query)
+	if err != nil {
+		return false, err
+	}
+	return matched > 0, nil
+}
+
+func releaseSeat(ctx context.Context, courseId string, config *MapPropertySource) error {
+	filter := bson.D{
+		{"id", courseId},
+		{"enrolled", bson.D{{"$gt", 0}}},
+	}
+	query := bson.D{
+		{"$inc", bson.D{{"enrolled", -1}}},
+		{"$set", bson.D{{"meta.lastModified", time.Now()}}},
+	}
+	return datastore.GetDatastore().Update(ctx, config.GetString("courses-collection"), filter, query)
+}
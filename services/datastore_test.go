@@ -0,0 +1,13 @@
+package service
+
+import (
+	"awesomeTestProject/datastore/datastoretest"
+	"testing"
+)
+
+// memoryStore is the in-memory datastore the service tests run on.
+type memoryStore = datastoretest.Store
+
+func useMemoryStore(t *testing.T) *memoryStore {
+	return datastoretest.Use(t)
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+var activeEnrollmentStatuses = bson.A{models.EnrollmentEnrolled, models.EnrollmentWaitlisted}
+
+var enrollmentIndex sync.Once
+
+// ensureEnrollmentIndex makes mongo refuse a second active enrollment of a
+// student in a course, which the check in EnrollStudent alone can not do for
+// concurrent requests.
+func ensureEnrollmentIndex(ctx context.Context, config *MapPropertySource) {
+	enrollmentIndex.Do(func() {
+		collection := config.GetString("enrollments-collection")
+		keys := bson.D{{"studentId", 1}, {"courseId", 1}}
+		err := datastore.GetDatastore().EnsureUnique(ctx, collection, keys, bson.D{{"active", true}})
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the active enrollment index of %s: %s", collection, err.Error()))
+		}
+	})
+}
+
+func ParseEnrollment(ctx context.Context, req HttpWebRequest) (*models.Enrollment, error) {
+	var enrollment models.Enrollment
+
+	err := req.Decode(&enrollment)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+
+	enrollment.CourseId = req.Param("id")
+	return &enrollment, nil
+}
+
+// EnrollStudent enrolls the student into the course, or puts them on the
+// course waitlist when every seat is taken.
+func EnrollStudent(ctx context.Context, enrollment *models.Enrollment, config *MapPropertySource) (*models.Enrollment, error) {
+	if enrollment.StudentId == "" {
+		return nil, Error.MissingRequiredProperty("studentId")
+	}
+
+	var student models.Student
+	student.Id = enrollment.StudentId
+	err := GetStudent(ctx, &student, config)
+	if _, ok := err.(*ResourceNotFoundError); ok {
+		return nil, Error.InvalidParam("studentId", "an existing student", enrollment.StudentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var course models.Course
+	course.Id = enrollment.CourseId
+	err = GetCourse(ctx, &course, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureEnrollmentIndex(ctx, config)
+	var existing models.Enrollment
+	filter := bson.D{
+		{"studentId", enrollment.StudentId},
+		{"courseId", enrollment.CourseId},
+		{"status", bson.D{{"$in", activeEnrollmentStatuses}}},
+	}
+	err = datastore.GetDatastore().GetById(ctx, config.GetString("enrollments-collection"), filter, &existing)
+	if err == nil {
+		return nil, Error.Duplicate("studentId", enrollment.StudentId)
+	}
+	if !datastore.IsNotFound(err) {
+		return nil, err
+	}
+
+	seated, err := claimSeat(ctx, course.Id, config)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	enrollment.Id = uuid.NewV4().String()
+	enrollment.Status = models.EnrollmentWaitlisted
+	enrollment.Active = true
+	enrollment.DropDate = nil
+	enrollment.WithdrawDate = nil
+	enrollment.EnrolledDate = nil
+	if seated {
+		enrollment.Status = models.EnrollmentEnrolled
+		enrollment.EnrolledDate = &now
+	}
+	enrollment.Meta.ResourceType = "Enrollment"
+	enrollment.Meta.Created = now
+	enrollment.Meta.LastModified = now
+
+	err = datastore.GetDatastore().Save(ctx, config.GetString("enrollments-collection"), enrollment)
+	if err != nil {
+		if seated {
+			_ = releaseSeat(ctx, course.Id, config)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, Error.Duplicate("studentId", enrollment.StudentId)
+		}
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+func GetStudentEnrollments(ctx context.Context, studentId string, config *MapPropertySource) ([]models.Enrollment, error) {
+	filter := bson.D{{"studentId", studentId}}
+	return getEnrollments(ctx, filter, config)
+}
+
+func GetCourseEnrollments(ctx context.Context, courseId string, config *MapPropertySource) ([]models.Enrollment, error) {
+	filter := bson.D{{"courseId", courseId}}
+	return getEnrollments(ctx, filter, config)
+}
+
+// GetStudentsEnrollments loads the enrollments of several students with a
+// single query, keyed by student id.
+func GetStudentsEnrollments(ctx context.Context, studentIds []string, config *MapPropertySource) (map[string][]models.Enrollment, error) {
+	ids := bson.A{}
+	for _, id := range studentIds {
+		ids = append(ids, id)
+	}
+	enrollments, err := getEnrollments(ctx, bson.D{{"studentId", bson.D{{"$in", ids}}}}, config)
+	if err != nil {
+		return nil, err
+	}
+
+	byStudent := make(map[string][]models.Enrollment, len(studentIds))
+	for _, e := range enrollments {
+		byStudent[e.StudentId] = append(byStudent[e.StudentId], e)
+	}
+	return byStudent, nil
+}
+
+func getEnrollments(ctx context.Context, filter interface{}, config *MapPropertySource) ([]models.Enrollment, error) {
+	enrollments := make([]models.Enrollment, 0)
+	opt := options.Find().SetSort(bson.D{{"meta.created", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("enrollments-collection"), filter, opt, &enrollments)
+	if err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
+
+func GetEnrollment(ctx context.Context, enrollment *models.Enrollment, config *MapPropertySource) error {
+	filter := bson.D{{"id", enrollment.Id}, {"courseId", enrollment.CourseId}}
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("enrollments-collection"), filter, enrollment)
+	if datastore.IsNotFound(err) {
+		return Error.ResourceNotFound(enrollment.Id, "")
+	}
+	return err
+}
+
+// DropEnrollment removes the student from the course before the drop deadline.
+func DropEnrollment(ctx context.Context, enrollment *models.Enrollment, config *MapPropertySource) (*models.Enrollment, error) {
+	return leaveCourse(ctx, enrollment, models.EnrollmentDropped, config)
+}
+
+// WithdrawEnrollment removes the student from the course after the drop
+// deadline but before the withdraw deadline.
+func WithdrawEnrollment(ctx context.Context, enrollment *models.Enrollment, config *MapPropertySource) (*models.Enrollment, error) {
+	return leaveCourse(ctx, enrollment, models.EnrollmentWithdrawn, config)
+}
+
+func leaveCourse(ctx context.Context, enrollment *models.Enrollment, status string, config *MapPropertySource) (*models.Enrollment, error) {
+	err := GetEnrollment(ctx, enrollment, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var course models.Course
+	course.Id = enrollment.CourseId
+	err = GetCourse(ctx, &course, config)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dateField := "dropDate"
+	deadline := course.DropDeadline
+	if status == models.EnrollmentWithdrawn {
+		dateField = "withdrawDate"
+		deadline = course.WithdrawDeadline
+	}
+	if !deadline.IsZero() && now.After(deadline) {
+		return nil, Error.InvalidParam(dateField, fmt.Sprintf("before %s", deadline.Format(time.RFC3339)), now.Format(time.RFC3339))
+	}
+
+	seated, err := setEnrollmentInactive(ctx, enrollment, status, dateField, now, config)
+	if err != nil {
+		return nil, err
+	}
+	if seated {
+		err = passSeat(ctx, course.Id, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = GetEnrollment(ctx, enrollment, config)
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// setEnrollmentInactive moves an active enrollment to the given status and
+// reports whether it was holding a seat. The previous status is part of the
+// filter so a concurrent drop of the same enrollment only releases one seat.
+func setEnrollmentInactive(ctx context.Context, enrollment *models.Enrollment, status, dateField string, now time.Time, config *MapPropertySource) (bool, error) {
+	previous := enrollment.Status
+	if previous != models.EnrollmentEnrolled && previous != models.EnrollmentWaitlisted {
+		return false, Error.InvalidParam("status", "an active enrollment", previous)
+	}
+
+	filter := bson.D{{"id", enrollment.Id}, {"status", previous}}
+	query := bson.D{{"$set", bson.D{
+		{"status", status},
+		{"active", false},
+		{dateField, now},
+		{"meta.lastModified", now},
+	}}}
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("enrollments-collection"), filter, query)
+	if err != nil {
+		return false, err
+	}
+	if matched == 0 {
+		return false, Error.InvalidParam("status", previous, "a concurrently modified enrollment")
+	}
+	return previous == models.EnrollmentEnrolled, nil
+}
+
+// passSeat hands the seat a leaving enrollment held to the oldest waitlisted
+// enrollment of the course. The seat stays taken while it changes hands, so a
+// new enrollment can not get it ahead of the waitlist. Only when nobody is
+// waiting is the seat given back to the course.
+func passSeat(ctx context.Context, courseId string, config *MapPropertySource) error {
+	now := time.Now()
+	filter := bson.D{{"courseId", courseId}, {"status", models.EnrollmentWaitlisted}}
+	query := bson.D{{"$set", bson.D{
+		{"status", models.EnrollmentEnrolled},
+		{"enrolledDate", now},
+		{"meta.lastModified", now},
+	}}}
+	var promoted models.Enrollment
+	err := datastore.GetDatastore().FindOneAndUpdate(ctx, config.GetString("enrollments-collection"), filter, query,
+		bson.D{{"meta.created", 1}}, &promoted)
+	if !datastore.IsNotFound(err) {
+		return err
+	}
+
+	err = releaseSeat(ctx, courseId, config)
+	if err != nil {
+		return err
+	}
+	// a student waitlisted since the lookup above gets the seat
+	return promoteWaitlist(ctx, courseId, config)
+}
+
+// promoteWaitlist fills free seats of the course from its waitlist, oldest
+// request first.
+func promoteWaitlist(ctx context.Context, courseId string, config *MapPropertySource) error {
+	filter := bson.D{{"courseId", courseId}, {"status", models.EnrollmentWaitlisted}}
+	opt := options.Find().SetSort(bson.D{{"meta.created", 1}}).SetLimit(1)
+
+	for {
+		var waiting []models.Enrollment
+		err := datastore.GetDatastore().GetAll(ctx, config.GetString("enrollments-collection"), filter, opt, &waiting)
+		if err != nil {
+			return err
+		}
+		if len(waiting) == 0 {
+			return nil
+		}
+		next := waiting[0]
+
+		seated, err := claimSeat(ctx, courseId, config)
+		if err != nil || !seated {
+			return err
+		}
+
+		now := time.Now()
+		query := bson.D{{"$set", bson.D{
+			{"status", models.EnrollmentEnrolled},
+			{"enrolledDate", now},
+			{"meta.lastModified", now},
+		}}}
+		matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("enrollments-collection"),
+			bson.D{{"id", next.Id}, {"status", models.EnrollmentWaitlisted}}, query)
+		if err != nil {
+			return err
+		}
+		if matched > 0 {
+			return nil
+		}
+		// The waitlisted enrollment went away under us, give the seat back and
+		// try the next one in line.
+		err = releaseSeat(ctx, courseId, config)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReleaseStudentEnrollments applies the configured student-delete-policy to
+// the enrollments of a student that is about to be deleted. With "block" the
+// deletion is refused while the student has active enrollments, with
+// "cascade" the enrollments are removed and their seats given back.
+func ReleaseStudentEnrollments(ctx context.Context, studentId string, config *MapPropertySource) error {
+	enrollments, err := GetStudentEnrollments(ctx, studentId, config)
+	if err != nil {
+		return err
+	}
+
+	policy := config.GetString("student-delete-policy")
+	if policy != "cascade" {
+		for _, e := range enrollments {
+			if e.Status == models.EnrollmentEnrolled || e.Status == models.EnrollmentWaitlisted {
+				return Error.ReferenceViolation("Student", studentId, "student has active enrollments")
+			}
+		}
+		return nil
+	}
+
+	err = dropActiveEnrollments(ctx, enrollments, config)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{"studentId", studentId}}
+	return datastore.GetDatastore().DeleteMany(ctx, config.GetString("enrollments-collection"), filter)
+}
+
+// DropStudentEnrollments drops every active enrollment of the student, giving
+// their seats to the course waitlists.
+func DropStudentEnrollments(ctx context.Context, studentId string, config *MapPropertySource) error {
+	enrollments, err := GetStudentEnrollments(ctx, studentId, config)
+	if err != nil {
+		return err
+	}
+	return dropActiveEnrollments(ctx, enrollments, config)
+}
+
+func dropActiveEnrollments(ctx context.Context, enrollments []models.Enrollment, config *MapPropertySource) error {
+	for i := range enrollments {
+		e := &enrollments[i]
+		if e.Status != models.EnrollmentEnrolled && e.Status != models.EnrollmentWaitlisted {
+			continue
+		}
+		seated, err := setEnrollmentInactive(ctx, e, models.EnrollmentDropped, "dropDate", time.Now(), config)
+		if err != nil {
+			return err
+		}
+		if seated {
+			err = passSeat(ctx, e.CourseId, config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func enrollmentTest(t *testing.T, capacity int) (*memoryStore, *MapPropertySource, *models.Course) {
+	InitConfigs()
+	config := GetConfigs()
+	store := useMemoryStore(t)
+	studentIndex = sync.Once{}
+	enrollmentIndex = sync.Once{}
+	course, err := SaveCourse(context.Background(), &models.Course{Name: "Compilers", Capacity: capacity}, config)
+	if err != nil {
+		t.Fatalf("course: %v", err)
+	}
+	return store, config, course
+}
+
+func enrolledStudents(t *testing.T, n int, config *MapPropertySource) []*models.Student {
+	created := make([]*models.Student, n)
+	for i := range created {
+		student, err := SaveStudent(context.Background(), &models.Student{Name: fmt.Sprintf("S%d", i), EnrollmentNumber: fmt.Sprintf("E%d", i)}, config)
+		if err != nil {
+			t.Fatalf("student: %v", err)
+		}
+		created[i] = student
+	}
+	return created
+}
+
+func courseEnrollments(store *memoryStore, config *MapPropertySource) map[string]int {
+	var stored []models.Enrollment
+	store.All(config.GetString("enrollments-collection"), &stored)
+	count := map[string]int{}
+	for _, e := range stored {
+		count[e.Status]++
+	}
+	return count
+}
+
+func seatsTaken(t *testing.T, courseId string, config *MapPropertySource) int {
+	course := models.Course{Id: courseId}
+	if err := GetCourse(context.Background(), &course, config); err != nil {
+		t.Fatalf("course: %v", err)
+	}
+	return course.Enrolled
+}
+
+func TestConcurrentEnrollmentsKeepToTheCapacity(t *testing.T) {
+	store, config, course := enrollmentTest(t, 3)
+	students := enrolledStudents(t, 12, config)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(students))
+	for _, student := range students {
+		wg.Add(1)
+		go func(studentId string) {
+			defer wg.Done()
+			_, err := EnrollStudent(context.Background(), &models.Enrollment{StudentId: studentId, CourseId: course.Id}, config)
+			errs <- err
+		}(student.Id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("enroll: %v", err)
+		}
+	}
+
+	count := courseEnrollments(store, config)
+	if count[models.EnrollmentEnrolled] != 3 || count[models.EnrollmentWaitlisted] != 9 {
+		t.Fatalf("enrollments = %v, want 3 enrolled and 9 waitlisted", count)
+	}
+	if taken := seatsTaken(t, course.Id, config); taken != 3 {
+		t.Fatalf("%d seats taken of 3", taken)
+	}
+}
+
+func TestConcurrentEnrollmentsOfAStudentKeepOne(t *testing.T) {
+	store, config, course := enrollmentTest(t, 5)
+	student := enrolledStudents(t, 1, config)[0]
+	// every request checks for an enrollment before any of them is saved
+	collection := config.GetString("enrollments-collection")
+	notFound := make([]error, 8)
+	for i := range notFound {
+		notFound[i] = &datastore.NotFoundError{Collection: collection}
+	}
+	store.Fail("GetById", collection, notFound...)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := EnrollStudent(context.Background(), &models.Enrollment{StudentId: student.Id, CourseId: course.Id}, config)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		var duplicate *DuplicateError
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.As(err, &duplicate):
+			t.Fatalf("enroll: %v, want DuplicateError", err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Fatalf("%d enrollments of one student succeeded, want 1", succeeded)
+	}
+	if count := courseEnrollments(store, config); count[models.EnrollmentEnrolled] != 1 {
+		t.Fatalf("enrollments = %v, want the student enrolled once", count)
+	}
+	// the refused requests give back the seats they claimed
+	if taken := seatsTaken(t, course.Id, config); taken != 1 {
+		t.Fatalf("%d seats taken by one student", taken)
+	}
+}
+
+func TestDroppedSeatGoesToTheWaitlist(t *testing.T) {
+	store, config, course := enrollmentTest(t, 1)
+	students := enrolledStudents(t, 3, config)
+	var enrollments []*models.Enrollment
+	for _, student := range students {
+		enrollment, err := EnrollStudent(context.Background(), &models.Enrollment{StudentId: student.Id, CourseId: course.Id}, config)
+		if err != nil {
+			t.Fatalf("enroll: %v", err)
+		}
+		enrollments = append(enrollments, enrollment)
+	}
+
+	if _, err := DropEnrollment(context.Background(), &models.Enrollment{Id: enrollments[0].Id, CourseId: course.Id}, config); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	next := models.Enrollment{Id: enrollments[1].Id, CourseId: course.Id}
+	if err := GetEnrollment(context.Background(), &next, config); err != nil || next.Status != models.EnrollmentEnrolled {
+		t.Fatalf("first waitlisted = %+v, %v, want enrolled", next, err)
+	}
+	if count := courseEnrollments(store, config); count[models.EnrollmentEnrolled] != 1 || count[models.EnrollmentWaitlisted] != 1 {
+		t.Fatalf("enrollments = %v, want 1 enrolled and 1 waitlisted", count)
+	}
+	if taken := seatsTaken(t, course.Id, config); taken != 1 {
+		t.Fatalf("%d seats taken of 1", taken)
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	"awesomeTestProject/outbox"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	EventSourceAuto         = "auto"
+	EventSourceChangeStream = "changestream"
+	EventSourceBus          = "bus"
+)
+
+// ParseLastEventId reads the Last-Event-ID a reconnecting event stream client
+// sends, the sequence of the last event it received. It is 0 for a new client.
+func ParseLastEventId(req HttpWebRequest) (int64, error) {
+	v := req.Header("Last-Event-ID")
+	if v == "" {
+		return 0, nil
+	}
+	sequence, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || sequence < 0 {
+		return 0, Error.InvalidParam("Last-Event-ID", "integer", v)
+	}
+	return sequence, nil
+}
+
+// StreamStudentEvents writes the student events matching the list parameters
+// to w as server-sent events until ctx is done. A client resuming after
+// lastEventId first gets the events it missed, as far as the outbox still
+// keeps them. Events are followed with a change stream on the outbox
+// collection when student-events-source allows and mongo supports it, from
+// the outbox bus otherwise, which only sees events on the replica running the
+// relay.
+func StreamStudentEvents(ctx context.Context, params map[string]interface{}, lastEventId int64, w io.Writer, config *MapPropertySource) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed, err := followStudentEvents(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer feed.close()
+	Flush(w)
+
+	replayed := map[string]bool{}
+	if lastEventId > 0 {
+		filter := bson.D{{"type", bson.D{{"$in", WebhookEvents}}}, {"sequence", bson.D{{"$gt", lastEventId}}}}
+		opt := options.Find().SetSort(bson.D{{"sequence", 1}})
+		var entry models.OutboxEntry
+		err = datastore.GetDatastore().Iterate(ctx, config.GetString("outbox-collection"), filter, opt, &entry, func(doc interface{}) error {
+			event := doc.(*models.OutboxEntry).Event
+			replayed[event.Id] = true
+			return writeStudentEvent(w, event, params)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	heartbeat := time.NewTicker(time.Duration(config.GetInt("student-events-heartbeat")) * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-feed.failed:
+			return err
+		case event := <-feed.events:
+			if replayed[event.Id] {
+				continue
+			}
+			if err := writeStudentEvent(w, event, params); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+			Flush(w)
+		}
+	}
+}
+
+// writeStudentEvent writes an event as a server-sent event, unless the
+// student it carries does not match the list parameters.
+func writeStudentEvent(w io.Writer, event models.Event, params map[string]interface{}) error {
+	ok, err := matchesStudentEvent(event, params)
+	if err != nil || !ok {
+		return err
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, b)
+	if err != nil {
+		return err
+	}
+	Flush(w)
+	return nil
+}
+
+// matchesStudentEvent applies the attribute filters of a list query to the
+// student of an event. Sorting and projection do not apply to events.
+func matchesStudentEvent(event models.Event, params map[string]interface{}) (bool, error) {
+	var nested map[string]interface{}
+	if err := json.Unmarshal(event.Data, &nested); err != nil {
+		return false, err
+	}
+	values := map[string]interface{}{}
+	flattenValues(nested, "", values)
+	for k, v := range params {
+		if k == "sortorder" || k == "sortfield" || k == "attributes" || k == "limit" || k == "offset" {
+			continue
+		}
+		if formatExportValue(values[k]) != formatExportValue(v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// studentEventFeed hands over the student events recorded after it was
+// opened. It fails when the change stream breaks, or when the client does not
+// keep up with the bus and events were dropped; the client then resumes with
+// its Last-Event-ID.
+type studentEventFeed struct {
+	events chan models.Event
+	failed chan error
+	close  func()
+}
+
+func followStudentEvents(ctx context.Context, config *MapPropertySource) (*studentEventFeed, error) {
+	feed := &studentEventFeed{
+		events: make(chan models.Event, config.GetInt("student-events-buffer")),
+		failed: make(chan error, 1),
+	}
+
+	source := config.GetString("student-events-source")
+	switch source {
+	case EventSourceAuto, EventSourceChangeStream:
+		err := watchStudentEvents(ctx, feed, config)
+		if err == nil {
+			return feed, nil
+		}
+		if source == EventSourceChangeStream {
+			return nil, err
+		}
+		Warn(ctx, fmt.Sprintf("Change streams are unavailable, following student events on the bus: %s", err.Error()))
+	case EventSourceBus:
+	default:
+		return nil, Error.InvalidParam("student-events-source", "auto, changestream or bus", source)
+	}
+
+	var dropped sync.Once
+	feed.close = outbox.Subscribe("*", func(_ context.Context, event models.Event) error {
+		if !isStudentEvent(event.Type) {
+			return nil
+		}
+		select {
+		case feed.events <- event:
+		default:
+			dropped.Do(func() {
+				feed.failed <- Error.Text("the event stream fell more than %d events behind", cap(feed.events))
+			})
+		}
+		// a slow client must not hold up the relay
+		return nil
+	})
+	return feed, nil
+}
+
+// watchStudentEvents follows the inserts into the outbox collection.
+func watchStudentEvents(ctx context.Context, feed *studentEventFeed, config *MapPropertySource) error {
+	pipeline := bson.A{bson.D{{"$match", bson.D{
+		{"operationType", "insert"},
+		{"fullDocument.type", bson.D{{"$in", WebhookEvents}}},
+	}}}}
+	stream, err := datastore.GetDatastore().Watch(ctx, config.GetString("outbox-collection"), pipeline)
+	if err != nil {
+		return err
+	}
+	feed.close = func() {}
+
+	go func() {
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument models.OutboxEntry `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				feed.failed <- err
+				return
+			}
+			select {
+			case feed.events <- change.FullDocument.Event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			feed.failed <- err
+		}
+	}()
+	return nil
+}
+
+func isStudentEvent(eventType string) bool {
+	for _, t := range WebhookEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
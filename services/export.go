@@ -0,0 +1,357 @@
+package service
+
+import (
+	"archive/zip"
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+var exportContentTypes = map[string]string{
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportNDJSON: "application/x-ndjson",
+	ExportXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportWriter writes a table row by row. Values are keyed by the flattened
+// dotted attribute name.
+type exportWriter interface {
+	Header(columns []string) error
+	Row(columns []string, values map[string]interface{}) error
+	Close() error
+}
+
+// ParseExportFormat picks the export format from the format parameter, or
+// from the Accept header when there is no parameter. CSV is the default.
+func ParseExportFormat(req HttpWebRequest) (string, error) {
+	if format := req.Param("format"); format != "" {
+		if _, ok := exportContentTypes[format]; !ok {
+			return "", Error.InvalidParam("format", "csv, ndjson or xlsx", format)
+		}
+		return format, nil
+	}
+
+	accept := req.Header("Accept")
+	if accept == "" {
+		return ExportCSV, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case "*/*", "text/*", "text/csv":
+			return ExportCSV, nil
+		case "application/x-ndjson", "application/ndjson":
+			return ExportNDJSON, nil
+		case exportContentTypes[ExportXLSX]:
+			return ExportXLSX, nil
+		}
+	}
+	return "", Error.NotAcceptable(accept, []string{"text/csv", "application/x-ndjson", exportContentTypes[ExportXLSX]})
+}
+
+func ExportContentType(format string) string {
+	return exportContentTypes[format]
+}
+
+// ExportStudents streams the students matching the list parameters to w. The
+// students are read from a cursor and written one at a time, so memory use
+// does not grow with the number of students.
+func ExportStudents(ctx context.Context, params map[string]interface{}, format string, w io.Writer, config *MapPropertySource) error {
+	filter, opt, err := GetFilter(params)
+	if err != nil {
+		return err
+	}
+
+	columns := flattenColumns(reflect.TypeOf(models.Student{}), "")
+	if v, ok := params["attributes"]; ok {
+		columns = selectColumns(columns, strings.Split(v.(string), ","))
+	}
+
+	var out exportWriter
+	switch format {
+	case ExportNDJSON:
+		out = &ndjsonExportWriter{w: bufio.NewWriter(w)}
+	case ExportXLSX:
+		out = &xlsxExportWriter{zw: zip.NewWriter(w)}
+	default:
+		out = &csvExportWriter{w: csv.NewWriter(w)}
+	}
+
+	err = out.Header(columns)
+	if err != nil {
+		return err
+	}
+
+	var student models.Student
+	err = datastore.GetDatastore().Iterate(ctx, config.GetString("students-collection"), filter, opt, &student, func(doc interface{}) error {
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		var nested map[string]interface{}
+		err = json.Unmarshal(b, &nested)
+		if err != nil {
+			return err
+		}
+		values := map[string]interface{}{}
+		flattenValues(nested, "", values)
+		return out.Row(columns, values)
+	})
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// flattenColumns lists the dotted json names of every leaf attribute of t.
+func flattenColumns(t reflect.Type, prefix string) []string {
+	columns := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			columns = append(columns, flattenColumns(ft, prefix+name+".")...)
+			continue
+		}
+		columns = append(columns, prefix+name)
+	}
+	return columns
+}
+
+func selectColumns(columns, attributes []string) []string {
+	selected := make([]string, 0)
+	for _, c := range columns {
+		for _, a := range attributes {
+			a = strings.TrimSpace(a)
+			if c == a || strings.HasPrefix(c, a+".") {
+				selected = append(selected, c)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+func flattenValues(nested map[string]interface{}, prefix string, values map[string]interface{}) {
+	for k, v := range nested {
+		if m, ok := v.(map[string]interface{}); ok {
+			flattenValues(m, prefix+k+".", values)
+			continue
+		}
+		values[prefix+k] = v
+	}
+}
+
+func formatExportValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvExportWriter) Header(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvExportWriter) Row(columns []string, values map[string]interface{}) error {
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = formatExportValue(values[column])
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvExportWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonExportWriter keeps the nesting of the documents, only the selected
+// attributes are written.
+type ndjsonExportWriter struct {
+	w *bufio.Writer
+}
+
+func (n *ndjsonExportWriter) Header(columns []string) error {
+	return nil
+}
+
+func (n *ndjsonExportWriter) Row(columns []string, values map[string]interface{}) error {
+	doc := map[string]interface{}{}
+	for _, column := range columns {
+		v, ok := values[column]
+		if !ok {
+			continue
+		}
+		parts := strings.Split(column, ".")
+		node := doc
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := node[p].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[p] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = v
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = n.w.Write(append(b, '\n'))
+	return err
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return n.w.Flush()
+}
+
+// xlsxExportWriter writes a single sheet workbook. The sheet is streamed into
+// the zip archive with inline strings, so no shared string table has to be
+// held in memory.
+type xlsxExportWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+func (x *xlsxExportWriter) Header(columns []string) error {
+	parts := [][2]string{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="students" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+	}
+	for _, part := range parts {
+		w, err := x.create(part[0])
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, part[1]); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := x.create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = sheet
+	_, err = io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		values[column] = column
+	}
+	return x.Row(columns, values)
+}
+
+func (x *xlsxExportWriter) create(name string) (io.Writer, error) {
+	return x.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+func (x *xlsxExportWriter) Row(columns []string, values map[string]interface{}) error {
+	x.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.row)
+	for i, column := range columns {
+		ref := xlsxColumn(i) + strconv.Itoa(x.row)
+		switch v := values[column].(type) {
+		case nil:
+			continue
+		case float64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			value := 0
+			if v {
+				value = 1
+			}
+			fmt.Fprintf(&b, `<c r="%s" t="b"><v>%d</v></c>`, ref, value)
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			_ = xml.EscapeText(&b, []byte(formatExportValue(v)))
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	_, err := io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	if err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumn converts a zero based column index to its spreadsheet letters.
+func xlsxColumn(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
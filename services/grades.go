@@ -0,0 +1,365 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var gradingScalesLock sync.RWMutex
+
+// gradingScales holds the scales a course can be graded with, keyed by the
+// name referenced from Course.GradingScale. Deployments can add their own
+// through RegisterGradingScale or the grading-scales configuration.
+var gradingScales = map[string]models.GradingScale{
+	models.ScaleLetter: {
+		Name: models.ScaleLetter,
+		Type: models.ScaleLetter,
+		Bands: []models.GradeBand{
+			{Mark: "A", MinPercent: 90, Points: 4.0, Passing: true},
+			{Mark: "B", MinPercent: 80, Points: 3.0, Passing: true},
+			{Mark: "C", MinPercent: 70, Points: 2.0, Passing: true},
+			{Mark: "D", MinPercent: 60, Points: 1.0, Passing: true},
+			{Mark: "F", MinPercent: 0, Points: 0.0, Passing: false},
+		},
+	},
+	models.ScalePercentage: {
+		Name: models.ScalePercentage,
+		Type: models.ScalePercentage,
+		Bands: []models.GradeBand{
+			{Mark: "A", MinPercent: 90, Points: 4.0, Passing: true},
+			{Mark: "B", MinPercent: 80, Points: 3.0, Passing: true},
+			{Mark: "C", MinPercent: 70, Points: 2.0, Passing: true},
+			{Mark: "D", MinPercent: 60, Points: 1.0, Passing: true},
+			{Mark: "F", MinPercent: 0, Points: 0.0, Passing: false},
+		},
+	},
+	models.ScalePassFail: {
+		Name: models.ScalePassFail,
+		Type: models.ScalePassFail,
+		Bands: []models.GradeBand{
+			{Mark: "P", MinPercent: 50, Passing: true},
+			{Mark: "F", MinPercent: 0, Passing: false},
+		},
+	},
+}
+
+func RegisterGradingScale(scale models.GradingScale) {
+	bands := append([]models.GradeBand(nil), scale.Bands...)
+	sort.SliceStable(bands, func(i, j int) bool {
+		return bands[i].MinPercent > bands[j].MinPercent
+	})
+	scale.Bands = bands
+
+	gradingScalesLock.Lock()
+	defer gradingScalesLock.Unlock()
+	gradingScales[scale.Name] = scale
+}
+
+// LoadGradingScales registers the scales of the grading-scales
+// configuration, written as "name:type@passPercent>mark=minPercent/points|
+// mark=minPercent/points;name:type@passPercent>...". Bands from passPercent
+// up are passing, points may be left out.
+func LoadGradingScales(config *MapPropertySource) error {
+	var scales []models.GradingScale
+	for _, rule := range strings.Split(config.GetString("grading-scales"), ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		scale, err := parseGradingScale(rule)
+		if err != nil {
+			return err
+		}
+		scales = append(scales, scale)
+	}
+	for _, scale := range scales {
+		RegisterGradingScale(scale)
+	}
+	return nil
+}
+
+func parseGradingScale(rule string) (models.GradingScale, error) {
+	var scale models.GradingScale
+	invalid := Error.InvalidParam("grading-scales", "name:type@passPercent>mark=minPercent/points|... rules", rule)
+	parts := strings.SplitN(rule, ">", 2)
+	if len(parts) != 2 {
+		return scale, invalid
+	}
+	head := strings.SplitN(parts[0], "@", 2)
+	nameType := strings.SplitN(head[0], ":", 2)
+	if len(head) != 2 || len(nameType) != 2 {
+		return scale, invalid
+	}
+	scale.Name, scale.Type = strings.TrimSpace(nameType[0]), strings.TrimSpace(nameType[1])
+	if scale.Name == "" {
+		return scale, invalid
+	}
+	if scale.Type != models.ScaleLetter && scale.Type != models.ScalePercentage && scale.Type != models.ScalePassFail {
+		return scale, Error.InvalidParam(scale.Name, "letter, percentage or passfail", scale.Type)
+	}
+	pass, err := strconv.ParseFloat(strings.TrimSpace(head[1]), 64)
+	if err != nil {
+		return scale, invalid
+	}
+
+	for _, b := range strings.Split(parts[1], "|") {
+		markMin := strings.SplitN(b, "=", 2)
+		if len(markMin) != 2 || strings.TrimSpace(markMin[0]) == "" {
+			return scale, invalid
+		}
+		band := models.GradeBand{Mark: strings.TrimSpace(markMin[0])}
+		minPoints := strings.SplitN(markMin[1], "/", 2)
+		band.MinPercent, err = strconv.ParseFloat(strings.TrimSpace(minPoints[0]), 64)
+		if err != nil || band.MinPercent < 0 || band.MinPercent > 100 {
+			return scale, Error.InvalidParam(scale.Name, "bands with a minimum percent between 0 and 100", b)
+		}
+		if len(minPoints) == 2 {
+			band.Points, err = strconv.ParseFloat(strings.TrimSpace(minPoints[1]), 64)
+			if err != nil {
+				return scale, invalid
+			}
+		}
+		band.Passing = band.MinPercent >= pass
+		scale.Bands = append(scale.Bands, band)
+	}
+	return scale, nil
+}
+
+// GetGradingScale finds a registered grading scale by name.
+func GetGradingScale(name string) (models.GradingScale, bool) {
+	gradingScalesLock.RLock()
+	defer gradingScalesLock.RUnlock()
+	scale, ok := gradingScales[name]
+	return scale, ok
+}
+
+func ParseGrade(ctx context.Context, req HttpWebRequest) (*models.GradeRequestPayload, error) {
+	var payload models.GradeRequestPayload
+
+	err := req.Decode(&payload)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// ResolveGrade turns the mark or percentage of a grade request into a grade on
+// the given scale.
+func ResolveGrade(scaleName string, payload *models.GradeRequestPayload) (*models.Grade, error) {
+	scale, ok := GetGradingScale(scaleName)
+	if !ok {
+		return nil, Error.InvalidParam("gradingScale", "a registered grading scale", scaleName)
+	}
+
+	grade := models.Grade{Scale: scale.Name}
+	var band *models.GradeBand
+
+	switch {
+	case payload.Percent != nil:
+		percent := *payload.Percent
+		if percent < 0 || percent > 100 {
+			return nil, Error.InvalidParam("percent", "a value between 0 and 100", fmt.Sprintf("%v", percent))
+		}
+		for i := range scale.Bands {
+			if percent >= scale.Bands[i].MinPercent {
+				band = &scale.Bands[i]
+				break
+			}
+		}
+		grade.Percent = &percent
+	case payload.Mark != "" && scale.Type != models.ScalePercentage:
+		for i := range scale.Bands {
+			if strings.EqualFold(scale.Bands[i].Mark, payload.Mark) {
+				band = &scale.Bands[i]
+				break
+			}
+		}
+	case scale.Type == models.ScalePercentage:
+		return nil, Error.MissingRequiredProperty("percent")
+	default:
+		return nil, Error.MissingRequiredProperty("mark")
+	}
+
+	if band == nil {
+		marks := make([]string, 0, len(scale.Bands))
+		for _, b := range scale.Bands {
+			marks = append(marks, b.Mark)
+		}
+		return nil, Error.InvalidParam("mark", strings.Join(marks, ", "), payload.Mark)
+	}
+
+	grade.Mark = band.Mark
+	grade.Passing = band.Passing
+	if scale.Type != models.ScalePassFail {
+		points := band.Points
+		grade.Points = &points
+	}
+	return &grade, nil
+}
+
+// RecordGrade sets the grade of an enrollment. Once the term of the course has
+// ended an existing grade can only be changed with a reason. Every change is
+// appended to the grade history of the enrollment.
+func RecordGrade(ctx context.Context, enrollment *models.Enrollment, payload *models.GradeRequestPayload, config *MapPropertySource) (*models.Enrollment, error) {
+	err := GetEnrollment(ctx, enrollment, config)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Status != models.EnrollmentEnrolled {
+		return nil, Error.InvalidParam("status", models.EnrollmentEnrolled, enrollment.Status)
+	}
+
+	var course models.Course
+	course.Id = enrollment.CourseId
+	err = GetCourse(ctx, &course, config)
+	if err != nil {
+		return nil, err
+	}
+
+	grade, err := ResolveGrade(course.GradingScale, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	termClosed := !course.TermEnd.IsZero() && now.After(course.TermEnd)
+	if termClosed && enrollment.Grade != nil && strings.TrimSpace(payload.Reason) == "" {
+		return nil, Error.MissingRequiredProperty("reason")
+	}
+
+	change := models.GradeChange{
+		Grade:     *grade,
+		Previous:  enrollment.Grade,
+		Reason:    payload.Reason,
+		ChangedBy: AuthenticatedUser(ctx),
+		ChangedAt: now,
+	}
+
+	filter := bson.D{{"id", enrollment.Id}, {"status", models.EnrollmentEnrolled}}
+	query := bson.D{
+		{"$set", bson.D{{"grade", grade}, {"meta.lastModified", now}}},
+		{"$push", bson.D{{"gradeHistory", change}}},
+	}
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("enrollments-collection"), filter, query)
+	if err != nil {
+		return nil, err
+	}
+	if matched == 0 {
+		return nil, Error.ResourceNotFound(enrollment.Id, "")
+	}
+
+	err = GetEnrollment(ctx, enrollment, config)
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+func GetTranscript(ctx context.Context, studentId string, config *MapPropertySource) (*models.Transcript, error) {
+	var student models.Student
+	student.Id = studentId
+	err := GetStudent(ctx, &student, config)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollments, err := GetStudentEnrollments(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+
+	courseIds := make([]string, 0, len(enrollments))
+	for _, e := range enrollments {
+		courseIds = append(courseIds, e.CourseId)
+	}
+	courses, err := GetCourses(ctx, courseIds, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return ComputeTranscript(studentId, enrollments, courses), nil
+}
+
+// ComputeTranscript groups the enrollments of a student by term and computes
+// the credit weighted term and cumulative GPA. Pass/fail grades earn credits
+// but do not count towards the GPA, waitlisted and dropped enrollments are
+// left out entirely.
+func ComputeTranscript(studentId string, enrollments []models.Enrollment, courses []models.Course) *models.Transcript {
+	byId := make(map[string]models.Course, len(courses))
+	for _, c := range courses {
+		byId[c.Id] = c
+	}
+
+	transcript := &models.Transcript{StudentId: studentId, Terms: make([]models.TranscriptTerm, 0)}
+	terms := map[string]int{}
+	var totalPoints, totalGpaCredits float64
+	termPoints := map[string]float64{}
+	termGpaCredits := map[string]float64{}
+
+	for _, e := range enrollments {
+		if e.Status != models.EnrollmentEnrolled && e.Status != models.EnrollmentWithdrawn {
+			continue
+		}
+		course, ok := byId[e.CourseId]
+		if !ok {
+			continue
+		}
+
+		idx, ok := terms[course.Term]
+		if !ok {
+			idx = len(transcript.Terms)
+			terms[course.Term] = idx
+			transcript.Terms = append(transcript.Terms, models.TranscriptTerm{Term: course.Term})
+		}
+		term := &transcript.Terms[idx]
+		term.Courses = append(term.Courses, models.TranscriptCourse{
+			CourseId: course.Id,
+			Name:     course.Name,
+			Credits:  course.Credits,
+			Status:   e.Status,
+			Grade:    e.Grade,
+		})
+
+		if e.Status != models.EnrollmentEnrolled || e.Grade == nil {
+			continue
+		}
+		if e.Grade.Passing {
+			term.Credits += course.Credits
+			transcript.Credits += course.Credits
+		}
+		if e.Grade.Points != nil {
+			termPoints[course.Term] += *e.Grade.Points * course.Credits
+			termGpaCredits[course.Term] += course.Credits
+			totalPoints += *e.Grade.Points * course.Credits
+			totalGpaCredits += course.Credits
+		}
+	}
+
+	for i := range transcript.Terms {
+		term := &transcript.Terms[i]
+		term.GPA = gpa(termPoints[term.Term], termGpaCredits[term.Term])
+	}
+	sort.SliceStable(transcript.Terms, func(i, j int) bool {
+		return transcript.Terms[i].Term < transcript.Terms[j].Term
+	})
+	transcript.CumulativeGPA = gpa(totalPoints, totalGpaCredits)
+	return transcript
+}
+
+func gpa(points, credits float64) *float64 {
+	if credits == 0 {
+		return nil
+	}
+	v := math.Round(points/credits*100) / 100
+	return &v
+}
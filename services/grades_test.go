@@ -0,0 +1,49 @@
+package service
+
+import (
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"errors"
+	"testing"
+)
+
+func TestGradingScalesAreLoadedFromTheConfiguration(t *testing.T) {
+	InitConfigs()
+	config := GetConfigs()
+	config.Data["grading-scales"] = "honors:letter@65>A=93/4|B=80/3|C=65/2|F=0; audit:passfail@70>P=70|F=0"
+
+	if err := LoadGradingScales(config); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	percent := 70.0
+	grade, err := ResolveGrade("honors", &models.GradeRequestPayload{Percent: &percent})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if grade.Mark != "C" || grade.Points == nil || *grade.Points != 2 || !grade.Passing {
+		t.Fatalf("grade = %+v, want a passing C worth 2 points", grade)
+	}
+	grade, err = ResolveGrade("audit", &models.GradeRequestPayload{Mark: "F"})
+	if err != nil || grade.Passing {
+		t.Fatalf("grade = %+v, %v, want a failing F", grade, err)
+	}
+}
+
+func TestInvalidGradingScaleIsRefused(t *testing.T) {
+	InitConfigs()
+	config := GetConfigs()
+
+	for _, scales := range []string{
+		"honors>A=90/4",
+		"honors:stars@50>A=90/4",
+		"honors:letter@50>A=190/4",
+		"honors:letter@50>A",
+	} {
+		config.Data["grading-scales"] = scales
+		err := LoadGradingScales(config)
+		var invalid *InvalidParamError
+		if !errors.As(err, &invalid) {
+			t.Errorf("loading %q: err = %v, want InvalidParamError", scales, err)
+		}
+	}
+}
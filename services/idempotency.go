@@ -0,0 +1,150 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// idempotencyPoll is how often a request waits to check on an earlier request
+// with the same key.
+const idempotencyPoll = 100 * time.Millisecond
+
+var idempotencyExpiry sync.Once
+
+func idempotencyId(key, caller string) string {
+	return caller + "/" + key
+}
+
+// ClaimIdempotencyKey records that the caller's request with the key is being
+// processed and returns nil, the response is to be stored with
+// CompleteIdempotencyKey or the key released with ReleaseIdempotencyKey. When
+// the key was used first, the stored record is returned to be replayed. A
+// request still processing the key is waited for up to
+// idempotency-wait-timeout seconds, so concurrent retries run one at a time.
+// A claim not completed within idempotency-lock-timeout seconds, because the
+// request processing it died, is taken over by the next retry.
+func ClaimIdempotencyKey(ctx context.Context, key, caller, fingerprint string, config *MapPropertySource) (*models.IdempotencyRecord, error) {
+	collection := config.GetString("idempotency-collection")
+	idempotencyExpiry.Do(func() {
+		if err := datastore.GetDatastore().EnsureExpiry(ctx, collection, "expiresAt"); err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the expiry index of %s: %s", collection, err.Error()))
+		}
+	})
+
+	now := time.Now().UTC()
+	lock := time.Duration(config.GetInt("idempotency-lock-timeout")) * time.Second
+	record := &models.IdempotencyRecord{
+		Id:          idempotencyId(key, caller),
+		Key:         key,
+		Caller:      caller,
+		Fingerprint: fingerprint,
+		Status:      models.IdempotencyPending,
+		LockedUntil: now.Add(lock),
+		ExpiresAt:   now.Add(time.Duration(config.GetInt("idempotency-ttl")) * time.Second),
+		Meta: models.Meta{
+			ResourceType: "IdempotencyKey",
+			Created:      now,
+			LastModified: now,
+		},
+	}
+	deadline := now.Add(time.Duration(config.GetInt("idempotency-wait-timeout")) * time.Second)
+
+	for {
+		err := datastore.GetDatastore().Save(ctx, collection, record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		existing := &models.IdempotencyRecord{}
+		err = datastore.GetDatastore().GetById(ctx, collection, bson.D{{"_id", record.Id}}, existing)
+		if datastore.IsNotFound(err) {
+			// released by a failed request, claim it again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// mongo removes expired records only once a minute
+		if existing.ExpiresAt.Before(time.Now()) {
+			filter := bson.D{{"_id", record.Id}, {"expiresAt", bson.D{{"$lt", time.Now().UTC()}}}}
+			if _, err := datastore.GetDatastore().Delete(ctx, collection, filter); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if existing.Fingerprint != fingerprint {
+			return nil, Error.IdempotencyKeyReused(key)
+		}
+		if existing.Status == models.IdempotencyCompleted {
+			return existing, nil
+		}
+		if existing.LockedUntil.Before(time.Now()) {
+			claimed, err := reclaimIdempotencyKey(ctx, record.Id, lock, config)
+			if err != nil {
+				return nil, err
+			}
+			if claimed {
+				return nil, nil
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, Error.IdempotencyKeyInProgress(key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, Error.RequestCancelled()
+		case <-time.After(idempotencyPoll):
+		}
+	}
+}
+
+// reclaimIdempotencyKey takes over a pending key whose lock ran out. It
+// returns false when another retry was quicker.
+func reclaimIdempotencyKey(ctx context.Context, id string, lock time.Duration, config *MapPropertySource) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.D{{"_id", id}, {"status", models.IdempotencyPending}, {"lockedUntil", bson.D{{"$lt", now}}}}
+	update := bson.D{{"$set", bson.D{
+		{"lockedUntil", now.Add(lock)},
+		{"expiresAt", now.Add(time.Duration(config.GetInt("idempotency-ttl")) * time.Second)},
+		{"meta.lastModified", now},
+	}}}
+	matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("idempotency-collection"), filter, update)
+	return matched > 0, err
+}
+
+// CompleteIdempotencyKey stores the response of the request that claimed the
+// key, unless a retry that took the key over completed it first.
+func CompleteIdempotencyKey(ctx context.Context, key, caller string, status int, headers map[string]string, body []byte, config *MapPropertySource) error {
+	update := bson.D{{"$set", bson.D{
+		{"status", models.IdempotencyCompleted},
+		{"responseStatus", status},
+		{"headers", headers},
+		{"body", body},
+		{"meta.lastModified", time.Now().UTC()},
+	}}, {"$unset", bson.D{{"lockedUntil", ""}}}}
+	filter := bson.D{{"_id", idempotencyId(key, caller)}, {"status", models.IdempotencyPending}}
+	_, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("idempotency-collection"), filter, update)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a claimed key whose request failed, so a retry
+// runs again.
+func ReleaseIdempotencyKey(ctx context.Context, key, caller string, config *MapPropertySource) error {
+	filter := bson.D{{"_id", idempotencyId(key, caller)}, {"status", models.IdempotencyPending}}
+	_, err := datastore.GetDatastore().Delete(ctx, config.GetString("idempotency-collection"), filter)
+	return err
+}
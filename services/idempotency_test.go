@@ -0,0 +1,128 @@
+package service
+
+import (
+	. "awesomeTestProject/shared"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idempotencyTest(t *testing.T) *MapPropertySource {
+	InitConfigs()
+	config := GetConfigs()
+	useMemoryStore(t)
+	idempotencyExpiry = sync.Once{}
+	return config
+}
+
+// idempotentCall handles a request with the key the way the Idempotent
+// middleware does, counting in runs how often the request really ran, and
+// returns the status it answered with.
+func idempotentCall(key, fingerprint string, runs *int32, config *MapPropertySource) (int, bool, error) {
+	replay, err := ClaimIdempotencyKey(context.Background(), key, "caller", fingerprint, config)
+	if err != nil {
+		return 0, false, err
+	}
+	if replay != nil {
+		return replay.ResponseStatus, true, nil
+	}
+	atomic.AddInt32(runs, 1)
+	time.Sleep(50 * time.Millisecond)
+	err = CompleteIdempotencyKey(context.Background(), key, "caller", http.StatusCreated, nil, []byte(`{"id":"s1"}`), config)
+	return http.StatusCreated, false, err
+}
+
+func TestConcurrentRetriesOfAKeyRunOnce(t *testing.T) {
+	config := idempotencyTest(t)
+
+	var runs, replayed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, replay, err := idempotentCall("k1", "f1", &runs, config)
+			if err != nil || status != http.StatusCreated {
+				t.Errorf("retry answered %d, %v, want 201", status, err)
+			}
+			if replay {
+				atomic.AddInt32(&replayed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if runs != 1 || replayed != 5 {
+		t.Fatalf("request ran %d times and was replayed %d times, want once and 5 replays", runs, replayed)
+	}
+}
+
+func TestIdempotencyKeyReusedForAnotherRequestIsRefused(t *testing.T) {
+	config := idempotencyTest(t)
+	var runs int32
+	if _, _, err := idempotentCall("k1", "f1", &runs, config); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+
+	_, _, err := idempotentCall("k1", "f2", &runs, config)
+	var reused *IdempotencyKeyReusedError
+	if !errors.As(err, &reused) || runs != 1 {
+		t.Fatalf("err = %v after %d runs, want IdempotencyKeyReusedError without running", err, runs)
+	}
+	// keys are scoped to the caller
+	if replay, err := ClaimIdempotencyKey(context.Background(), "k1", "other", "f2", config); replay != nil || err != nil {
+		t.Fatalf("claim of another caller = %+v, %v, want it claimed", replay, err)
+	}
+}
+
+func TestReleasedIdempotencyKeyRunsAgain(t *testing.T) {
+	config := idempotencyTest(t)
+	if _, err := ClaimIdempotencyKey(context.Background(), "k1", "caller", "f1", config); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := ReleaseIdempotencyKey(context.Background(), "k1", "caller", config); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+
+	var runs int32
+	if status, replay, err := idempotentCall("k1", "f1", &runs, config); err != nil || replay || runs != 1 {
+		t.Fatalf("retry answered %d, replayed %v, %v, want it run again", status, replay, err)
+	}
+}
+
+func TestRetryWaitsForTheRequestHoldingTheKey(t *testing.T) {
+	config := idempotencyTest(t)
+	config.Data["idempotency-wait-timeout"] = 0
+	if _, err := ClaimIdempotencyKey(context.Background(), "k1", "caller", "f1", config); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	_, err := ClaimIdempotencyKey(context.Background(), "k1", "caller", "f1", config)
+	var inProgress *IdempotencyKeyInProgressError
+	if !errors.As(err, &inProgress) {
+		t.Fatalf("err = %v, want IdempotencyKeyInProgressError once the wait ran out", err)
+	}
+}
+
+func TestAbandonedIdempotencyKeyIsTakenOver(t *testing.T) {
+	config := idempotencyTest(t)
+	// the request holding the key died, its lock runs out at once
+	config.Data["idempotency-lock-timeout"] = 0
+	if _, err := ClaimIdempotencyKey(context.Background(), "k1", "caller", "f1", config); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	var runs int32
+	if status, replay, err := idempotentCall("k1", "f1", &runs, config); err != nil || replay || runs != 1 {
+		t.Fatalf("retry answered %d, replayed %v, %v, want it to take the key over", status, replay, err)
+	}
+	config.Data["idempotency-lock-timeout"] = 60
+	if status, replay, err := idempotentCall("k1", "f1", &runs, config); err != nil || !replay || status != http.StatusCreated {
+		t.Fatalf("next retry answered %d, replayed %v, %v, want the response replayed", status, replay, err)
+	}
+}
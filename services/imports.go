@@ -0,0 +1,457 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/jobs"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// studentImportAttributes are the student attributes a CSV column can be
+// mapped to, with the setter that validates and applies the cell value.
+var studentImportAttributes = map[string]func(student *models.Student, value string) error{
+	"name": func(student *models.Student, value string) error {
+		student.Name = value
+		return nil
+	},
+	"enrollmentNumber": func(student *models.Student, value string) error {
+		student.EnrollmentNumber = value
+		return nil
+	},
+}
+
+type ImportOptions struct {
+	DryRun    bool
+	Delimiter rune
+	// Mapping maps a CSV header to a student attribute.
+	Mapping map[string]string
+}
+
+type importRow struct {
+	line    int
+	student *models.Student
+}
+
+// ParseImportRequest reads the uploaded CSV and the import options. The
+// column mapping comes from the mapping parameter, written as
+// "attribute=Column Header,...", and defaults to student-import-mapping.
+func ParseImportRequest(ctx context.Context, req HttpWebRequest, config *MapPropertySource) (*ImportOptions, []byte, error) {
+	opts := &ImportOptions{
+		DryRun:    req.Param("dryRun") == "true",
+		Delimiter: ',',
+	}
+
+	switch d := req.Param("delimiter"); d {
+	case "", ",", "comma":
+	case ";", "semicolon":
+		opts.Delimiter = ';'
+	case "\t", "tab":
+		opts.Delimiter = '\t'
+	default:
+		return nil, nil, Error.InvalidParam("delimiter", "comma, semicolon or tab", d)
+	}
+
+	mapping := req.Param("mapping")
+	if mapping == "" {
+		mapping = config.GetString("student-import-mapping")
+	}
+	opts.Mapping = map[string]string{}
+	for _, pair := range strings.Split(mapping, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, Error.InvalidParam("mapping", "attribute=Column pairs", pair)
+		}
+		attribute := strings.TrimSpace(parts[0])
+		if _, ok := studentImportAttributes[attribute]; !ok {
+			return nil, nil, Error.NoAttribute(attribute)
+		}
+		opts.Mapping[strings.TrimSpace(parts[1])] = attribute
+	}
+
+	maxSize := config.GetInt("student-import-max-size")
+	b, err := ioutil.ReadAll(io.LimitReader(req.Raw().Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) > maxSize {
+		return nil, nil, Error.PayloadTooLarge("import size", maxSize)
+	}
+	return opts, b, nil
+}
+
+// readImportRows turns the CSV into students, collecting an error for every
+// row that cannot be read or does not satisfy the student schema. Files saved
+// by Excel start with a byte order mark, which is dropped.
+func readImportRows(body []byte, opts *ImportOptions) ([]importRow, []models.ImportRowError, error) {
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(body) {
+		return nil, nil, Error.InvalidType("body", "UTF-8 text/csv", "binary content")
+	}
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.Comma = opts.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, Error.MissingRequiredProperty("header")
+	}
+	if err != nil {
+		return nil, nil, Error.InvalidType("body", "text/csv", err.Error())
+	}
+
+	columns := make([]string, len(header))
+	found := map[string]bool{}
+	for i, h := range header {
+		if attribute, ok := opts.Mapping[strings.TrimSpace(h)]; ok {
+			columns[i] = attribute
+			found[attribute] = true
+		}
+	}
+	if !found["name"] {
+		return nil, nil, Error.MissingRequiredProperty("name")
+	}
+
+	rows := make([]importRow, 0)
+	rowErrors := make([]models.ImportRowError, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+			}
+			rowErrors = append(rowErrors, models.ImportRowError{Row: line, Detail: err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		var student models.Student
+		var rowErr *models.ImportRowError
+		for i, value := range record {
+			if i >= len(columns) || columns[i] == "" {
+				continue
+			}
+			if err := studentImportAttributes[columns[i]](&student, strings.TrimSpace(value)); err != nil {
+				rowErr = &models.ImportRowError{Row: line, Column: header[i], Detail: err.Error()}
+				break
+			}
+		}
+		if rowErr == nil && student.Name == "" {
+			rowErr = &models.ImportRowError{Row: line, Column: "name", Detail: Error.MissingRequiredProperty("name").Error()}
+		}
+		if rowErr != nil {
+			rowErrors = append(rowErrors, *rowErr)
+			continue
+		}
+		rows = append(rows, importRow{line: line, student: &student})
+	}
+	return rows, rowErrors, nil
+}
+
+const studentImportJob = "student-import"
+
+type studentImportPayload struct {
+	ImportJobId string `json:"importJobId"`
+}
+
+func init() {
+	jobs.Register(studentImportJob, runImport)
+}
+
+// StartImport validates the CSV and imports it. Files with more rows than
+// student-import-async-rows are imported by a job on the job queue whose
+// progress can be polled, smaller files are imported before returning. Both
+// are kept in import-jobs-collection, their rejected rows in
+// import-errors-collection and the upload of a background import in
+// import-uploads-collection until it is done, so neither can grow the job
+// document. The returned flag tells whether the import was left running in
+// the background.
+func StartImport(ctx context.Context, opts *ImportOptions, body []byte, config *MapPropertySource) (*models.ImportJob, bool, error) {
+	rows, rowErrors, err := readImportRows(body, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	job := &models.ImportJob{
+		Id:        uuid.NewV4().String(),
+		Status:    models.JobRunning,
+		DryRun:    opts.DryRun,
+		TotalRows: len(rows) + len(rowErrors),
+		Failed:    len(rowErrors),
+		Errors:    rowErrors,
+		Meta:      models.Meta{ResourceType: "ImportJob", Created: now, LastModified: now},
+	}
+	job.ProcessedRows = job.Failed
+	collection := config.GetString("import-jobs-collection")
+
+	if len(rows) <= config.GetInt("student-import-async-rows") {
+		err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+			err := datastore.GetDatastore().Save(ctx, collection, job)
+			if err != nil {
+				return err
+			}
+			return saveImportErrors(ctx, job.Id, rowErrors, config)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		err = importStudents(ctx, job, rows, true, config)
+		if err != nil {
+			failImport(ctx, job, err, config)
+			return nil, false, err
+		}
+		return job, false, nil
+	}
+
+	job.Status = models.JobPending
+	upload := &models.ImportUpload{ImportJobId: job.Id, Body: body, Delimiter: opts.Delimiter, Mapping: opts.Mapping}
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		err := datastore.GetDatastore().Save(ctx, collection, job)
+		if err != nil {
+			return err
+		}
+		err = saveImportErrors(ctx, job.Id, rowErrors, config)
+		if err != nil {
+			return err
+		}
+		err = datastore.GetDatastore().Save(ctx, config.GetString("import-uploads-collection"), upload)
+		if err != nil {
+			return err
+		}
+		_, err = jobs.Enqueue(ctx, studentImportJob, studentImportPayload{job.Id}, config)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// runImport imports the upload of a background import. An attempt resumes
+// after the rows an earlier one got through, and the import fails once the
+// last attempt failed too.
+func runImport(ctx context.Context, queued *models.Job, progress jobs.Progress) error {
+	config := GetConfigs()
+	var p studentImportPayload
+	if err := json.Unmarshal(queued.Payload, &p); err != nil {
+		return err
+	}
+	job, err := GetImportJob(ctx, p.ImportJobId, config)
+	if err != nil {
+		return err
+	}
+	var upload models.ImportUpload
+	err = datastore.GetDatastore().GetById(ctx, config.GetString("import-uploads-collection"), bson.D{{"importJobId", job.Id}}, &upload)
+	if datastore.IsNotFound(err) {
+		Info(ctx, fmt.Sprintf("Import job(%s) is already %s", job.Id, job.Status))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := &ImportOptions{DryRun: job.DryRun, Delimiter: upload.Delimiter, Mapping: upload.Mapping}
+	rows, rowErrors, err := readImportRows(upload.Body, opts)
+	if err != nil {
+		failImport(ctx, job, err, config)
+		return nil
+	}
+	done := job.ProcessedRows - len(rowErrors)
+	if done < 0 {
+		done = 0
+	}
+	if done > len(rows) {
+		done = len(rows)
+	}
+
+	Info(ctx, fmt.Sprintf("Import job(%s) started for %d rows, %d already processed", job.Id, len(rows), done))
+	err = importStudents(ctx, job, rows[done:], true, config)
+	if err != nil {
+		if queued.Attempts >= queued.MaxAttempts {
+			failImport(ctx, job, err, config)
+		}
+		return err
+	}
+	Info(ctx, fmt.Sprintf("Import job(%s) completed, %d imported, %d failed", job.Id, job.Imported, job.Failed))
+	return nil
+}
+
+// failImport records that an import stopped with err.
+func failImport(ctx context.Context, job *models.ImportJob, err error, config *MapPropertySource) {
+	job.Status = models.JobFailed
+	job.Detail = err.Error()
+	if err := saveImportProgress(ctx, job, nil, config); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to record the failure of Import job(%s): %s", job.Id, err.Error()))
+	}
+}
+
+// importStudents rejects rows whose enrollment number is already taken and
+// inserts the rest in batches, each in one transaction with its events. In dry
+// run mode nothing is written. With track set the job progress is stored
+// after every batch, the errors of the job are taken as stored already.
+func importStudents(ctx context.Context, job *models.ImportJob, rows []importRow, track bool, config *MapPropertySource) error {
+	job.Status = models.JobRunning
+	batchSize := config.GetInt("student-import-batch-size")
+	seen := map[string]bool{}
+	saved := len(job.Errors)
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[start:end]
+
+		students := make([]*models.Student, 0, len(batch))
+		for _, row := range batch {
+			students = append(students, row.student)
+		}
+		duplicates, err := DuplicateEnrollmentNumbers(ctx, students, config)
+		if err != nil {
+			return err
+		}
+
+		accepted := make([]importRow, 0, len(batch))
+		created := make([]*models.Student, 0, len(batch))
+		for i, row := range batch {
+			number := row.student.EnrollmentNumber
+			if _, ok := duplicates[i]; !ok && number != "" && seen[number] {
+				duplicates[i] = Error.Duplicate("enrollmentNumber", number)
+			}
+			if err, ok := duplicates[i]; ok {
+				job.Errors = append(job.Errors, models.ImportRowError{Row: row.line, Column: "enrollmentNumber", Detail: err.Error()})
+				job.Failed++
+				continue
+			}
+			if number != "" {
+				seen[number] = true
+			}
+			NewStudent(row.student, config)
+			accepted = append(accepted, row)
+			created = append(created, row.student)
+		}
+
+		if !job.DryRun && len(created) > 0 {
+			failed, err := saveStudentBatch(ctx, created, config)
+			if err != nil {
+				return err
+			}
+			for i, row := range accepted {
+				if err, ok := failed[i]; ok {
+					job.Errors = append(job.Errors, models.ImportRowError{Row: row.line, Detail: err.Error()})
+					job.Failed++
+					continue
+				}
+				job.Imported++
+			}
+		} else if job.DryRun {
+			job.Imported += len(accepted)
+		}
+
+		job.ProcessedRows += len(batch)
+		if track {
+			err = saveImportProgress(ctx, job, job.Errors[saved:], config)
+			if err != nil {
+				return err
+			}
+			saved = len(job.Errors)
+		}
+	}
+
+	job.Status = models.JobCompleted
+	if track {
+		return saveImportProgress(ctx, job, nil, config)
+	}
+	return nil
+}
+
+// saveImportProgress stores how far the import got with the rows it rejected
+// since the last time. The upload is dropped once the import is done.
+func saveImportProgress(ctx context.Context, job *models.ImportJob, rowErrors []models.ImportRowError, config *MapPropertySource) error {
+	job.Meta.LastModified = time.Now()
+	query := bson.D{{"$set", bson.D{
+		{"status", job.Status},
+		{"processedRows", job.ProcessedRows},
+		{"imported", job.Imported},
+		{"failed", job.Failed},
+		{"detail", job.Detail},
+		{"meta.lastModified", job.Meta.LastModified},
+	}}}
+	return datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		err := saveImportErrors(ctx, job.Id, rowErrors, config)
+		if err != nil {
+			return err
+		}
+		err = datastore.GetDatastore().Update(ctx, config.GetString("import-jobs-collection"), bson.D{{"id", job.Id}}, query)
+		if err != nil || (job.Status != models.JobCompleted && job.Status != models.JobFailed) {
+			return err
+		}
+		_, err = datastore.GetDatastore().Delete(ctx, config.GetString("import-uploads-collection"), bson.D{{"importJobId", job.Id}})
+		return err
+	})
+}
+
+var importErrorIndex sync.Once
+
+// saveImportErrors adds rejected rows to the errors of the import.
+func saveImportErrors(ctx context.Context, importJobId string, rowErrors []models.ImportRowError, config *MapPropertySource) error {
+	if len(rowErrors) == 0 {
+		return nil
+	}
+	collection := config.GetString("import-errors-collection")
+	importErrorIndex.Do(func() {
+		err := datastore.GetDatastore().EnsureIndex(context.Background(), collection, bson.D{{"importJobId", 1}, {"row", 1}})
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the import index of %s: %s", collection, err.Error()))
+		}
+	})
+	dtos := make([]interface{}, 0, len(rowErrors))
+	for _, rowErr := range rowErrors {
+		rowErr.ImportJobId = importJobId
+		dtos = append(dtos, rowErr)
+	}
+	return datastore.GetDatastore().SaveMany(ctx, collection, dtos)
+}
+
+func GetImportJob(ctx context.Context, id string, config *MapPropertySource) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("import-jobs-collection"), bson.D{{"id", id}}, &job)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Errors = make([]models.ImportRowError, 0)
+	opt := options.Find().SetSort(bson.D{{"row", 1}})
+	err = datastore.GetDatastore().GetAll(ctx, config.GetString("import-errors-collection"), bson.D{{"importJobId", id}}, opt, &job.Errors)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,423 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	"awesomeTestProject/payments"
+	. "awesomeTestProject/shared"
+	"context"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+// ledgerPostTimeout bounds posting an entry the gateway already approved.
+const ledgerPostTimeout = 5 * time.Second
+
+var ledgerIndex sync.Once
+
+// ensureLedgerIndex makes mongo refuse what the checks of the ledger alone
+// can not for concurrent requests: a second reversal of an entry, a second
+// outcome of a pending entry and two refunds of a payment with one number.
+func ensureLedgerIndex(ctx context.Context, config *MapPropertySource) {
+	ledgerIndex.Do(func() {
+		collection := config.GetString("ledger-collection")
+		for _, keys := range []bson.D{{{"reversesId", 1}}, {{"attemptId", 1}}, {{"paymentId", 1}, {"sequence", 1}}} {
+			field := keys[len(keys)-1].Key
+			partial := bson.D{{field, bson.D{{"$exists", true}}}}
+			err := datastore.GetDatastore().EnsureUnique(ctx, collection, keys, partial)
+			if err != nil {
+				Warn(ctx, fmt.Sprintf("Unable to create the %s index of %s: %s", field, collection, err.Error()))
+			}
+		}
+	})
+}
+
+func ParseLedgerRequest(ctx context.Context, req HttpWebRequest) (*models.LedgerRequestPayload, error) {
+	var payload models.LedgerRequestPayload
+
+	err := req.Decode(&payload)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+	return &payload, nil
+}
+
+func ledgerStudentExists(ctx context.Context, studentId string, config *MapPropertySource) error {
+	var student models.Student
+	student.Id = studentId
+	return GetStudent(ctx, &student, config)
+}
+
+func validateLedgerAmount(payload *models.LedgerRequestPayload, config *MapPropertySource) error {
+	if payload.Amount <= 0 {
+		return Error.PaymentInvalid(fmt.Sprintf("amount must be positive, got %d", payload.Amount))
+	}
+	currency := config.GetString("ledger-currency")
+	if payload.Currency == "" {
+		payload.Currency = currency
+	}
+	if payload.Currency != currency {
+		return Error.PaymentInvalid(fmt.Sprintf("currency must be %s, got %s", currency, payload.Currency))
+	}
+	return nil
+}
+
+func paymentGateway(config *MapPropertySource) (payments.Gateway, error) {
+	name := config.GetString("payment-gateway")
+	if name == "" {
+		return nil, Error.Text("No payment gateway is configured")
+	}
+	gateway, ok := payments.GetGateway(name)
+	if !ok {
+		return nil, Error.Text("Payment gateway %s is not registered", name)
+	}
+	return gateway, nil
+}
+
+func saveLedgerEntry(ctx context.Context, entry *models.LedgerEntry, config *MapPropertySource) (*models.LedgerEntry, error) {
+	entry.Id = uuid.NewV4().String()
+	if entry.Status == "" {
+		entry.Status = models.LedgerPosted
+	}
+	entry.Meta.ResourceType = "LedgerEntry"
+	entry.Meta.Created = time.Now()
+	entry.Meta.LastModified = entry.Meta.Created
+
+	err := datastore.GetDatastore().Save(ctx, config.GetString("ledger-collection"), entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// settleLedgerEntry records the answer of the gateway to a pending entry as
+// a new entry: posted with the reference of the gateway transaction, or
+// declined without effect on the balance. The pending entry is left as it is.
+// An approval is recorded even when the request was cancelled or ran out of
+// time meanwhile, the money moved.
+func settleLedgerEntry(attempt *models.LedgerEntry, result *payments.Result, config *MapPropertySource) (*models.LedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerPostTimeout)
+	defer cancel()
+
+	outcome := &models.LedgerEntry{
+		StudentId:   attempt.StudentId,
+		Type:        attempt.Type,
+		Status:      models.LedgerPosted,
+		Amount:      attempt.Amount,
+		Delta:       attempt.Delta,
+		Currency:    attempt.Currency,
+		Description: attempt.Description,
+		Reference:   result.Reference,
+		PaymentId:   attempt.PaymentId,
+		AttemptId:   attempt.Id,
+	}
+	if !result.Approved {
+		outcome.Status = models.LedgerDeclined
+		outcome.Delta = 0
+		outcome.Description = result.Reason
+	}
+	outcome, err := saveLedgerEntry(ctx, outcome, config)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, Error.Duplicate("attemptId", attempt.Id)
+	}
+	return outcome, err
+}
+
+func AddInvoice(ctx context.Context, studentId string, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error) {
+	err := ledgerStudentExists(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+	err = validateLedgerAmount(payload, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveLedgerEntry(ctx, &models.LedgerEntry{
+		StudentId:   studentId,
+		Type:        models.LedgerInvoice,
+		Amount:      payload.Amount,
+		Delta:       payload.Amount,
+		Currency:    payload.Currency,
+		Description: payload.Description,
+	}, config)
+}
+
+// CapturePayment charges the payment through the configured gateway. The
+// payment is recorded as a pending entry before the gateway is asked, so a
+// capture is never taken without a trace on the ledger, and the answer of the
+// gateway as the posted or declined entry settling it. When the gateway fails
+// without an answer the pending entry stays unsettled, to be reconciled with
+// the gateway.
+func CapturePayment(ctx context.Context, studentId string, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error) {
+	err := ledgerStudentExists(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+	err = validateLedgerAmount(payload, config)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Token == "" {
+		return nil, Error.PaymentInvalid("payment token is missing")
+	}
+
+	gateway, err := paymentGateway(config)
+	if err != nil {
+		return nil, err
+	}
+	ensureLedgerIndex(ctx, config)
+	entry, err := saveLedgerEntry(ctx, &models.LedgerEntry{
+		StudentId:   studentId,
+		Type:        models.LedgerPayment,
+		Status:      models.LedgerPending,
+		Amount:      payload.Amount,
+		Delta:       -payload.Amount,
+		Currency:    payload.Currency,
+		Description: payload.Description,
+	}, config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := gateway.Capture(ctx, payments.CaptureRequest{
+		EntryId:     entry.Id,
+		StudentId:   studentId,
+		Amount:      payload.Amount,
+		Currency:    payload.Currency,
+		Token:       payload.Token,
+		Description: payload.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settleAnswer(ctx, entry, result, config)
+}
+
+// settleAnswer settles the pending entry with the answer of the gateway and
+// returns the posted entry, or the reason the gateway declined.
+func settleAnswer(ctx context.Context, entry *models.LedgerEntry, result *payments.Result, config *MapPropertySource) (*models.LedgerEntry, error) {
+	outcome, err := settleLedgerEntry(entry, result, config)
+	if !result.Approved {
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to record that LedgerEntry(%s) was declined: %s", entry.Id, err.Error()))
+		}
+		return nil, Error.PaymentInvalid(result.Reason)
+	}
+	return outcome, err
+}
+
+// RefundPayment gives back part or all of an earlier payment through the
+// gateway it was captured with. The refund is recorded pending before the
+// gateway is asked, and counts against the payment until the gateway declines
+// it, so concurrent refunds can never give back more than was paid. Reversing
+// a refund makes its amount refundable again.
+func RefundPayment(ctx context.Context, studentId string, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error) {
+	err := ledgerStudentExists(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+	err = validateLedgerAmount(payload, config)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := getLedgerEntry(ctx, studentId, payload.PaymentId, config)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Type != models.LedgerPayment {
+		return nil, Error.PaymentInvalid(fmt.Sprintf("entry %s is not a payment", payment.Id))
+	}
+	if payment.Status != models.LedgerPosted {
+		return nil, Error.PaymentInvalid(fmt.Sprintf("entry %s is a %s payment, only posted payments are refunded", payment.Id, payment.Status))
+	}
+
+	gateway, err := paymentGateway(config)
+	if err != nil {
+		return nil, err
+	}
+	ensureLedgerIndex(ctx, config)
+	entry, err := reserveRefund(ctx, payment, payload, config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := gateway.Refund(ctx, payments.RefundRequest{
+		Reference: payment.Reference,
+		Amount:    payload.Amount,
+		Currency:  payload.Currency,
+	})
+	if err != nil {
+		// the refund may have gone through, the pending entry keeps the amount
+		return nil, err
+	}
+	return settleAnswer(ctx, entry, result, config)
+}
+
+// reserveRefund records a pending refund of the payment, as long as it stays
+// within what is left of the payment. The refunds of a payment are numbered:
+// two refunds working out what is left at the same time take the same number,
+// the index refuses the second and it works it out again.
+func reserveRefund(ctx context.Context, payment *models.LedgerEntry, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error) {
+	for {
+		entries, err := getLedgerEntries(ctx, bson.D{{"paymentId", payment.Id}}, config)
+		if err != nil {
+			return nil, err
+		}
+		refunded, refunds := refundedOf(entries)
+		if left := payment.Amount - refunded; payload.Amount > left {
+			return nil, Error.PaymentInvalid(fmt.Sprintf("only %d of payment %s is left to refund", left, payment.Id))
+		}
+
+		entry, err := saveLedgerEntry(ctx, &models.LedgerEntry{
+			StudentId:   payment.StudentId,
+			Type:        models.LedgerRefund,
+			Status:      models.LedgerPending,
+			Amount:      payload.Amount,
+			Delta:       payload.Amount,
+			Currency:    payload.Currency,
+			Description: payload.Description,
+			PaymentId:   payment.Id,
+			Sequence:    refunds + 1,
+		}, config)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		return entry, err
+	}
+}
+
+// refundedOf adds up how much the entries of a payment refund: the refunds
+// asked for that the gateway did not decline, less the refunds reversed.
+// refunds is the number of refunds asked for.
+func refundedOf(entries []models.LedgerEntry) (refunded int64, refunds int64) {
+	declined := map[string]bool{}
+	for _, e := range entries {
+		if e.Status == models.LedgerDeclined {
+			declined[e.AttemptId] = true
+		}
+	}
+	for _, e := range entries {
+		switch {
+		case e.Type == models.LedgerRefund && e.Status == models.LedgerPending:
+			refunds++
+			if !declined[e.Id] {
+				refunded += e.Amount
+			}
+		case e.Type == models.LedgerReversal:
+			refunded -= e.Amount
+		}
+	}
+	return refunded, refunds
+}
+
+// ReverseLedgerEntry corrects a ledger entry by adding an entry with the
+// opposite effect on the balance, the original entry is never changed.
+// Reversing a payment or refund does not move any money through the gateway.
+func ReverseLedgerEntry(ctx context.Context, studentId, entryId string, payload *models.LedgerRequestPayload, config *MapPropertySource) (*models.LedgerEntry, error) {
+	entry, err := getLedgerEntry(ctx, studentId, entryId, config)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type == models.LedgerReversal {
+		return nil, Error.InvalidParam("entryId", "an entry that is not a reversal", entry.Id)
+	}
+	if entry.Status != models.LedgerPosted {
+		return nil, Error.InvalidParam("entryId", "a posted entry", entry.Id)
+	}
+
+	ensureLedgerIndex(ctx, config)
+	var existing models.LedgerEntry
+	err = datastore.GetDatastore().GetById(ctx, config.GetString("ledger-collection"), bson.D{{"reversesId", entry.Id}}, &existing)
+	if err == nil {
+		return nil, Error.Duplicate("reversesId", entry.Id)
+	}
+	if !datastore.IsNotFound(err) {
+		return nil, err
+	}
+
+	reversal, err := saveLedgerEntry(ctx, &models.LedgerEntry{
+		StudentId:   studentId,
+		Type:        models.LedgerReversal,
+		Amount:      entry.Amount,
+		Delta:       -entry.Delta,
+		Currency:    entry.Currency,
+		Description: payload.Description,
+		PaymentId:   entry.PaymentId,
+		ReversesId:  entry.Id,
+	}, config)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, Error.Duplicate("reversesId", entry.Id)
+	}
+	return reversal, err
+}
+
+func GetLedger(ctx context.Context, studentId string, config *MapPropertySource) (*models.Ledger, error) {
+	err := ledgerStudentExists(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getLedgerEntries(ctx, bson.D{{"studentId", studentId}}, config)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := GetLedgerBalance(ctx, studentId, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Ledger{
+		StudentId: studentId,
+		Currency:  config.GetString("ledger-currency"),
+		Balance:   balance,
+		Entries:   entries,
+	}, nil
+}
+
+// GetLedgerBalance is the amount the student owes, negative when they are in
+// credit.
+func GetLedgerBalance(ctx context.Context, studentId string, config *MapPropertySource) (int64, error) {
+	pipeline := bson.A{
+		bson.D{{"$match", bson.D{{"studentId", studentId}, {"status", models.LedgerPosted}}}},
+		bson.D{{"$group", bson.D{{"_id", "$studentId"}, {"balance", bson.D{{"$sum", "$delta"}}}}}},
+	}
+	var rows []struct {
+		Balance int64 `bson:"balance"`
+	}
+	err := datastore.GetDatastore().Aggregate(ctx, config.GetString("ledger-collection"), pipeline, &rows)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].Balance, nil
+}
+
+func getLedgerEntry(ctx context.Context, studentId, entryId string, config *MapPropertySource) (*models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	filter := bson.D{{"id", entryId}, {"studentId", studentId}}
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("ledger-collection"), filter, &entry)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(entryId, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func getLedgerEntries(ctx context.Context, filter interface{}, config *MapPropertySource) ([]models.LedgerEntry, error) {
+	entries := make([]models.LedgerEntry, 0)
+	opt := options.Find().SetSort(bson.D{{"meta.created", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("ledger-collection"), filter, opt, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	"awesomeTestProject/payments"
+	. "awesomeTestProject/shared"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func ledgerTest(t *testing.T) (*memoryStore, *MapPropertySource) {
+	InitConfigs()
+	config := GetConfigs()
+	payments.Register("fake", payments.NewFakeGateway())
+	config.Data["payment-gateway"] = "fake"
+	store := useMemoryStore(t)
+	ledgerIndex = sync.Once{}
+	if err := store.Save(context.Background(), config.GetString("students-collection"), &models.Student{Id: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	return store, config
+}
+
+func ledgerEntries(store *memoryStore, config *MapPropertySource) []models.LedgerEntry {
+	var entries []models.LedgerEntry
+	store.All(config.GetString("ledger-collection"), &entries)
+	return entries
+}
+
+// postedBalance is the balance GetLedgerBalance aggregates, which the
+// memoryStore can not run.
+func postedBalance(store *memoryStore, config *MapPropertySource) int64 {
+	var balance int64
+	for _, e := range ledgerEntries(store, config) {
+		if e.Status == models.LedgerPosted {
+			balance += e.Delta
+		}
+	}
+	return balance
+}
+
+// refunded is how much of the payment the ledger says is refunded.
+func refunded(store *memoryStore, config *MapPropertySource, paymentId string) int64 {
+	var entries []models.LedgerEntry
+	for _, e := range ledgerEntries(store, config) {
+		if e.PaymentId == paymentId {
+			entries = append(entries, e)
+		}
+	}
+	amount, _ := refundedOf(entries)
+	return amount
+}
+
+func capture(t *testing.T, config *MapPropertySource, amount int64) *models.LedgerEntry {
+	payment, err := CapturePayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: amount, Token: "tok_visa"}, config)
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	return payment
+}
+
+func TestCapturePaymentPostsApprovedPayment(t *testing.T) {
+	store, config := ledgerTest(t)
+
+	payment := capture(t, config, 2500)
+	if payment.Status != models.LedgerPosted || !strings.HasPrefix(payment.Reference, "fake_") {
+		t.Fatalf("payment = %+v, want posted with the gateway reference", payment)
+	}
+	entries := ledgerEntries(store, config)
+	if len(entries) != 2 || entries[0].Status != models.LedgerPending || entries[1].AttemptId != entries[0].Id || entries[1].Delta != -2500 {
+		t.Fatalf("ledger = %+v, want the pending payment and the posted one settling it", entries)
+	}
+	if balance := postedBalance(store, config); balance != -2500 {
+		t.Fatalf("balance = %d, want -2500", balance)
+	}
+}
+
+func TestCapturePaymentWithoutGatewayFails(t *testing.T) {
+	store, config := ledgerTest(t)
+	config.Data["payment-gateway"] = ""
+
+	_, err := CapturePayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 2500, Token: "tok_visa"}, config)
+	if err == nil || !strings.Contains(err.Error(), "No payment gateway") {
+		t.Fatalf("err = %v, want the capture refused without a gateway", err)
+	}
+	if entries := ledgerEntries(store, config); len(entries) != 0 {
+		t.Fatalf("ledger = %+v, want no entry", entries)
+	}
+}
+
+func TestCapturePaymentDeclinedIsRecorded(t *testing.T) {
+	store, config := ledgerTest(t)
+
+	_, err := CapturePayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 2500, Token: payments.FakeTokenDeclined}, config)
+	var invalid *PaymentInvalidError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want PaymentInvalidError", err)
+	}
+	entries := ledgerEntries(store, config)
+	if len(entries) != 2 || entries[0].Status != models.LedgerPending || entries[1].Status != models.LedgerDeclined || entries[1].AttemptId != entries[0].Id {
+		t.Fatalf("ledger = %+v, want the pending payment and the declined one settling it", entries)
+	}
+	if balance := postedBalance(store, config); balance != 0 {
+		t.Fatalf("balance = %d after a declined payment, want 0", balance)
+	}
+}
+
+func TestCapturePaymentGatewayFailureKeepsPendingEntry(t *testing.T) {
+	store, config := ledgerTest(t)
+
+	_, err := CapturePayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 2500, Token: payments.FakeTokenError}, config)
+	if err == nil {
+		t.Fatal("capture through a failing gateway succeeded")
+	}
+	entries := ledgerEntries(store, config)
+	if len(entries) != 1 || entries[0].Status != models.LedgerPending {
+		t.Fatalf("ledger = %+v, want the pending payment to reconcile", entries)
+	}
+}
+
+func TestCapturePaymentIsRecordedBeforeTheGatewayCharges(t *testing.T) {
+	store, config := ledgerTest(t)
+	store.Fail("Save", config.GetString("ledger-collection"), errors.New("datastore down"))
+
+	gateway := &countingGateway{Gateway: payments.NewFakeGateway()}
+	payments.Register("counting", gateway)
+	config.Data["payment-gateway"] = "counting"
+
+	_, err := CapturePayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 2500, Token: "tok_visa"}, config)
+	if err == nil {
+		t.Fatal("capture succeeded without a ledger entry")
+	}
+	if gateway.captures != 0 {
+		t.Fatalf("gateway charged %d times without a ledger entry", gateway.captures)
+	}
+}
+
+func TestRefundPaymentWithinThePayment(t *testing.T) {
+	store, config := ledgerTest(t)
+	payment := capture(t, config, 100)
+
+	refund, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 60, PaymentId: payment.Id}, config)
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if refund.Status != models.LedgerPosted || refund.PaymentId != payment.Id {
+		t.Fatalf("refund = %+v, want a posted refund of the payment", refund)
+	}
+
+	_, err = RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 50, PaymentId: payment.Id}, config)
+	var invalid *PaymentInvalidError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want PaymentInvalidError for refunding more than was paid", err)
+	}
+	if amount := refunded(store, config, payment.Id); amount != 60 {
+		t.Fatalf("refunded = %d, want 60", amount)
+	}
+}
+
+func TestConcurrentRefundsNeverExceedThePayment(t *testing.T) {
+	_, config := ledgerTest(t)
+	payment := capture(t, config, 100)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	refunded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 30, PaymentId: payment.Id}, config)
+			if err == nil {
+				mu.Lock()
+				refunded += 30
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if refunded != 90 {
+		t.Fatalf("refunded %d of a payment of 100, want 90", refunded)
+	}
+}
+
+func TestDeclinedRefundReleasesTheAmount(t *testing.T) {
+	store, config := ledgerTest(t)
+	payment := capture(t, config, 100)
+
+	gateway := &countingGateway{Gateway: payments.NewFakeGateway(), decline: true}
+	payments.Register("counting", gateway)
+	config.Data["payment-gateway"] = "counting"
+
+	_, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 100, PaymentId: payment.Id}, config)
+	var invalid *PaymentInvalidError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want PaymentInvalidError", err)
+	}
+	if amount := refunded(store, config, payment.Id); amount != 0 {
+		t.Fatalf("refunded = %d after a declined refund, want 0", amount)
+	}
+	for _, e := range ledgerEntries(store, config) {
+		if e.Type == models.LedgerRefund && e.Status == models.LedgerPosted {
+			t.Fatalf("declined refund posted %+v", e)
+		}
+	}
+}
+
+func TestReversingARefundFreesItsAmount(t *testing.T) {
+	_, config := ledgerTest(t)
+	// the gateway has the say on refunding money again
+	payments.Register("approving", approvingGateway{})
+	config.Data["payment-gateway"] = "approving"
+	payment := capture(t, config, 100)
+
+	refund, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 100, PaymentId: payment.Id}, config)
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if _, err := ReverseLedgerEntry(context.Background(), "s1", refund.Id, &models.LedgerRequestPayload{}, config); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+
+	if _, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 100, PaymentId: payment.Id}, config); err != nil {
+		t.Fatalf("refund after the reversal: %v", err)
+	}
+	_, err = RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 1, PaymentId: payment.Id}, config)
+	var invalid *PaymentInvalidError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want PaymentInvalidError for refunding more than was paid", err)
+	}
+}
+
+func TestLedgerEntriesAreNeverChanged(t *testing.T) {
+	store, config := ledgerTest(t)
+	payment := capture(t, config, 100)
+	if _, err := RefundPayment(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 40, PaymentId: payment.Id}, config); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	before := ledgerEntries(store, config)
+
+	if _, err := ReverseLedgerEntry(context.Background(), "s1", payment.Id, &models.LedgerRequestPayload{}, config); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	after := ledgerEntries(store, config)
+	if len(after) != len(before)+1 {
+		t.Fatalf("%d entries after a reversal, want %d", len(after), len(before)+1)
+	}
+	for i := range before {
+		if !reflect.DeepEqual(before[i], after[i]) {
+			t.Fatalf("entry changed from %+v to %+v", before[i], after[i])
+		}
+	}
+}
+
+func TestReversingAnEntryTwice(t *testing.T) {
+	_, config := ledgerTest(t)
+	invoice, err := AddInvoice(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 100}, config)
+	if err != nil {
+		t.Fatalf("invoice: %v", err)
+	}
+	if _, err := ReverseLedgerEntry(context.Background(), "s1", invoice.Id, &models.LedgerRequestPayload{}, config); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+
+	_, err = ReverseLedgerEntry(context.Background(), "s1", invoice.Id, &models.LedgerRequestPayload{}, config)
+	var duplicate *DuplicateError
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("err = %v, want DuplicateError", err)
+	}
+}
+
+func TestConcurrentReversalIsRefusedByTheIndex(t *testing.T) {
+	store, config := ledgerTest(t)
+	invoice, err := AddInvoice(context.Background(), "s1", &models.LedgerRequestPayload{Amount: 100}, config)
+	if err != nil {
+		t.Fatalf("invoice: %v", err)
+	}
+	if _, err := ReverseLedgerEntry(context.Background(), "s1", invoice.Id, &models.LedgerRequestPayload{}, config); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+
+	// the second reversal looked for an existing one before the first was saved
+	collection := config.GetString("ledger-collection")
+	store.Fail("GetById", collection, nil, &datastore.NotFoundError{Collection: collection})
+	_, err = ReverseLedgerEntry(context.Background(), "s1", invoice.Id, &models.LedgerRequestPayload{}, config)
+	var duplicate *DuplicateError
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("err = %v, want DuplicateError", err)
+	}
+	reversals := 0
+	for _, e := range ledgerEntries(store, config) {
+		if e.Type == models.LedgerReversal {
+			reversals++
+		}
+	}
+	if reversals != 1 {
+		t.Fatalf("%d reversals of one entry, want 1", reversals)
+	}
+}
+
+// countingGateway counts the captures reaching the gateway, and declines
+// every refund with decline.
+type countingGateway struct {
+	payments.Gateway
+	captures int
+	decline  bool
+}
+
+func (g *countingGateway) Capture(ctx context.Context, req payments.CaptureRequest) (*payments.Result, error) {
+	g.captures++
+	return g.Gateway.Capture(ctx, req)
+}
+
+func (g *countingGateway) Refund(ctx context.Context, req payments.RefundRequest) (*payments.Result, error) {
+	if g.decline {
+		return &payments.Result{Approved: false, Reason: "refunds are closed"}, nil
+	}
+	return g.Gateway.Refund(ctx, req)
+}
+
+// approvingGateway approves every capture and refund.
+type approvingGateway struct{}
+
+func (approvingGateway) Capture(ctx context.Context, req payments.CaptureRequest) (*payments.Result, error) {
+	return &payments.Result{Reference: "approved_" + req.EntryId, Approved: true}, nil
+}
+
+func (approvingGateway) Refund(ctx context.Context, req payments.RefundRequest) (*payments.Result, error) {
+	return &payments.Result{Reference: "approved_refund", Approved: true}, nil
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/jobs"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusApplicant = "applicant"
+	StatusAdmitted  = "admitted"
+	StatusEnrolled  = "enrolled"
+	StatusSuspended = "suspended"
+	StatusGraduated = "graduated"
+	StatusWithdrawn = "withdrawn"
+)
+
+// TransitionGuard decides whether a student may move to the target status.
+// Returning an error made with RefuseTransition refuses the transition, its
+// message is reported to the caller as the reason. Any other error fails the
+// transition as it is.
+type TransitionGuard func(ctx context.Context, student *models.Student, transition *models.StatusTransition, config *MapPropertySource) error
+
+// TransitionRefusedError is a guard refusing a transition.
+type TransitionRefusedError struct {
+	Reason string
+}
+
+func (e *TransitionRefusedError) Error() string {
+	return e.Reason
+}
+
+// RefuseTransition is the error a guard returns to refuse a transition.
+func RefuseTransition(format string, args ...interface{}) error {
+	return &TransitionRefusedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// TransitionHook is a side effect of a transition. It is queued with the
+// transition and runs on the job queue afterwards, failing hooks are retried
+// up to job-max-attempts, so a hook may run more than once.
+type TransitionHook func(ctx context.Context, student *models.Student, transition *models.StatusTransition, config *MapPropertySource) error
+
+type transitionHook struct {
+	name string
+	hook TransitionHook
+}
+
+var (
+	lifecycleLock sync.RWMutex
+	guards        = map[string][]TransitionGuard{}
+	hooks         = map[string][]transitionHook{}
+)
+
+// RegisterTransitionGuard adds a guard for transitions into the status "to".
+func RegisterTransitionGuard(to string, guard TransitionGuard) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	guards[to] = append(guards[to], guard)
+}
+
+// RegisterTransitionHook adds a side effect for transitions into the status
+// "to". The name identifies the hook in the queued jobs.
+func RegisterTransitionHook(to, name string, hook TransitionHook) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+	hooks[to] = append(hooks[to], transitionHook{name, hook})
+}
+
+func getTransitionHook(to, name string) (TransitionHook, bool) {
+	lifecycleLock.RLock()
+	defer lifecycleLock.RUnlock()
+	for _, h := range hooks[to] {
+		if h.name == name {
+			return h.hook, true
+		}
+	}
+	return nil, false
+}
+
+const transitionHookJob = "student-transition-hook"
+
+type transitionHookPayload struct {
+	TransitionId string `json:"transitionId"`
+	Hook         string `json:"hook"`
+}
+
+func init() {
+	jobs.Register(transitionHookJob, runTransitionHook)
+	RegisterTransitionGuard(StatusGraduated, outstandingBalanceGuard)
+	RegisterTransitionHook(StatusWithdrawn, "drop-enrollments", dropEnrollmentsHook)
+	RegisterTransitionHook(StatusGraduated, "drop-enrollments", dropEnrollmentsHook)
+}
+
+func outstandingBalanceGuard(ctx context.Context, student *models.Student, transition *models.StatusTransition, config *MapPropertySource) error {
+	balance, err := GetLedgerBalance(ctx, student.Id, config)
+	if err != nil {
+		return err
+	}
+	if balance > 0 {
+		return RefuseTransition("student has an outstanding balance of %d", balance)
+	}
+	return nil
+}
+
+func dropEnrollmentsHook(ctx context.Context, student *models.Student, transition *models.StatusTransition, config *MapPropertySource) error {
+	return DropStudentEnrollments(ctx, student.Id, config)
+}
+
+// StudentLifecycle reads the allowed transitions from the student-lifecycle
+// configuration, written as "from>to|to;from>to".
+func StudentLifecycle(config *MapPropertySource) map[string][]string {
+	lifecycle := map[string][]string{}
+	for _, rule := range strings.Split(config.GetString("student-lifecycle"), ";") {
+		parts := strings.SplitN(strings.TrimSpace(rule), ">", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		from := strings.TrimSpace(parts[0])
+		for _, to := range strings.Split(parts[1], "|") {
+			if to = strings.TrimSpace(to); to != "" {
+				lifecycle[from] = append(lifecycle[from], to)
+			}
+		}
+	}
+	return lifecycle
+}
+
+func InitialStudentStatus(config *MapPropertySource) string {
+	return config.GetString("student-lifecycle-initial")
+}
+
+func ParseTransition(ctx context.Context, req HttpWebRequest) (*models.TransitionRequestPayload, error) {
+	var payload models.TransitionRequestPayload
+
+	err := req.Decode(&payload)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// TransitionStudent moves the student to the requested status if the
+// lifecycle allows it and every guard agrees. The current version is part of
+// the update filter so two concurrent transitions cannot both succeed. The
+// guards are asked again inside the transaction, which also queues the hooks.
+func TransitionStudent(ctx context.Context, studentId, actor string, payload *models.TransitionRequestPayload, config *MapPropertySource) (*models.StatusTransition, error) {
+	if payload.To == "" {
+		return nil, Error.MissingRequiredProperty("to")
+	}
+
+	var student models.Student
+	student.Id = studentId
+	err := GetStudent(ctx, &student, config)
+	if err != nil {
+		return nil, err
+	}
+
+	from := student.Status
+	if from == "" {
+		from = InitialStudentStatus(config)
+	}
+	allowed := StudentLifecycle(config)[from]
+	if !contains(allowed, payload.To) {
+		return nil, Error.InvalidTransition(from, payload.To, allowed, "")
+	}
+
+	now := time.Now()
+	transition := &models.StatusTransition{
+		Id:        uuid.NewV4().String(),
+		StudentId: studentId,
+		From:      from,
+		To:        payload.To,
+		Reason:    payload.Reason,
+		By:        actor,
+		At:        now,
+	}
+
+	lifecycleLock.RLock()
+	toGuards := guards[payload.To]
+	toHooks := hooks[payload.To]
+	lifecycleLock.RUnlock()
+
+	err = checkTransitionGuards(ctx, toGuards, &student, transition, allowed, config)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := versionedStudentFilter(studentId, student.Meta.Version)
+	query := bson.D{{"$set", bson.D{
+		{"status", payload.To},
+		{"meta.lastModified", now},
+		{"meta.version", NextVersion(student.Meta.Version)},
+	}}}
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("students-collection"), filter, query)
+		if err != nil {
+			return err
+		}
+		if matched == 0 {
+			return Error.InvalidTransition(from, payload.To, allowed, "student was changed concurrently")
+		}
+		// what the guards read may have changed since they were asked
+		err = checkTransitionGuards(ctx, toGuards, &student, transition, allowed, config)
+		if err != nil {
+			return err
+		}
+
+		err = archiveStudent(ctx, &student, VersionTransition, now, config)
+		if err != nil {
+			return err
+		}
+
+		err = datastore.GetDatastore().Save(ctx, config.GetString("student-transitions-collection"), transition)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range toHooks {
+			_, err = jobs.Enqueue(ctx, transitionHookJob, transitionHookPayload{transition.Id, h.name}, config)
+			if err != nil {
+				return err
+			}
+		}
+
+		changed := student
+		changed.Status = payload.To
+		changed.Meta.LastModified = now
+		changed.Meta.Version = NextVersion(student.Meta.Version)
+		return recordStudentEvent(ctx, models.EventStudentUpdated, &changed, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transition, nil
+}
+
+func checkTransitionGuards(ctx context.Context, toGuards []TransitionGuard, student *models.Student, transition *models.StatusTransition, allowed []string, config *MapPropertySource) error {
+	for _, guard := range toGuards {
+		err := guard(ctx, student, transition, config)
+		var refused *TransitionRefusedError
+		if errors.As(err, &refused) {
+			return Error.InvalidTransition(transition.From, transition.To, allowed, refused.Reason)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTransitionHook runs a side effect of a stored transition for the job
+// queue, on the student as it is now.
+func runTransitionHook(ctx context.Context, job *models.Job, progress jobs.Progress) error {
+	config := GetConfigs()
+	var p transitionHookPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+
+	var transition models.StatusTransition
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("student-transitions-collection"), bson.D{{"id", p.TransitionId}}, &transition)
+	if err != nil {
+		return err
+	}
+	hook, ok := getTransitionHook(transition.To, p.Hook)
+	if !ok {
+		Warn(ctx, fmt.Sprintf("Transition hook %s to %s is not registered, StatusTransition(%s) skipped", p.Hook, transition.To, transition.Id))
+		return nil
+	}
+
+	var student models.Student
+	student.Id = transition.StudentId
+	err = GetStudent(ctx, &student, config)
+	if _, ok := err.(*ResourceNotFoundError); ok {
+		Info(ctx, fmt.Sprintf("Student(%s) is gone, transition hook %s skipped", transition.StudentId, p.Hook))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return hook(ctx, &student, &transition, config)
+}
+
+func GetStudentTransitions(ctx context.Context, studentId string, config *MapPropertySource) ([]models.StatusTransition, error) {
+	transitions := make([]models.StatusTransition, 0)
+	opt := options.Find().SetSort(bson.D{{"at", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("student-transitions-collection"), bson.D{{"studentId", studentId}}, opt, &transitions)
+	if err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 )
 
 func GetFilter(params map[string]interface{}) (interface{}, *options.FindOptions, error) {
@@ -10,7 +11,7 @@ func GetFilter(params map[string]interface{}) (interface{}, *options.FindOptions
 	choice := options.FindOptions{}
 
 	for i, v := range params {
-		if i == "sortorder" || i == "sortfield" {
+		if i == "sortorder" || i == "sortfield" || i == "attributes" || i == "limit" || i == "offset" {
 			continue
 		}
 		if i == "dateStart" {
@@ -44,6 +45,23 @@ func GetFilter(params map[string]interface{}) (interface{}, *options.FindOptions
 		choice.Sort = bson.D{{v.(string), -1}}
 	}
 
+	if v, ok := params["attributes"]; ok {
+		projection := bson.D{{"_id", 0}}
+		for _, a := range strings.Split(v.(string), ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				projection = append(projection, bson.E{Key: a, Value: 1})
+			}
+		}
+		choice.Projection = projection
+	}
+
+	if v, ok := params["limit"].(int); ok && v > 0 {
+		choice.SetLimit(int64(v))
+	}
+	if v, ok := params["offset"].(int); ok && v > 0 {
+		choice.SetSkip(int64(v))
+	}
+
 	filter := bson.D{{}}
 	if len(setElements) > 0 {
 		filter = bson.D{{"$and", setElements}}
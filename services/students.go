@@ -3,19 +3,53 @@ package service
 import (
 	"awesomeTestProject/datastore"
 	"awesomeTestProject/models"
+	"awesomeTestProject/outbox"
 	. "awesomeTestProject/shared"
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"sync"
 	"time"
 )
 
+var studentIndex sync.Once
+
+// ensureStudentIndex makes mongo refuse a second student with the same id or
+// enrollment number, which concurrent requests creating students would
+// otherwise both insert.
+func ensureStudentIndex(ctx context.Context, config *MapPropertySource) {
+	studentIndex.Do(func() {
+		collection := config.GetString("students-collection")
+		err := datastore.GetDatastore().EnsureUnique(ctx, collection, bson.D{{"id", 1}}, nil)
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the id index of %s: %s", collection, err.Error()))
+		}
+		partial := bson.D{{"enrollmentNumber", bson.D{{"$gt", ""}}}}
+		err = datastore.GetDatastore().EnsureUnique(ctx, collection, bson.D{{"enrollmentNumber", 1}}, partial)
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the enrollmentNumber index of %s: %s", collection, err.Error()))
+		}
+	})
+}
+
+// duplicateStudent tells which unique attribute of the student a duplicate
+// key error of the students collection is about.
+func duplicateStudent(err error, student *models.Student) error {
+	if strings.Contains(err.Error(), "enrollmentNumber") {
+		return Error.Duplicate("enrollmentNumber", student.EnrollmentNumber)
+	}
+	return Error.Duplicate("id", student.Id)
+}
+
 func ParseStudent(ctx context.Context, req HttpWebRequest) (*models.Student, error) {
 	var student models.Student
 
-	b, _ := req.Body()
-	err := json.Unmarshal([]byte(b), &student)
+	err := req.Decode(&student)
 	if err != nil {
 		Fatal(ctx, "Unable to deserialize the request body")
 		return nil, err
@@ -31,8 +65,7 @@ func ParseStudent(ctx context.Context, req HttpWebRequest) (*models.Student, err
 func ParsePatchStudent(ctx context.Context, req HttpWebRequest) *models.PatchRequestPayload {
 	var patchPayload models.PatchRequestPayload
 
-	b, _ := req.Body()
-	err := json.Unmarshal([]byte(b), &patchPayload)
+	err := req.Decode(&patchPayload)
 	if err != nil {
 		Fatal(ctx, "Unable to deserialize the request body")
 		return nil
@@ -48,6 +81,7 @@ func ParseGetRequest(ctx context.Context, req HttpWebRequest) (map[string]interf
 	name := req.Param("name")
 	order := req.Param("sortorder")
 	field := req.Param("sortfield")
+	attributes := req.Param("attributes")
 
 	if id != "" {
 		params["id"] = id
@@ -61,54 +95,236 @@ func ParseGetRequest(ctx context.Context, req HttpWebRequest) (map[string]interf
 	if field != "" {
 		params["sortfield"] = field
 	}
+	if attributes != "" {
+		params["attributes"] = attributes
+	}
 	return params, nil
 }
 
-func SaveStudent(ctx context.Context, student *models.Student, config *MapPropertySource) (*models.Student, error) {
+// NewStudent fills in the server assigned attributes of a student about to be
+// created.
+func NewStudent(student *models.Student, config *MapPropertySource) {
 	student.Id = uuid.NewV4().String()
+	student.Status = InitialStudentStatus(config)
 	student.Meta.Created = time.Now()
 	student.Meta.LastModified = time.Now()
+	student.Meta.Version = NextVersion("")
+}
 
-	err := datastore.GetDatastore().Save(ctx, config.GetString("students-collection"), student)
+// DuplicateEnrollmentNumbers finds the students whose enrollment number is
+// already taken, either by a stored student or by a student earlier in the
+// list. The result is keyed by the index in students.
+func DuplicateEnrollmentNumbers(ctx context.Context, students []*models.Student, config *MapPropertySource) (map[int]error, error) {
+	duplicates := map[int]error{}
+	numbers := bson.A{}
+	seen := map[string]bool{}
+	for i, s := range students {
+		if s.EnrollmentNumber == "" {
+			continue
+		}
+		if seen[s.EnrollmentNumber] {
+			duplicates[i] = Error.Duplicate("enrollmentNumber", s.EnrollmentNumber)
+			continue
+		}
+		seen[s.EnrollmentNumber] = true
+		numbers = append(numbers, s.EnrollmentNumber)
+	}
+	if len(numbers) == 0 {
+		return duplicates, nil
+	}
+
+	var existing []models.Student
+	filter := bson.D{{"enrollmentNumber", bson.D{{"$in", numbers}}}}
+	opt := options.Find().SetProjection(bson.D{{"enrollmentNumber", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("students-collection"), filter, opt, &existing)
+	if err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, s := range existing {
+		taken[s.EnrollmentNumber] = true
+	}
+	for i, s := range students {
+		if taken[s.EnrollmentNumber] {
+			duplicates[i] = Error.Duplicate("enrollmentNumber", s.EnrollmentNumber)
+		}
+	}
+	return duplicates, nil
+}
+
+func SaveStudent(ctx context.Context, student *models.Student, config *MapPropertySource) (*models.Student, error) {
+	duplicates, err := DuplicateEnrollmentNumbers(ctx, []*models.Student{student}, config)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := duplicates[0]; ok {
+		return nil, err
+	}
+
+	NewStudent(student, config)
+	ensureStudentIndex(ctx, config)
+
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		err := datastore.GetDatastore().Save(ctx, config.GetString("students-collection"), student)
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateStudent(err, student)
+		}
+		if err != nil {
+			return err
+		}
+		return recordStudentEvent(ctx, models.EventStudentCreated, student, config)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return student, nil
 }
 
-func GetStudents(ctx context.Context, params map[string]interface{}, config *MapPropertySource) ([]byte, error) {
-	var student models.Student
+// recordStudentEvent stores the event of a student change in the outbox,
+// within the transaction ctx carries.
+func recordStudentEvent(ctx context.Context, eventType string, student *models.Student, config *MapPropertySource) error {
+	return outbox.Record(ctx, eventType, student.Id, student, config)
+}
+
+// saveStudentBatch inserts new students through SaveMany and records their
+// creation in the outbox in one transaction. A student that can not be
+// inserted aborts the transaction, which is then run again without it. The
+// students that were not created are returned with their error by index.
+func saveStudentBatch(ctx context.Context, students []*models.Student, config *MapPropertySource) (map[int]error, error) {
+	ensureStudentIndex(ctx, config)
+	failed := map[int]error{}
+	for {
+		pending := make([]int, 0, len(students))
+		for i := range students {
+			if _, ok := failed[i]; !ok {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) == 0 {
+			return failed, nil
+		}
+
+		var rejected map[int]error
+		err := datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+			rejected = map[int]error{}
+			dtos := make([]interface{}, 0, len(pending))
+			for _, i := range pending {
+				dtos = append(dtos, students[i])
+			}
+			err := datastore.GetDatastore().SaveMany(ctx, config.GetString("students-collection"), dtos)
+			var writeErr mongo.BulkWriteException
+			if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+				for _, we := range writeErr.WriteErrors {
+					i := pending[we.Index]
+					if mongo.IsDuplicateKeyError(we) {
+						rejected[i] = duplicateStudent(we, students[i])
+					} else {
+						rejected[i] = Error.Datastore(we)
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+			for _, i := range pending {
+				if err := recordStudentEvent(ctx, models.EventStudentCreated, students[i], config); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			return failed, nil
+		}
+		if len(rejected) == 0 {
+			return nil, err
+		}
+		for i, err := range rejected {
+			failed[i] = err
+		}
+	}
+}
+
+func GetStudents(ctx context.Context, params map[string]interface{}, config *MapPropertySource) ([]models.Student, error) {
+	students := make([]models.Student, 0)
 	filter, opt, err := GetFilter(params)
 	if err != nil {
 		return nil, err
 	}
 
-	return datastore.GetDatastore().GetByFilter(ctx, config.GetString("students-collection"), filter, opt, &student)
+	err = datastore.GetDatastore().GetAll(ctx, config.GetString("students-collection"), filter, opt, &students)
+	if err != nil {
+		return nil, err
+	}
+	return students, nil
 }
 
 func GetStudent(ctx context.Context, student *models.Student, config *MapPropertySource) error {
 	filter := bson.D{{"id", student.Id}}
-	return datastore.GetDatastore().GetById(ctx, config.GetString("students-collection"), filter, student)
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("students-collection"), filter, student)
+	if datastore.IsNotFound(err) {
+		return Error.ResourceNotFound(student.Id, "")
+	}
+	return err
+}
+
+// readOnlyStudentPath tells whether a patch path is one of the attributes the
+// server maintains: the id, the status, which only transitions change, and
+// anything under meta.
+func readOnlyStudentPath(path string) bool {
+	return path == "id" || path == "_id" || path == "status" || path == "meta" || strings.HasPrefix(path, "meta.")
 }
 
 func PatchStudent(ctx context.Context, patchPayload *models.PatchRequestPayload, config *MapPropertySource) (*models.Student, error) {
+	var current models.Student
+	current.Id = patchPayload.Id
+	err := GetStudent(ctx, &current, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ensureStudentIndex(ctx, config)
+	now := time.Now()
 	var setElements bson.D
+	patched := map[string]interface{}{}
 	for _, v := range patchPayload.Operations {
+		if readOnlyStudentPath(v.Path) {
+			return nil, Error.MutabilityViolation(v.Path)
+		}
+		patched[v.Path] = v.Value
 		setElements = append(setElements, bson.E{Key: v.Path, Value: v.Value})
 	}
-	setElements = append(setElements, bson.E{Key: "meta.lastModified", Value: time.Now()})
+	setElements = append(setElements, bson.E{Key: "meta.lastModified", Value: now})
+	setElements = append(setElements, bson.E{Key: "meta.version", Value: NextVersion(current.Meta.Version)})
 
 	query := bson.D{{"$set", setElements}}
 
-	filter := bson.D{{"id", patchPayload.Id}}
-	err := datastore.GetDatastore().Update(ctx, config.GetString("students-collection"), filter, query)
-	if err != nil {
-		return nil, err
-	}
-
 	var student models.Student
-	student.Id = patchPayload.Id
-	err = GetStudent(ctx, &student, config)
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		filter := versionedStudentFilter(current.Id, current.Meta.Version)
+		matched, err := datastore.GetDatastore().UpdateMatched(ctx, config.GetString("students-collection"), filter, query)
+		if mongo.IsDuplicateKeyError(err) {
+			return Error.Duplicate("enrollmentNumber", fmt.Sprint(patched["enrollmentNumber"]))
+		}
+		if err != nil {
+			return err
+		}
+		if matched == 0 {
+			return Error.VersionConflict(current.Id, current.Meta.Version)
+		}
+
+		err = archiveStudent(ctx, &current, VersionPatch, now, config)
+		if err != nil {
+			return err
+		}
+
+		student = models.Student{Id: patchPayload.Id}
+		err = GetStudent(ctx, &student, config)
+		if err != nil {
+			return err
+		}
+		return recordStudentEvent(ctx, models.EventStudentUpdated, &student, config)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -116,31 +332,100 @@ func PatchStudent(ctx context.Context, patchPayload *models.PatchRequestPayload,
 	return &student, err
 }
 
-func PutStudent(ctx context.Context, student *models.Student, config *MapPropertySource) (*models.Student, error) {
-	var setElements bson.D
+// PutStudent replaces the student with the given one. The read only
+// attributes id, status and meta.created are kept from the stored student.
+// When the student does not exist it is created if put-upsert is enabled,
+// otherwise a ResourceNotFoundError is returned. The returned flag tells
+// whether the student was created.
+func PutStudent(ctx context.Context, student *models.Student, config *MapPropertySource) (*models.Student, bool, error) {
+	var existing models.Student
+	existing.Id = student.Id
+	err := GetStudent(ctx, &existing, config)
+	_, created := err.(*ResourceNotFoundError)
+	if err != nil && !created {
+		return nil, false, err
+	}
+
+	if created && !config.GetBool("put-upsert") {
+		return nil, false, Error.ResourceNotFound(student.Id, "")
+	}
 
-	setElements = append(setElements, bson.E{Key: "name", Value: student.Name})
-	setElements = append(setElements, bson.E{Key: "meta.lastModified", Value: time.Now()})
+	now := time.Now()
+	if created {
+		student.Status = InitialStudentStatus(config)
+		student.Meta = models.Meta{Created: now, Version: NextVersion("")}
+	} else {
+		student.Status = existing.Status
+		student.Meta = existing.Meta
+		student.Meta.Version = NextVersion(existing.Meta.Version)
+	}
+	student.Meta.LastModified = now
 
-	query := bson.D{{"$set", setElements}}
+	ensureStudentIndex(ctx, config)
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		if created {
+			// a student put concurrently under the same id is refused by the
+			// index instead of replaced
+			err := datastore.GetDatastore().Save(ctx, config.GetString("students-collection"), student)
+			if mongo.IsDuplicateKeyError(err) {
+				return duplicateStudent(err, student)
+			}
+			if err != nil {
+				return err
+			}
+			return recordStudentEvent(ctx, models.EventStudentCreated, student, config)
+		}
 
-	filter := bson.D{{"id", student.Id}}
-	err := datastore.GetDatastore().Update(ctx, config.GetString("students-collection"), filter, query)
+		filter := versionedStudentFilter(student.Id, existing.Meta.Version)
+		matched, err := datastore.GetDatastore().Replace(ctx, config.GetString("students-collection"), filter, student, false)
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateStudent(err, student)
+		}
+		if err != nil {
+			return err
+		}
+		if matched == 0 {
+			return Error.VersionConflict(student.Id, existing.Meta.Version)
+		}
+
+		err = archiveStudent(ctx, &existing, VersionUpdate, now, config)
+		if err != nil {
+			return err
+		}
+		return recordStudentEvent(ctx, models.EventStudentUpdated, student, config)
+	})
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	return student, created, nil
+}
 
-	var studentDB models.Student
-	studentDB.Id = student.Id
-	err = GetStudent(ctx, &studentDB, config)
+func DeleteStudent(ctx context.Context, id string, config *MapPropertySource) error {
+	var current models.Student
+	current.Id = id
+	err := GetStudent(ctx, &current, config)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &studentDB, err
-}
+	return datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		err := ReleaseStudentEnrollments(ctx, id, config)
+		if err != nil {
+			return err
+		}
 
-func DeleteStudent(ctx context.Context, id string, config *MapPropertySource) error {
-	filter := bson.D{{"id", id}}
-	return datastore.GetDatastore().Delete(ctx, config.GetString("students-collection"), filter)
-}
\ No newline at end of file
+		filter := versionedStudentFilter(id, current.Meta.Version)
+		deleted, err := datastore.GetDatastore().Delete(ctx, config.GetString("students-collection"), filter)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return Error.VersionConflict(id, current.Meta.Version)
+		}
+		err = archiveStudent(ctx, &current, VersionDelete, time.Now(), config)
+		if err != nil {
+			return err
+		}
+		return recordStudentEvent(ctx, models.EventStudentDeleted, &current, config)
+	})
+}
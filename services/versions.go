@@ -0,0 +1,178 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strconv"
+	"time"
+)
+
+const (
+	VersionUpdate     = "update"
+	VersionPatch      = "patch"
+	VersionTransition = "transition"
+	VersionRevert     = "revert"
+	VersionDelete     = "delete"
+)
+
+// NextVersion is the version following v. Students stored before versioning
+// have no version and are treated as version 0.
+func NextVersion(v string) string {
+	n, _ := strconv.Atoi(v)
+	return strconv.Itoa(n + 1)
+}
+
+// versionedStudentFilter matches the student only while it is still at the
+// given version, so an update based on a stale read matches nothing.
+func versionedStudentFilter(id, version string) bson.D {
+	var current interface{} = version
+	if version == "" {
+		current = bson.D{{"$in", bson.A{"", nil}}}
+	}
+	return bson.D{{"id", id}, {"meta.version", current}}
+}
+
+// archiveStudent stores the prior version of a student in the history once
+// it has been superseded at the given time.
+func archiveStudent(ctx context.Context, prior *models.Student, supersededBy string, at time.Time, config *MapPropertySource) error {
+	version := prior.Meta.Version
+	if version == "" {
+		version = "0"
+	}
+	return datastore.GetDatastore().Save(ctx, config.GetString("student-versions-collection"), &models.StudentVersion{
+		StudentId:    prior.Id,
+		Version:      version,
+		ValidFrom:    prior.Meta.LastModified,
+		ValidTo:      at,
+		SupersededBy: supersededBy,
+		Student:      *prior,
+	})
+}
+
+func GetStudentVersions(ctx context.Context, id string, config *MapPropertySource) (*models.StudentVersions, error) {
+	versions := make([]models.StudentVersion, 0)
+	opt := options.Find().SetSort(bson.D{{"validTo", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("student-versions-collection"), bson.D{{"studentId", id}}, opt, &versions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.StudentVersions{StudentId: id, Versions: versions}
+	var student models.Student
+	student.Id = id
+	err = GetStudent(ctx, &student, config)
+	switch err.(type) {
+	case nil:
+		result.Current = &student
+	case *ResourceNotFoundError:
+		if len(versions) == 0 {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetStudentVersion returns the student as it was at the given version,
+// whether that is the current version or one from the history.
+func GetStudentVersion(ctx context.Context, id, version string, config *MapPropertySource) (*models.Student, error) {
+	var student models.Student
+	student.Id = id
+	err := GetStudent(ctx, &student, config)
+	if _, ok := err.(*ResourceNotFoundError); err != nil && !ok {
+		return nil, err
+	}
+	if err == nil && student.Meta.Version == version {
+		return &student, nil
+	}
+
+	var archived models.StudentVersion
+	filter := bson.D{{"studentId", id}, {"version", version}}
+	err = datastore.GetDatastore().GetById(ctx, config.GetString("student-versions-collection"), filter, &archived)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &archived.Student, nil
+}
+
+// GetStudentAsOf returns the student as it was at the given time.
+func GetStudentAsOf(ctx context.Context, id string, asOf time.Time, config *MapPropertySource) (*models.Student, error) {
+	var student models.Student
+	student.Id = id
+	err := GetStudent(ctx, &student, config)
+	if _, ok := err.(*ResourceNotFoundError); err != nil && !ok {
+		return nil, err
+	}
+	if err == nil && !student.Meta.LastModified.After(asOf) {
+		return &student, nil
+	}
+
+	var archived models.StudentVersion
+	filter := bson.D{
+		{"studentId", id},
+		{"validFrom", bson.D{{"$lte", asOf}}},
+		{"validTo", bson.D{{"$gt", asOf}}},
+	}
+	err = datastore.GetDatastore().GetById(ctx, config.GetString("student-versions-collection"), filter, &archived)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(id, asOf.Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &archived.Student, nil
+}
+
+// RevertStudent makes the content of an earlier version current again. The
+// revert is stored as a new version, the status is left alone because it only
+// changes through lifecycle transitions.
+func RevertStudent(ctx context.Context, id, version string, config *MapPropertySource) (*models.Student, error) {
+	var current models.Student
+	current.Id = id
+	err := GetStudent(ctx, &current, config)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := GetStudentVersion(ctx, id, version, config)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	reverted := *target
+	reverted.Id = id
+	reverted.Status = current.Status
+	reverted.Meta = current.Meta
+	reverted.Meta.Version = NextVersion(current.Meta.Version)
+	reverted.Meta.LastModified = now
+
+	err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+		matched, err := datastore.GetDatastore().Replace(ctx, config.GetString("students-collection"),
+			versionedStudentFilter(id, current.Meta.Version), &reverted, false)
+		if err != nil {
+			return err
+		}
+		if matched == 0 {
+			return Error.VersionConflict(id, current.Meta.Version)
+		}
+
+		err = archiveStudent(ctx, &current, VersionRevert, now, config)
+		if err != nil {
+			return err
+		}
+		return recordStudentEvent(ctx, models.EventStudentUpdated, &reverted, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reverted, nil
+}
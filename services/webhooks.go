@@ -0,0 +1,483 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/jobs"
+	"awesomeTestProject/models"
+	"awesomeTestProject/outbox"
+	. "awesomeTestProject/shared"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const webhookDeliveryJob = "webhook-delivery"
+
+// WebhookEvents are the event types a subscription can listen to.
+var WebhookEvents = []string{models.EventStudentCreated, models.EventStudentUpdated, models.EventStudentDeleted}
+
+type webhookDeliveryPayload struct {
+	DeliveryId string `json:"deliveryId"`
+}
+
+func init() {
+	jobs.Register(webhookDeliveryJob, deliverWebhook)
+	for _, eventType := range WebhookEvents {
+		outbox.Subscribe(eventType, publishEvent)
+	}
+}
+
+func ParseWebhookSubscription(ctx context.Context, req HttpWebRequest) (*models.WebhookSubscriptionPayload, error) {
+	var payload models.WebhookSubscriptionPayload
+
+	err := req.Decode(&payload)
+	if err != nil {
+		Fatal(ctx, "Unable to deserialize the request body")
+		return nil, err
+	}
+	return &payload, nil
+}
+
+func validateWebhookSubscription(payload *models.WebhookSubscriptionPayload, config *MapPropertySource) error {
+	if payload.Url == "" {
+		return Error.MissingRequiredProperty("url")
+	}
+	u, err := url.Parse(payload.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Error.InvalidParam("url", "an absolute http or https URL", payload.Url)
+	}
+	if !config.GetBool("webhook-allow-private") {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || ip != nil && privateAddress(ip) {
+			return Error.InvalidParam("url", "a URL of a public host", host)
+		}
+	}
+	if len(payload.Events) == 0 {
+		return Error.MissingRequiredProperty("events")
+	}
+	for _, e := range payload.Events {
+		if !contains(WebhookEvents, e) {
+			return Error.InvalidParam("events", strings.Join(WebhookEvents, ", "), e)
+		}
+	}
+	return nil
+}
+
+// CreateWebhookSubscription stores an active subscription. Without a secret
+// one is generated, the returned subscription is the only place it is shown.
+func CreateWebhookSubscription(ctx context.Context, payload *models.WebhookSubscriptionPayload, config *MapPropertySource) (*models.WebhookSubscription, error) {
+	err := validateWebhookSubscription(payload, config)
+	if err != nil {
+		return nil, err
+	}
+	secret := payload.Secret
+	if secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = "whsec_" + hex.EncodeToString(b)
+	}
+
+	now := time.Now()
+	subscription := &models.WebhookSubscription{
+		Id:     uuid.NewV4().String(),
+		Url:    payload.Url,
+		Events: payload.Events,
+		Secret: secret,
+		Active: payload.Active == nil || *payload.Active,
+		Meta:   models.Meta{ResourceType: "WebhookSubscription", Created: now, LastModified: now},
+	}
+	err = datastore.GetDatastore().Save(ctx, config.GetString("webhooks-collection"), subscription)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// privateAddress tells whether ip is a loopback, link local, private or
+// otherwise internal address, which webhooks must not reach unless
+// webhook-allow-private is set: a subscription could read the cloud metadata
+// at 169.254.169.254 or services of the internal network otherwise.
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// webhookClient sends the deliveries. The address every connection is made
+// to is checked once the host name is resolved, so neither a host resolving
+// to an internal address nor a redirect gets around webhook-allow-private.
+var webhookClient = &http.Client{Transport: &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if GetConfigs().GetBool("webhook-allow-private") {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+				return Error.Text("webhooks may not connect to %s", host)
+			}
+			return nil
+		},
+	}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+	MaxIdleConnsPerHost: 4,
+}}
+
+func GetWebhookSubscriptions(ctx context.Context, config *MapPropertySource) ([]models.WebhookSubscription, error) {
+	subscriptions := make([]models.WebhookSubscription, 0)
+	opt := options.Find().SetSort(bson.D{{"meta.created", 1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("webhooks-collection"), bson.D{}, opt, &subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return subscriptions, nil
+}
+
+func GetWebhookSubscription(ctx context.Context, id string, config *MapPropertySource) (*models.WebhookSubscription, error) {
+	subscription, err := getWebhookSubscription(ctx, id, config)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+func getWebhookSubscription(ctx context.Context, id string, config *MapPropertySource) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	err := datastore.GetDatastore().GetById(ctx, config.GetString("webhooks-collection"), bson.D{{"id", id}}, &subscription)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(id, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// UpdateWebhookSubscription replaces the url and events of a subscription,
+// and the secret when one is given. Enabling a subscription again clears its
+// failures.
+func UpdateWebhookSubscription(ctx context.Context, id string, payload *models.WebhookSubscriptionPayload, config *MapPropertySource) (*models.WebhookSubscription, error) {
+	err := validateWebhookSubscription(payload, config)
+	if err != nil {
+		return nil, err
+	}
+	current, err := getWebhookSubscription(ctx, id, config)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.D{
+		{"url", payload.Url},
+		{"events", payload.Events},
+		{"meta.lastModified", time.Now()},
+	}
+	if payload.Secret != "" {
+		set = append(set, bson.E{"secret", payload.Secret})
+	}
+	if payload.Active != nil {
+		set = append(set, bson.E{"active", *payload.Active})
+	}
+	enabled := payload.Active != nil && *payload.Active && !current.Active
+	if enabled {
+		set = append(set, bson.E{"consecutiveFailures", 0})
+	}
+	query := bson.D{{"$set", set}}
+	if enabled {
+		query = append(query, bson.E{"$unset", bson.D{{"disabledReason", ""}, {"disabledAt", ""}}})
+	}
+	err = datastore.GetDatastore().Update(ctx, config.GetString("webhooks-collection"), bson.D{{"id", id}}, query)
+	if err != nil {
+		return nil, err
+	}
+	return GetWebhookSubscription(ctx, id, config)
+}
+
+func DeleteWebhookSubscription(ctx context.Context, id string, config *MapPropertySource) error {
+	deleted, err := datastore.GetDatastore().Delete(ctx, config.GetString("webhooks-collection"), bson.D{{"id", id}})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return Error.ResourceNotFound(id, "")
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the delivery log of a subscription, the most
+// recent first.
+func GetWebhookDeliveries(ctx context.Context, subscriptionId string, config *MapPropertySource) ([]models.WebhookDelivery, error) {
+	if _, err := getWebhookSubscription(ctx, subscriptionId, config); err != nil {
+		return nil, err
+	}
+	deliveries := make([]models.WebhookDelivery, 0)
+	opt := options.Find().SetSort(bson.D{{"meta.created", -1}})
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("webhook-deliveries-collection"), bson.D{{"subscriptionId", subscriptionId}}, opt, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook sends a logged delivery again, with the same payload and
+// a fresh signature.
+func RedeliverWebhook(ctx context.Context, subscriptionId, deliveryId string, config *MapPropertySource) (*models.WebhookDelivery, error) {
+	subscription, err := getWebhookSubscription(ctx, subscriptionId, config)
+	if err != nil {
+		return nil, err
+	}
+	if !subscription.Active {
+		return nil, Error.InvalidParam("subscription", "an active subscription", "a disabled one")
+	}
+
+	collection := config.GetString("webhook-deliveries-collection")
+	filter := bson.D{{"id", deliveryId}, {"subscriptionId", subscriptionId}}
+	query := bson.D{{"$set", bson.D{{"status", models.DeliveryPending}, {"meta.lastModified", time.Now()}}}}
+	var delivery models.WebhookDelivery
+	err = datastore.GetDatastore().FindOneAndUpdate(ctx, collection, filter, query, bson.D{}, &delivery)
+	if datastore.IsNotFound(err) {
+		return nil, Error.ResourceNotFound(deliveryId, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = jobs.Enqueue(ctx, webhookDeliveryJob, webhookDeliveryPayload{delivery.Id}, config)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+var webhookDeliveryIndex sync.Once
+
+// ensureWebhookDeliveryIndex makes mongo refuse a second delivery of an event
+// to a subscription, which the check in publishEvent alone can not do for
+// relays publishing the event at the same time.
+func ensureWebhookDeliveryIndex(ctx context.Context, config *MapPropertySource) {
+	webhookDeliveryIndex.Do(func() {
+		collection := config.GetString("webhook-deliveries-collection")
+		keys := bson.D{{"eventId", 1}, {"subscriptionId", 1}}
+		err := datastore.GetDatastore().EnsureUnique(ctx, collection, keys, nil)
+		if err != nil {
+			Warn(ctx, fmt.Sprintf("Unable to create the delivery index of %s: %s", collection, err.Error()))
+		}
+	})
+}
+
+// publishEvent queues a delivery of an event the outbox relays to every
+// active subscription listening to it. The relay publishes an event again
+// when this fails, subscriptions that already have a delivery of the event
+// are left out.
+func publishEvent(ctx context.Context, e models.Event) error {
+	config := GetConfigs()
+	subscriptions := make([]models.WebhookSubscription, 0)
+	filter := bson.D{{"active", true}, {"events", e.Type}}
+	err := datastore.GetDatastore().GetAll(ctx, config.GetString("webhooks-collection"), filter, nil, &subscriptions)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	event := models.WebhookEvent{Id: e.Id, Type: e.Type, Occurred: e.Occurred, Data: e.Data}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	collection := config.GetString("webhook-deliveries-collection")
+	ensureWebhookDeliveryIndex(ctx, config)
+	for _, subscription := range subscriptions {
+		var existing models.WebhookDelivery
+		err = datastore.GetDatastore().GetById(ctx, collection, bson.D{{"eventId", event.Id}, {"subscriptionId", subscription.Id}}, &existing)
+		if err == nil {
+			continue
+		}
+		if !datastore.IsNotFound(err) {
+			return err
+		}
+
+		delivery := &models.WebhookDelivery{
+			Id:             uuid.NewV4().String(),
+			SubscriptionId: subscription.Id,
+			EventId:        event.Id,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         models.DeliveryPending,
+			Attempts:       []models.DeliveryAttempt{},
+			Meta:           models.Meta{ResourceType: "WebhookDelivery", Created: now, LastModified: now},
+		}
+		err = datastore.GetDatastore().WithTransaction(ctx, func(ctx context.Context) error {
+			err := datastore.GetDatastore().Save(ctx, collection, delivery)
+			if err != nil {
+				return err
+			}
+			_, err = jobs.Enqueue(ctx, webhookDeliveryJob, webhookDeliveryPayload{delivery.Id}, config)
+			return err
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			// a relay publishing the event at the same time queued it
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignWebhook is the X-Webhook-Signature of a payload: the hex HMAC-SHA256,
+// keyed with the subscription secret, of the X-Webhook-Timestamp, a dot and
+// the body.
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook is the job sending one delivery. A failed attempt is retried
+// by the job queue with a growing delay, the delivery fails with the last
+// attempt. Subscriptions failing webhook-disable-after attempts in a row are
+// disabled.
+func deliverWebhook(ctx context.Context, job *models.Job, progress jobs.Progress) error {
+	config := GetConfigs()
+	var p webhookDeliveryPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+
+	collection := config.GetString("webhook-deliveries-collection")
+	var delivery models.WebhookDelivery
+	err := datastore.GetDatastore().GetById(ctx, collection, bson.D{{"id", p.DeliveryId}}, &delivery)
+	if err != nil {
+		return err
+	}
+	subscription, err := getWebhookSubscription(ctx, delivery.SubscriptionId, config)
+	if _, ok := err.(*ResourceNotFoundError); ok {
+		return finishDelivery(ctx, &delivery, models.DeliveryFailed, nil, config)
+	}
+	if err != nil {
+		return err
+	}
+	if !subscription.Active {
+		Info(ctx, fmt.Sprintf("Webhook(%s) is disabled, Delivery(%s) dropped", subscription.Id, delivery.Id))
+		return finishDelivery(ctx, &delivery, models.DeliveryFailed, nil, config)
+	}
+
+	attempt, sendErr := sendWebhook(ctx, subscription, &delivery, config)
+	status := models.DeliveryPending
+	switch {
+	case sendErr == nil:
+		status = models.DeliverySucceeded
+	case job.Attempts >= job.MaxAttempts:
+		status = models.DeliveryFailed
+	}
+	if err := finishDelivery(ctx, &delivery, status, attempt, config); err != nil {
+		return err
+	}
+	if err := recordWebhookOutcome(ctx, subscription, sendErr, config); err != nil {
+		Warn(ctx, fmt.Sprintf("Unable to record the outcome of Webhook(%s): %s", subscription.Id, err.Error()))
+	}
+	return sendErr
+}
+
+func sendWebhook(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery, config *MapPropertySource) (*models.DeliveryAttempt, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{
+		"X-Webhook-Id":        delivery.Id,
+		"X-Webhook-Event":     delivery.EventType,
+		"X-Webhook-Timestamp": timestamp,
+		"X-Webhook-Signature": SignWebhook(subscription.Secret, timestamp, delivery.Payload),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.GetInt("webhook-timeout"))*time.Second)
+	defer cancel()
+
+	attempt := &models.DeliveryAttempt{At: time.Now()}
+	resp, err := HttpPostWith(ctx, webhookClient, subscription.Url, MediaTypeJSON, bytes.NewReader(delivery.Payload), headers)
+	attempt.Duration = time.Now().Sub(attempt.At).String()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = Error.Text("Webhook(%s) answered %d", subscription.Id, resp.StatusCode)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	Info(ctx, fmt.Sprintf("Delivery(%s) of %s to Webhook(%s) answered %d", delivery.Id, delivery.EventType, subscription.Id, resp.StatusCode))
+	return attempt, nil
+}
+
+func finishDelivery(ctx context.Context, delivery *models.WebhookDelivery, status string, attempt *models.DeliveryAttempt, config *MapPropertySource) error {
+	query := bson.D{{"$set", bson.D{{"status", status}, {"meta.lastModified", time.Now()}}}}
+	if attempt != nil {
+		query = append(query, bson.E{"$push", bson.D{{"attempts", attempt}}})
+	}
+	return datastore.GetDatastore().Update(ctx, config.GetString("webhook-deliveries-collection"), bson.D{{"id", delivery.Id}}, query)
+}
+
+func recordWebhookOutcome(ctx context.Context, subscription *models.WebhookSubscription, sendErr error, config *MapPropertySource) error {
+	collection := config.GetString("webhooks-collection")
+	if sendErr == nil {
+		if subscription.ConsecutiveFailures == 0 {
+			return nil
+		}
+		return datastore.GetDatastore().Update(ctx, collection, bson.D{{"id", subscription.Id}},
+			bson.D{{"$set", bson.D{{"consecutiveFailures", 0}}}})
+	}
+
+	var updated models.WebhookSubscription
+	err := datastore.GetDatastore().FindOneAndUpdate(ctx, collection, bson.D{{"id", subscription.Id}},
+		bson.D{{"$inc", bson.D{{"consecutiveFailures", 1}}}}, bson.D{}, &updated)
+	if err != nil {
+		return err
+	}
+	limit := config.GetInt("webhook-disable-after")
+	if !updated.Active || updated.ConsecutiveFailures < limit {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%d delivery attempts failed in a row, the last with: %s", updated.ConsecutiveFailures, sendErr.Error())
+	Warn(ctx, fmt.Sprintf("Webhook(%s) disabled, %s", subscription.Id, reason))
+	return datastore.GetDatastore().Update(ctx, collection, bson.D{{"id", subscription.Id}}, bson.D{{"$set", bson.D{
+		{"active", false},
+		{"disabledReason", reason},
+		{"disabledAt", time.Now()},
+		{"meta.lastModified", time.Now()},
+	}}})
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"awesomeTestProject/datastore"
+	"awesomeTestProject/models"
+	. "awesomeTestProject/shared"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// receiver is a webhook endpoint answering with the queued statuses in turn,
+// 200 once they run out, and keeping the requests it got.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func webhookTest(t *testing.T) (*memoryStore, *MapPropertySource) {
+	InitConfigs()
+	config := GetConfigs()
+	// the receivers listen on the loopback interface
+	config.Data["webhook-allow-private"] = true
+	config.Data["job-max-attempts"] = 3
+	store := useMemoryStore(t)
+	datastore.Db = &datastore.MongoDatabase{Jobs: "jobs"}
+	webhookDeliveryIndex = sync.Once{}
+	return store, config
+}
+
+func subscribe(t *testing.T, url string, config *MapPropertySource) *models.WebhookSubscription {
+	subscription, err := CreateWebhookSubscription(context.Background(), &models.WebhookSubscriptionPayload{
+		Url:    url,
+		Events: []string{models.EventStudentCreated},
+		Secret: "whsec_test",
+	}, config)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return subscription
+}
+
+func studentCreated() models.Event {
+	return models.Event{
+		Id:          uuid.NewV4().String(),
+		Type:        models.EventStudentCreated,
+		AggregateId: "s1",
+		Occurred:    time.Now(),
+		Data:        []byte(`{"id":"s1","name":"Ada"}`),
+	}
+}
+
+// deliveryJobs are the queued jobs delivering webhooks.
+func deliveryJobs(store *memoryStore) []models.Job {
+	var queued []models.Job
+	store.All("jobs", &queued)
+	deliveries := make([]models.Job, 0, len(queued))
+	for _, job := range queued {
+		if job.Type == webhookDeliveryJob {
+			deliveries = append(deliveries, job)
+		}
+	}
+	return deliveries
+}
+
+// attempt runs a delivery job the way a worker does for its n-th attempt.
+func attempt(job models.Job, n int) error {
+	job.Attempts = n
+	return deliverWebhook(context.Background(), &job, func(completed, total int) error { return nil })
+}
+
+func deliveries(store *memoryStore, config *MapPropertySource) []models.WebhookDelivery {
+	var logged []models.WebhookDelivery
+	store.All(config.GetString("webhook-deliveries-collection"), &logged)
+	return logged
+}
+
+func TestSignWebhook(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte(`1700000000.{"id":"e1"}`))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignWebhook("whsec_test", "1700000000", []byte(`{"id":"e1"}`)); got != want {
+		t.Fatalf("signature = %s, want %s", got, want)
+	}
+	if SignWebhook("whsec_other", "1700000000", []byte(`{"id":"e1"}`)) == want {
+		t.Fatal("signature does not depend on the secret")
+	}
+	if SignWebhook("whsec_test", "1700000001", []byte(`{"id":"e1"}`)) == want {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	store, config := webhookTest(t)
+	endpoint := newReceiver(t)
+	subscribe(t, endpoint.URL, config)
+
+	if err := publishEvent(context.Background(), studentCreated()); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	queued := deliveryJobs(store)
+	if len(queued) != 1 {
+		t.Fatalf("%d delivery jobs queued, want 1", len(queued))
+	}
+	if err := attempt(queued[0], 1); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if endpoint.received() != 1 {
+		t.Fatalf("endpoint got %d requests, want 1", endpoint.received())
+	}
+	req, body := endpoint.requests[0], endpoint.bodies[0]
+	want := SignWebhook("whsec_test", req.Header.Get("X-Webhook-Timestamp"), body)
+	if got := req.Header.Get("X-Webhook-Signature"); got != want {
+		t.Fatalf("X-Webhook-Signature = %s, want %s", got, want)
+	}
+	if req.Header.Get("X-Webhook-Event") != models.EventStudentCreated || !strings.Contains(string(body), `"name":"Ada"`) {
+		t.Fatalf("endpoint got %s %s, want the student.created event", req.Header.Get("X-Webhook-Event"), body)
+	}
+	if logged := deliveries(store, config); logged[0].Status != models.DeliverySucceeded || len(logged[0].Attempts) != 1 {
+		t.Fatalf("delivery = %+v, want succeeded after one attempt", logged[0])
+	}
+}
+
+func TestWebhookDeliveryIsRetried(t *testing.T) {
+	store, config := webhookTest(t)
+	endpoint := newReceiver(t, http.StatusServiceUnavailable)
+	subscription := subscribe(t, endpoint.URL, config)
+	if err := publishEvent(context.Background(), studentCreated()); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	job := deliveryJobs(store)[0]
+
+	if err := attempt(job, 1); err == nil {
+		t.Fatal("failed attempt reported success, the job queue would not retry it")
+	}
+	logged := deliveries(store, config)[0]
+	if logged.Status != models.DeliveryPending || len(logged.Attempts) != 1 || logged.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("delivery = %+v, want pending after a failed attempt", logged)
+	}
+
+	if err := attempt(job, 2); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	logged = deliveries(store, config)[0]
+	if logged.Status != models.DeliverySucceeded || len(logged.Attempts) != 2 {
+		t.Fatalf("delivery = %+v, want succeeded on the retry", logged)
+	}
+	current, _ := getWebhookSubscription(context.Background(), subscription.Id, config)
+	if current.ConsecutiveFailures != 0 {
+		t.Fatalf("consecutiveFailures = %d after a success, want 0", current.ConsecutiveFailures)
+	}
+}
+
+func TestWebhookDeliveryFailsWithTheLastAttempt(t *testing.T) {
+	store, config := webhookTest(t)
+	endpoint := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	subscribe(t, endpoint.URL, config)
+	if err := publishEvent(context.Background(), studentCreated()); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	job := deliveryJobs(store)[0]
+
+	for n := 1; n <= 3; n++ {
+		_ = attempt(job, n)
+	}
+	logged := deliveries(store, config)[0]
+	if logged.Status != models.DeliveryFailed || len(logged.Attempts) != 3 {
+		t.Fatalf("delivery = %+v, want failed after 3 attempts", logged)
+	}
+}
+
+func TestFailingWebhookIsDisabled(t *testing.T) {
+	store, config := webhookTest(t)
+	config.Data["webhook-disable-after"] = 2
+	endpoint := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	subscription := subscribe(t, endpoint.URL, config)
+
+	for i := 0; i < 3; i++ {
+		if err := publishEvent(context.Background(), studentCreated()); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	queued := deliveryJobs(store)
+	if len(queued) != 3 {
+		t.Fatalf("%d delivery jobs queued, want 3", len(queued))
+	}
+	_ = attempt(queued[0], 1)
+	_ = attempt(queued[1], 1)
+
+	current, _ := getWebhookSubscription(context.Background(), subscription.Id, config)
+	if current.Active || current.ConsecutiveFailures != 2 || !strings.Contains(current.DisabledReason, "2 delivery attempts failed") {
+		t.Fatalf("subscription = %+v, want disabled after 2 failures", current)
+	}
+
+	if err := attempt(queued[2], 1); err != nil {
+		t.Fatalf("delivery to a disabled webhook: %v", err)
+	}
+	if endpoint.received() != 2 {
+		t.Fatalf("endpoint got %d requests, want none once disabled", endpoint.received()-2)
+	}
+	if logged := deliveries(store, config)[2]; logged.Status != models.DeliveryFailed {
+		t.Fatalf("delivery = %+v, want dropped as failed", logged)
+	}
+}
+
+func TestEventIsDeliveredOncePerSubscription(t *testing.T) {
+	store, config := webhookTest(t)
+	endpoint := newReceiver(t)
+	subscribe(t, endpoint.URL, config)
+	event := studentCreated()
+
+	if err := publishEvent(context.Background(), event); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	// the relay publishes the event again, and another relay checked for a
+	// delivery before the first was saved
+	if err := publishEvent(context.Background(), event); err != nil {
+		t.Fatalf("publish again: %v", err)
+	}
+	collection := config.GetString("webhook-deliveries-collection")
+	store.Fail("GetById", collection, &datastore.NotFoundError{Collection: collection})
+	if err := publishEvent(context.Background(), event); err != nil {
+		t.Fatalf("concurrent publish: %v", err)
+	}
+
+	if n := len(deliveries(store, config)); n != 1 {
+		t.Fatalf("%d deliveries of one event, want 1", n)
+	}
+	if n := len(deliveryJobs(store)); n != 1 {
+		t.Fatalf("%d delivery jobs of one event, want 1", n)
+	}
+}
+
+func TestWebhookUrlMustBePublic(t *testing.T) {
+	_, config := webhookTest(t)
+	config.Data["webhook-allow-private"] = false
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.7/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]/hook",
+	} {
+		_, err := CreateWebhookSubscription(context.Background(), &models.WebhookSubscriptionPayload{Url: url, Events: []string{models.EventStudentCreated}}, config)
+		var invalid *InvalidParamError
+		if !errors.As(err, &invalid) || invalid.Name != "url" {
+			t.Errorf("subscribing %s: err = %v, want InvalidParamError for url", url, err)
+		}
+	}
+
+	config.Data["webhook-allow-private"] = true
+	if _, err := CreateWebhookSubscription(context.Background(), &models.WebhookSubscriptionPayload{Url: "http://10.0.0.7/hook", Events: []string{models.EventStudentCreated}}, config); err != nil {
+		t.Fatalf("subscribing a private address when allowed: %v", err)
+	}
+}
+
+func TestWebhookIsNotSentToAPrivateAddress(t *testing.T) {
+	store, config := webhookTest(t)
+	endpoint := newReceiver(t)
+	// stored while allowed, or through a name resolving to the loopback
+	subscribe(t, endpoint.URL, config)
+	if err := publishEvent(context.Background(), studentCreated()); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	config.Data["webhook-allow-private"] = false
+
+	err := attempt(deliveryJobs(store)[0], 1)
+	if err == nil || !strings.Contains(err.Error(), "webhooks may not connect to 127.0.0.1") {
+		t.Fatalf("err = %v, want the connection refused", err)
+	}
+	if endpoint.received() != 0 {
+		t.Fatal("endpoint on a private address was reached")
+	}
+}
@@ -0,0 +1,187 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeSCIMJSON = "application/scim+json"
+	MediaTypeXML      = "application/xml"
+	MediaTypeMsgpack  = "application/msgpack"
+)
+
+// Codec serializes response entities and request bodies for one media type.
+type Codec interface {
+	MediaType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    []Codec
+)
+
+// RegisterCodec makes a media type available for negotiation. The first
+// registered codec is used when the client accepts anything.
+func RegisterCodec(codec Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs = append(codecs, codec)
+}
+
+func init() {
+	RegisterCodec(jsonCodec{MediaTypeJSON})
+	RegisterCodec(jsonCodec{MediaTypeSCIMJSON})
+	RegisterCodec(xmlCodec{})
+	RegisterCodec(msgpackCodec{})
+}
+
+func SupportedMediaTypes() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	supported := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		supported = append(supported, c.MediaType())
+	}
+	return supported
+}
+
+func codecByMediaType(mediaType string) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, c := range codecs {
+		if c.MediaType() == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+func defaultCodec() Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[0]
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// NegotiateCodec picks the codec for an Accept header, honouring q values.
+// A missing header, */* and application/* get the default codec. When
+// nothing matches a NotAcceptableError is returned.
+func NegotiateCodec(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return defaultCodec(), nil
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.mediaType == "*/*" || r.mediaType == "application/*" {
+			return defaultCodec(), nil
+		}
+		if c := codecByMediaType(r.mediaType); c != nil {
+			return c, nil
+		}
+	}
+	return nil, Error.NotAcceptable(accept, SupportedMediaTypes())
+}
+
+// CodecFor picks the codec for a request Content-Type. Requests without one
+// are read as JSON, unknown types give an UnsupportedMediaTypeError.
+func CodecFor(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return defaultCodec(), nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if c := codecByMediaType(mediaType); c != nil {
+			return c, nil
+		}
+	}
+	return nil, Error.UnsupportedMediaType(contentType, SupportedMediaTypes())
+}
+
+type jsonCodec struct{ mediaType string }
+
+func (c jsonCodec) MediaType() string                          { return c.mediaType }
+func (c jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (c jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec uses the json struct tags so field names are the same in every
+// representation.
+type msgpackCodec struct{}
+
+func init() {
+	msgpack.Register(json.RawMessage{},
+		func(enc *msgpack.Encoder, v reflect.Value) error {
+			var value interface{}
+			if v.Len() == 0 {
+				return enc.EncodeNil()
+			}
+			if err := json.Unmarshal(v.Bytes(), &value); err != nil {
+				return err
+			}
+			return enc.Encode(value)
+		},
+		func(dec *msgpack.Decoder, v reflect.Value) error {
+			value, err := dec.DecodeInterface()
+			if err != nil {
+				return err
+			}
+			b, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		})
+}
+
+func (msgpackCodec) MediaType() string { return MediaTypeMsgpack }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -14,12 +14,87 @@ func InitConfigs() {
 			"database-password" : "database",
 			"database-name" : "database",
 			"listening-port": "5000",
+			"grpc-port": "9000",
 			"sql-username":   "root",
 			"sql-password":   "",
 			"sql-url":        "tcp(127.0.0.1:3306)",
 			"sql-name":       "test",
 			"students-collection": "students",
+			"put-upsert": false,
+			"student-versions-collection": "studentVersions",
+			"import-jobs-collection": "importJobs",
+			"import-uploads-collection": "importUploads",
+			"import-errors-collection": "importErrors",
+			"student-import-mapping": "name=name,enrollmentNumber=enrollmentNumber",
+			"student-import-max-size": 10485760,
+			"student-import-async-rows": 1000,
+			"student-import-batch-size": 500,
+			"courses-collection": "courses",
+			"enrollments-collection": "enrollments",
+			"student-delete-policy": "block",
+			"default-grading-scale": "letter",
+			"grading-scales": "",
+			"attendance-collection": "attendance",
+			"attendance-alert-threshold": 0.8,
+			"ledger-collection": "ledger",
+			"ledger-currency": "USD",
+			"payment-gateway": "",
+			"student-transitions-collection": "studentTransitions",
+			"student-lifecycle-initial": "applicant",
+			"bulk-max-operations": 1000,
+			"bulk-max-payload-size": 1048576,
+			"bulk-transactional": false,
+			"graphql-max-depth": 8,
+			"graphql-max-complexity": 1000,
+			"graphql-page-size": 50,
+			"idempotency-collection": "idempotencyKeys",
+			"idempotency-ttl": 86400,
+			"idempotency-wait-timeout": 10,
+			"idempotency-lock-timeout": 60,
+			"jobs-collection": "jobs",
+			"job-workers": 4,
+			"job-lease": 30,
+			"job-poll-interval": 1,
+			"job-max-attempts": 5,
+			"job-backoff": 2,
+			"job-backoff-max": 300,
+			"job-retention": 604800,
+			"schedules": "",
+			"schedules-collection": "schedules",
+			"schedule-missed-policy": "skip",
+			"schedule-catchup-max": 10,
+			"schedule-lease": 300,
+			"schedule-poll-interval": 5,
+			"webhooks-collection": "webhooks",
+			"webhook-deliveries-collection": "webhookDeliveries",
+			"webhook-timeout": 10,
+			"webhook-disable-after": 15,
+			"webhook-allow-private": false,
+			"outbox-collection": "outbox",
+			"outbox-sinks": "bus",
+			"outbox-file-path": "events.ndjson",
+			"outbox-http-url": "",
+			"outbox-http-timeout": 10,
+			"outbox-batch-size": 100,
+			"outbox-poll-interval": 1,
+			"outbox-lease": 30,
+			"outbox-retention": 604800,
+			"outbox-counters-collection": "outboxCounters",
+			"leases-collection": "leases",
+			"student-events-source": "auto",
+			"student-events-buffer": 256,
+			"student-events-heartbeat": 15,
+			"request-timeout": 30,
+			"import-request-timeout": 300,
+			"export-request-timeout": 3600,
+			"bulk-request-timeout": 300,
+			"shutdown-grace": 30,
+			"validate-requests": false,
+			"validate-responses": false,
+			"validate-max-body-size": 1048576,
+			"student-lifecycle": "applicant>admitted|withdrawn;admitted>enrolled|withdrawn;enrolled>suspended|graduated|withdrawn;suspended>enrolled|withdrawn",
 			"disable-auth":   false,
+			"auth-user-header": "",
 		},
 	}
 }
@@ -1,7 +1,11 @@
 package shared
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 )
 
@@ -11,7 +15,7 @@ var (
 )
 
 var (
-	errorTemplate          = `{"Status": "%d", "detail":"%s"}`
+	logTemplate = "%s: %s"
 )
 
 func init() {
@@ -31,11 +35,22 @@ type ErrorFactory interface {
 	InvalidParamGeneric() error
 	ResourceNotFound(id, version string) error
 	Duplicate(path string, value interface{}) error
+	ReferenceViolation(resource, id, detail string) error
+	InvalidTransition(from, to string, allowed []string, reason string) error
+	PayloadTooLarge(name string, max int) error
+	VersionConflict(id, version string) error
+	NotAcceptable(accept string, supported []string) error
+	UnsupportedMediaType(contentType string, supported []string) error
 	UnauthorisedRequest() error
 	ForbiddenRequest() error
 	DomainUnverified() error
 	RequestCancelled() error
+	DeadlineExceeded() error
+	PaymentInvalid(reason string) error
+	IdempotencyKeyReused(key string) error
+	IdempotencyKeyInProgress(key string) error
 	Datastore(reason error) error
+	Validation(errs []error) error
 	Text(template string, args ...interface{}) error
 }
 
@@ -196,6 +211,96 @@ func (e DuplicateError) Error() string {
 	return fmt.Sprintf("Resource has duplicate value '%v' at path '%s'", e.Value, e.Path)
 }
 
+func (f *errorFactory) ReferenceViolation(resource, id, detail string) error {
+	return &ReferenceViolationError{resource, id, detail}
+}
+
+// Reference Violation Error
+type ReferenceViolationError struct {
+	Resource string
+	Id       string
+	Detail   string
+}
+
+func (e ReferenceViolationError) Error() string {
+	return fmt.Sprintf("Resource %s '%s' is still referenced: %s", e.Resource, e.Id, e.Detail)
+}
+
+func (f *errorFactory) InvalidTransition(from, to string, allowed []string, reason string) error {
+	return &InvalidTransitionError{from, to, allowed, reason}
+}
+
+// Invalid Transition Error
+type InvalidTransitionError struct {
+	From    string
+	To      string
+	Allowed []string
+	Reason  string
+}
+
+func (e InvalidTransitionError) Error() string {
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("Transition from '%s' to '%s' was refused: %s", e.From, e.To, e.Reason)
+	}
+	return fmt.Sprintf("Transition from '%s' to '%s' is not allowed", e.From, e.To)
+}
+
+func (f *errorFactory) PayloadTooLarge(name string, max int) error {
+	return &PayloadTooLargeError{name, max}
+}
+
+// Payload Too Large Error
+type PayloadTooLargeError struct {
+	Name string
+	Max  int
+}
+
+func (e PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("Request exceeds the maximum %s of %d", e.Name, e.Max)
+}
+
+func (f *errorFactory) VersionConflict(id, version string) error {
+	return &VersionConflictError{id, version}
+}
+
+// Version Conflict Error
+type VersionConflictError struct {
+	Id      string
+	Version string
+}
+
+func (e VersionConflictError) Error() string {
+	return fmt.Sprintf("Resource '%s' was modified concurrently, version '%s' is no longer current", e.Id, e.Version)
+}
+
+func (f *errorFactory) NotAcceptable(accept string, supported []string) error {
+	return &NotAcceptableError{accept, supported}
+}
+
+// Not Acceptable Error
+type NotAcceptableError struct {
+	Accept    string
+	Supported []string
+}
+
+func (e NotAcceptableError) Error() string {
+	return fmt.Sprintf("None of '%s' can be produced, supported types are %s", e.Accept, strings.Join(e.Supported, ", "))
+}
+
+func (f *errorFactory) UnsupportedMediaType(contentType string, supported []string) error {
+	return &UnsupportedMediaTypeError{contentType, supported}
+}
+
+// Unsupported Media Type Error
+type UnsupportedMediaTypeError struct {
+	ContentType string
+	Supported   []string
+}
+
+func (e UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("Request body of type '%s' can not be read, supported types are %s", e.ContentType, strings.Join(e.Supported, ", "))
+}
+
 // Unauthorised Error
 type UnauthorisedError struct {
 }
@@ -241,17 +346,70 @@ func (f *errorFactory) RequestCancelled() error {
 	return &RequestCancelled{}
 }
 
+// Deadline Exceeded Error
+type DeadlineExceededError struct {
+}
+
+func (e *DeadlineExceededError) Error() string {
+	return "Request did not complete before its deadline"
+}
+
+func (f *errorFactory) DeadlineExceeded() error {
+	return &DeadlineExceededError{}
+}
+
+// ContextError is err as the RequestCancelled or DeadlineExceededError it
+// stands for when it was caused by the end of ctx. An unexpected error while
+// ctx is done is taken as caused by it, the typed errors are kept.
+func ContextError(ctx context.Context, err error) error {
+	unexpected := ErrorStatus(err) == http.StatusInternalServerError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), unexpected && ctx.Err() == context.DeadlineExceeded:
+		return Error.DeadlineExceeded()
+	case errors.Is(err, context.Canceled), unexpected && ctx.Err() == context.Canceled:
+		return Error.RequestCancelled()
+	}
+	return err
+}
+
 type PaymentInvalidError struct {
 	Reason string
 }
 
 func (e *PaymentInvalidError) Error() string {
-	return fmt.Sprintf("No attribute defined for path (segment) '%s'", e.Reason)
+	return fmt.Sprintf("Payment is invalid: %s", e.Reason)
 }
+
 func (f *errorFactory) PaymentInvalid(reason string) error {
 	return &PaymentInvalidError{reason}
 }
 
+// Idempotency Key Reused Error
+type IdempotencyKeyReusedError struct {
+	Key string
+}
+
+func (e *IdempotencyKeyReusedError) Error() string {
+	return fmt.Sprintf("Idempotency-Key '%s' was already used for a different request", e.Key)
+}
+
+func (f *errorFactory) IdempotencyKeyReused(key string) error {
+	return &IdempotencyKeyReusedError{key}
+}
+
+// Idempotency Key In Progress Error
+type IdempotencyKeyInProgressError struct {
+	Key string
+}
+
+func (e *IdempotencyKeyInProgressError) Error() string {
+	return fmt.Sprintf("A request with Idempotency-Key '%s' is still being processed", e.Key)
+}
+
+func (f *errorFactory) IdempotencyKeyInProgress(key string) error {
+	return &IdempotencyKeyInProgressError{key}
+}
+
 type DatastoreError struct {
 	Reason error
 }
@@ -263,3 +421,28 @@ func (e *DatastoreError) Error() string {
 func (f *errorFactory) Datastore(reason error) error {
 	return &DatastoreError{reason}
 }
+
+// Validation Error collects the violations found checking a request.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	details := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		details = append(details, err.Error())
+	}
+	return fmt.Sprintf("Request has %d violations: %s", len(e.Errors), strings.Join(details, "; "))
+}
+
+// Validation is the single violation itself, or a ValidationError listing
+// them all.
+func (f *errorFactory) Validation(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &ValidationError{errs}
+}
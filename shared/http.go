@@ -54,22 +54,33 @@ func GetByFilter(ctx context.Context, getUrl, filter string) (*http.Response, er
 	return resp, err
 }
 
-func HttpPost(ctx context.Context, postUrl string, contentType string, body io.Reader) (*http.Response, error) {
+// HttpPost posts the body, the headers are set on the request after the
+// content type. The request is cancelled with ctx.
+func HttpPost(ctx context.Context, postUrl string, contentType string, body io.Reader, headers ...map[string]string) (*http.Response, error) {
+	return HttpPostWith(ctx, http.DefaultClient, postUrl, contentType, body, headers...)
+}
+
+// HttpPostWith is HttpPost through the given client.
+func HttpPostWith(ctx context.Context, client *http.Client, postUrl string, contentType string, body io.Reader, headers ...map[string]string) (*http.Response, error) {
 	t0 := time.Now()
 
-	req, err := http.NewRequest("POST", postUrl, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", postUrl, body)
 	if err != nil {
 		return nil, err
 	}
 
 	if v := ctx.Value(RequestId{}); v != nil {
-		req.WithContext(ctx)
 		req.Header.Add("X-Correlation-Id", v.(string))
 	}
 
 	req.Header.Set("Content-Type", contentType)
+	for _, h := range headers {
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	Debug(ctx, "POST Time taken for ", postUrl, " is ", time.Now().Sub(t0), " seconds")
 
 	return resp, err
@@ -8,7 +8,13 @@ import (
 )
 
 func InjectServiceScope(t int64, next RoutineHandler) {
-	ctx := context.Background()
+	InjectServiceScopeWith(context.Background(), t, next)
+}
+
+// InjectServiceScopeWith is InjectServiceScope for routines that have to stop
+// with parent, like the jobs of a worker shutting down.
+func InjectServiceScopeWith(parent context.Context, t int64, next RoutineHandler) {
+	ctx := parent
 
 	uid := uuid.NewV4()
 	ctx = context.WithValue(ctx, RequestId{}, uid.String())
@@ -24,81 +30,12 @@ func ErrorHandler(t int64, ctx context.Context, next RoutineHandler) {
 	next(t, ctx)
 }
 
+// GenericErrorHandler logs the error a routine panicked with, at the level
+// registered for it.
 func GenericErrorHandler(ctx context.Context) {
 	if r := recover(); r != nil {
-		switch r.(type) {
-		case *InvalidPathError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"InvalidPathError",
-				r.(error).Error()))
-
-		case *InvalidParamGenericError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"InvalidParamGenericError",
-				r.(error).Error()))
-
-		case *InvalidParamError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"InvalidParamError",
-				r.(error).Error()))
-
-		case *InvalidTypeError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"InvalidTypeError",
-				r.(error).Error()))
-
-		case *NoAttributeError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"NoAttributeError",
-				r.(error).Error()))
-
-		case *MissingRequiredPropertyError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"MissingRequiredPropertyError",
-				r.(error).Error()))
-
-		case *DuplicateError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"DuplicateError",
-				r.(error).Error()))
-
-		case *UnauthorisedError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"UnauthorisedError",
-				r.(error).Error()))
-
-		case *ForbiddenError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"ForbiddenError",
-				r.(error).Error()))
-
-		case *UnverifiedDomain:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"UnverifiedDomain",
-				r.(error).Error()))
-
-		case *PaymentInvalidError:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"PaymentInvalidError",
-				r.(error).Error()))
-
-		default:
-			Fatal(ctx, fmt.Sprintf(
-				errorTemplate,
-				"Internal Error Unkown",
-				r.(error).Error()))
-		}
+		err := ContextError(ctx, panicError(r))
+		m := ErrorMappingOf(err)
+		m.Log(ctx, fmt.Sprintf(logTemplate, m.Code, err.Error()))
 	}
-
 }
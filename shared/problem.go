@@ -0,0 +1,214 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+const MediaTypeProblemJSON = "application/problem+json"
+
+// Problem is the RFC 7807 body of error responses. Code is stable for an
+// error type, clients should branch on it rather than on the detail, which is
+// meant for people.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code"`
+	CorrelationId string         `json:"correlationId,omitempty"`
+	Errors        []ProblemField `json:"errors,omitempty"`
+	// Allowed lists the statuses the student may move to, only for refused
+	// transitions.
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// ProblemField is one of the violations of a validation problem.
+type ProblemField struct {
+	Code   string `json:"code"`
+	Field  string `json:"field,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// ErrorMapping is how the errors of one type are answered and logged when
+// they are recovered: with Status and the stable Code and Title of the
+// problem, logged with Log, one of Debug, Info, Warn or Fatal.
+type ErrorMapping struct {
+	Status int
+	Code   string
+	Title  string
+	Log    func(ctx context.Context, args ...interface{}) context.Context
+}
+
+type registeredError struct {
+	target  reflect.Type
+	mapping ErrorMapping
+}
+
+var (
+	errorLock       sync.RWMutex
+	errorMappings   []registeredError
+	internalMapping = ErrorMapping{http.StatusInternalServerError, "internal_error", "Internal error", Fatal}
+)
+
+// RegisterError maps the errors of the type of example, also when they are
+// wrapped, so applications can answer their own errors with a fitting
+// status. Registering a type again replaces its mapping. Errors of no
+// registered type are internal errors.
+func RegisterError(example error, mapping ErrorMapping) {
+	if mapping.Log == nil {
+		mapping.Log = Info
+	}
+	target := reflect.TypeOf(example)
+
+	errorLock.Lock()
+	defer errorLock.Unlock()
+	for i, r := range errorMappings {
+		if r.target == target {
+			errorMappings[i].mapping = mapping
+			return
+		}
+	}
+	errorMappings = append(errorMappings, registeredError{target, mapping})
+}
+
+// ErrorMappingOf finds the mapping of the first registered type err is, or
+// wraps, in the order the types were registered.
+func ErrorMappingOf(err error) ErrorMapping {
+	errorLock.RLock()
+	defer errorLock.RUnlock()
+	for _, r := range errorMappings {
+		if errors.As(err, reflect.New(r.target).Interface()) {
+			return r.mapping
+		}
+	}
+	return internalMapping
+}
+
+func init() {
+	RegisterError(&InvalidPathError{}, ErrorMapping{http.StatusBadRequest, "invalid_path", "Invalid path", Info})
+	RegisterError(&InvalidFilterError{}, ErrorMapping{http.StatusBadRequest, "invalid_filter", "Invalid filter", Info})
+	RegisterError(&InvalidParamGenericError{}, ErrorMapping{http.StatusBadRequest, "invalid_param", "Invalid parameter", Info})
+	RegisterError(&InvalidParamError{}, ErrorMapping{http.StatusBadRequest, "invalid_param", "Invalid parameter", Info})
+	RegisterError(&InvalidTypeError{}, ErrorMapping{http.StatusBadRequest, "invalid_type", "Invalid type", Info})
+	RegisterError(&NoAttributeError{}, ErrorMapping{http.StatusBadRequest, "no_attribute", "Unknown attribute", Info})
+	RegisterError(&MissingRequiredPropertyError{}, ErrorMapping{http.StatusBadRequest, "missing_required_property", "Missing required property", Info})
+	RegisterError(&MutabilityViolationError{}, ErrorMapping{http.StatusBadRequest, "mutability_violation", "Attribute is not mutable", Info})
+	RegisterError(&ValidationError{}, ErrorMapping{http.StatusBadRequest, "validation_failed", "Validation failed", Info})
+	RegisterError(&ResourceNotFoundError{}, ErrorMapping{http.StatusNotFound, "not_found", "Resource not found", Info})
+	RegisterError(&DuplicateError{}, ErrorMapping{http.StatusConflict, "duplicate", "Duplicate value", Info})
+	RegisterError(&ReferenceViolationError{}, ErrorMapping{http.StatusConflict, "reference_violation", "Resource is still referenced", Info})
+	RegisterError(&InvalidTransitionError{}, ErrorMapping{http.StatusConflict, "invalid_transition", "Transition not allowed", Info})
+	RegisterError(&VersionConflictError{}, ErrorMapping{http.StatusConflict, "version_conflict", "Version conflict", Info})
+	RegisterError(&IdempotencyKeyInProgressError{}, ErrorMapping{http.StatusConflict, "idempotency_key_in_progress", "Idempotency-Key in use", Info})
+	RegisterError(&IdempotencyKeyReusedError{}, ErrorMapping{http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key reused", Info})
+	RegisterError(&NotAcceptableError{}, ErrorMapping{http.StatusNotAcceptable, "not_acceptable", "Not acceptable", Info})
+	RegisterError(&UnsupportedMediaTypeError{}, ErrorMapping{http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported media type", Info})
+	RegisterError(&PayloadTooLargeError{}, ErrorMapping{http.StatusRequestEntityTooLarge, "payload_too_large", "Payload too large", Info})
+	RegisterError(&PaymentInvalidError{}, ErrorMapping{http.StatusPaymentRequired, "payment_invalid", "Payment invalid", Info})
+	RegisterError(&UnauthorisedError{}, ErrorMapping{http.StatusUnauthorized, "unauthorised", "Unauthorised", Warn})
+	RegisterError(&ForbiddenError{}, ErrorMapping{http.StatusForbidden, "forbidden", "Forbidden", Warn})
+	RegisterError(&UnverifiedDomain{}, ErrorMapping{http.StatusNotModified, "domain_unverified", "Domain unverified", Info})
+	RegisterError(&RequestCancelled{}, ErrorMapping{StatusClientClosedRequest, "request_cancelled", "Request cancelled", Warn})
+	RegisterError(&DeadlineExceededError{}, ErrorMapping{http.StatusGatewayTimeout, "deadline_exceeded", "Deadline exceeded", Warn})
+	RegisterError(&DatastoreError{}, ErrorMapping{http.StatusServiceUnavailable, "datastore_unavailable", "Datastore unavailable", Fatal})
+}
+
+// ErrorStatus is the http status ErrorRecovery answers with for the error, for
+// callers that report errors without panicking.
+func ErrorStatus(err error) int {
+	return ErrorMappingOf(err).Status
+}
+
+// ErrorCode is the stable code of the error in problem responses.
+func ErrorCode(err error) string {
+	return ErrorMappingOf(err).Code
+}
+
+// panicError is the error a recovered panic value stands for.
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return Error.Text("%v", r)
+}
+
+// NewProblem describes err for the request it failed.
+func NewProblem(ctx context.Context, req HttpWebRequest, err error) *Problem {
+	m := ErrorMappingOf(err)
+	p := &Problem{
+		Type:     "/problems/" + m.Code,
+		Title:    m.Title,
+		Status:   m.Status,
+		Detail:   err.Error(),
+		Instance: req.Raw().URL.Path,
+		Code:     m.Code,
+		Errors:   problemFields(err),
+	}
+	if v := ctx.Value(RequestId{}); v != nil {
+		p.CorrelationId = fmt.Sprint(v)
+	}
+	var transition *InvalidTransitionError
+	if errors.As(err, &transition) {
+		p.Allowed = transition.Allowed
+	}
+	return p
+}
+
+// problemFields lists the violations of a validation error, a single one for
+// the errors about one attribute or parameter.
+func problemFields(err error) []ProblemField {
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		fields := make([]ProblemField, 0, len(validation.Errors))
+		for _, violation := range validation.Errors {
+			fields = append(fields, problemFields(violation)...)
+		}
+		return fields
+	}
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		if field, ok := problemField(err); ok {
+			return []ProblemField{{Code: ErrorCode(err), Field: field, Detail: err.Error()}}
+		}
+	}
+	return nil
+}
+
+// problemField is the attribute or parameter an error is about.
+func problemField(err error) (string, bool) {
+	switch e := err.(type) {
+	case *InvalidPathError:
+		return e.Path, true
+	case *InvalidParamError:
+		return e.Name, true
+	case *InvalidTypeError:
+		return e.Path, true
+	case *NoAttributeError:
+		return e.Path, true
+	case *MissingRequiredPropertyError:
+		return e.Path, true
+	case *MutabilityViolationError:
+		return e.Path, true
+	case *DuplicateError:
+		return e.Path, true
+	}
+	return "", false
+}
+
+// ProblemResponse answers with the problem, as application/problem+json.
+func ProblemResponse(problem *Problem) *ResponseOut {
+	b, err := json.Marshal(problem)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"status":%d,"code":%q}`, problem.Status, problem.Code))
+	}
+	ri := NewResponseOut().Status(problem.Status).Header("Content-Type", MediaTypeProblemJSON).Body(b)
+	ri.problem = problem
+	return ri
+}
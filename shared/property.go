@@ -27,6 +27,18 @@ func (mps *MapPropertySource) GetInt(key string) int {
 
 	return mps.Get(key).(int)
 }
+func (mps *MapPropertySource) GetFloat(key string) float64 {
+	if os.Getenv(key) != "" {
+		var value = os.Getenv(key)
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return mps.Get(key).(float64)
+		}
+		return v
+	}
+
+	return mps.Get(key).(float64)
+}
 func (mps *MapPropertySource) GetBool(key string) bool {
 	if os.Getenv(key) != "" {
 		truth := os.Getenv(key)
@@ -2,7 +2,9 @@ package shared
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
@@ -28,6 +30,20 @@ func (hwr HttpWebRequest) Target() string            { return hwr.Req.RequestURI
 func (hwr HttpWebRequest) Method() string            { return hwr.Req.Method }
 func (hwr HttpWebRequest) Header(name string) string { return hwr.Req.Header.Get(name) }
 func (hwr HttpWebRequest) Body() ([]byte, error)     { return ioutil.ReadAll(hwr.Req.Body) }
+// Decode reads the request body into v with the codec matching the
+// Content-Type header.
+func (hwr HttpWebRequest) Decode(v interface{}) error {
+	codec, err := CodecFor(hwr.Header("Content-Type"))
+	if err != nil {
+		return err
+	}
+	b, err := hwr.Body()
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(b, v)
+}
+
 func (hwr HttpWebRequest) Param(name string) string {
 	if v := hwr.Req.URL.Query().Get(name); len(v) > 0 {
 		return v
@@ -43,6 +59,9 @@ type ResponseOut struct {
 	statusCode   int
 	headers      map[string]string
 	responseBody []byte
+	entity       interface{}
+	stream       func(w io.Writer) error
+	problem      *Problem
 }
 
 func NewResponseOut() *ResponseOut {
@@ -61,10 +80,24 @@ func (ri *ResponseOut) GetHeader(name string) string {
 	return ri.headers[name]
 }
 
+func (ri *ResponseOut) GetHeaders() map[string]string {
+	return ri.headers
+}
+
 func (ri *ResponseOut) GetBody() []byte {
 	return ri.responseBody
 }
 
+func (ri *ResponseOut) GetEntity() interface{} {
+	return ri.entity
+}
+
+// GetProblem is the problem an error response describes, nil for other
+// responses.
+func (ri *ResponseOut) GetProblem() *Problem {
+	return ri.problem
+}
+
 func (ri *ResponseOut) Status(statusCode int) *ResponseOut {
 	ri.statusCode = statusCode
 	return ri
@@ -95,129 +128,80 @@ func (ri *ResponseOut) Body(content []byte) *ResponseOut {
 	return ri
 }
 
+// Entity sets the value to respond with. The endpoint serializes it in the
+// media type negotiated from the Accept header.
+func (ri *ResponseOut) Entity(v interface{}) *ResponseOut {
+	ri.entity = v
+	return ri
+}
+
+// Stream makes the endpoint write the response through fn once the status and
+// headers are sent, instead of buffering the whole body in memory. Errors from
+// fn can no longer change the status and are only logged.
+func (ri *ResponseOut) Stream(fn func(w io.Writer) error) *ResponseOut {
+	ri.stream = fn
+	return ri
+}
+
+// Flush sends what a Stream function wrote so far to the client, for long
+// lived responses like event streams that must not wait for the buffer of
+// the server to fill.
+func Flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 /* End point handlers */
 
 func AuthHandler(next EndpointHandler) EndpointHandler {
 	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) (info *ResponseOut) {
-		if Configs.GetBool("disable-auth") {
-			return next(req, ctx, config)
-		}
+		ctx, err := Authenticate(ctx, req.Header)
+		ErrorCheck(err)
 
 		return next(req, ctx, config)
 	}
 }
 
+// Authenticate checks the credentials of a request whatever transport it came
+// in on, header reads the http header or the gRPC metadata. The returned
+// context is the one to continue the request with.
+func Authenticate(ctx context.Context, header func(name string) string) (context.Context, error) {
+	if Configs.GetBool("disable-auth") {
+		return ctx, nil
+	}
+
+	// the user an authenticating proxy in front of the service vouches for,
+	// only trusted when the deployment names the header it sets
+	if name := Configs.GetString("auth-user-header"); name != "" {
+		if user := header(name); user != "" {
+			ctx = context.WithValue(ctx, UserId{}, user)
+		}
+	}
+	return ctx, nil
+}
+
+// AnonymousUser stands for the user of requests nobody authenticated.
+const AnonymousUser = "anonymous"
+
+// AuthenticatedUser is the user Authenticate verified the request is made by,
+// AnonymousUser when it could not. Audit records name this user, never one the
+// client merely claims to be.
+func AuthenticatedUser(ctx context.Context) string {
+	if v, ok := ctx.Value(UserId{}).(string); ok && v != "" {
+		return v
+	}
+	return AnonymousUser
+}
+
 func ErrorRecovery(next EndpointHandler) EndpointHandler {
 	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) (info *ResponseOut) {
 		defer func() {
 			if r := recover(); r != nil {
-				info = NewResponseOut()
-
-				switch r.(type) {
-				case *InvalidPathError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-				case *InvalidParamGenericError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-				case *InvalidParamError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-				case *InvalidTypeError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-				case *NoAttributeError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-
-				case *MissingRequiredPropertyError:
-					info.Status(http.StatusBadRequest)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusBadRequest,
-							r.(error).Error()),
-					))
-
-				case *DuplicateError:
-					info.Status(http.StatusConflict)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusConflict,
-							"uniqueness",
-							r.(error).Error()),
-					))
-
-				case *UnauthorisedError:
-					info.Status(http.StatusUnauthorized)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusUnauthorized,
-							r.(error).Error()),
-					))
-
-				case *ForbiddenError:
-					info.Status(http.StatusForbidden)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusForbidden,
-							r.(error).Error()),
-					))
-
-				case *UnverifiedDomain:
-					info.Status(http.StatusNotModified)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusNotModified,
-							r.(error).Error()),
-					))
-
-				case *DatastoreError:
-					info.Status(http.StatusServiceUnavailable)
-					info.Body([]byte(
-						fmt.Sprintf(
-							errorTemplate,
-							http.StatusServiceUnavailable,
-							r.(error).Error()),
-					))
-
-				default:
-					info.Status(http.StatusInternalServerError)
-					info.Body([]byte(fmt.Sprintf(
-						errorTemplate,
-						http.StatusInternalServerError,
-						r.(error).Error()),
-					))
-				}
+				err := ContextError(ctx, panicError(r))
+				problem := NewProblem(ctx, req, err)
+				ErrorMappingOf(err).Log(ctx, fmt.Sprintf(logTemplate, problem.Code, err.Error()))
+				info = ProblemResponse(problem)
 			}
 
 		}()
@@ -225,20 +209,118 @@ func ErrorRecovery(next EndpointHandler) EndpointHandler {
 	}
 }
 
+// StatusClientClosedRequest is the status of requests whose client went away
+// before the response, it is only seen in the logs.
+const StatusClientClosedRequest = 499
+
+// Deadline gives the request timeout seconds to complete, after which its
+// context is done and it is answered with 504. A streamed response has to be
+// written within the deadline too. A timeout of 0 sets no deadline.
+func Deadline(timeout int, next EndpointHandler) EndpointHandler {
+	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+		if timeout <= 0 {
+			return next(req, ctx, config)
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		resp := next(req, ctx, config)
+		if resp.stream == nil {
+			cancel()
+			return resp
+		}
+		stream := resp.stream
+		resp.stream = func(w io.Writer) error {
+			defer cancel()
+			return stream(w)
+		}
+		return resp
+	}
+}
+
+// Until ends the request once done is done, for streams that otherwise run
+// until the client goes away.
+func Until(done context.Context, next EndpointHandler) EndpointHandler {
+	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut {
+		ctx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-done.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		resp := next(req, ctx, config)
+		if resp.stream == nil {
+			cancel()
+			return resp
+		}
+		stream := resp.stream
+		resp.stream = func(w io.Writer) error {
+			defer cancel()
+			return stream(w)
+		}
+		return resp
+	}
+}
+
 type EndpointHandler func(r HttpWebRequest, ctx context.Context, config *MapPropertySource) *ResponseOut
 
+// Endpoint negotiates the response media type from the Accept header before
+// running next, answering 406 when none of the registered codecs matches,
+// and serializes the entity next responds with.
 func Endpoint(next EndpointHandler, config *MapPropertySource) http.HandlerFunc {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		ctx := context.Background()
+		ctx := req.Context()
+		codec, err := NegotiateCodec(req.Header.Get("Accept"))
+		if err != nil {
+			writeResponse(rw, ProblemResponse(NewProblem(ctx, HttpWebRequest{req}, err)))
+			return
+		}
+
+		resp := next(HttpWebRequest{req}, ctx, config)
+		if resp.entity != nil {
+			b, err := codec.Marshal(resp.entity)
+			if err != nil {
+				log.Printf("Unable to serialize the response as %s %s", codec.MediaType(), err)
+				resp = ProblemResponse(NewProblem(ctx, HttpWebRequest{req}, err))
+			} else {
+				resp.responseBody = b
+				rw.Header().Set("Content-Type", codec.MediaType())
+			}
+		}
+		if len(resp.responseBody) > 0 && rw.Header().Get("Content-Type") == "" {
+			rw.Header().Set("Content-Type", MediaTypeJSON)
+		}
+		writeResponse(rw, resp)
+	})
+}
+
+// StreamEndpoint is the Endpoint for handlers that pick their own media types
+// and write their response as is, like exports.
+func StreamEndpoint(next EndpointHandler, config *MapPropertySource) http.HandlerFunc {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
 		resp := next(HttpWebRequest{req}, ctx, config)
-		for k, v := range resp.headers {
-			rw.Header().Set(k, v)
+		if len(resp.responseBody) > 0 && resp.headers["Content-Type"] == "" {
+			rw.Header().Set("Content-Type", MediaTypeJSON)
 		}
-		rw.WriteHeader(resp.statusCode)
-		_, _ = rw.Write(resp.responseBody)
+		writeResponse(rw, resp)
 	})
 }
 
+func writeResponse(rw http.ResponseWriter, resp *ResponseOut) {
+	for k, v := range resp.headers {
+		rw.Header().Set(k, v)
+	}
+	rw.WriteHeader(resp.statusCode)
+	if resp.stream != nil {
+		if err := resp.stream(rw); err != nil {
+			log.Printf("Unable to stream the response %s", err)
+		}
+		return
+	}
+	_, _ = rw.Write(resp.responseBody)
+}
+
 func InjectRequestScope(next EndpointHandler) EndpointHandler {
 	return func(req HttpWebRequest, ctx context.Context, config *MapPropertySource) (info *ResponseOut) {
 		if req.Header("X-Correlation-Id") != "" {
@@ -255,9 +337,13 @@ func InjectRequestScope(next EndpointHandler) EndpointHandler {
 		t := time.Now()
 		resp := next(req, ctx,config)
 		now := time.Now()
+		body := resp.responseBody
+		if resp.entity != nil {
+			body, _ = json.Marshal(resp.entity)
+		}
 		Info(ctx,
 			"Completed Req ", req.Target(), " time taken ( ", now.Sub(t), "s )",
-			" resp: ", string(resp.responseBody))
+			" resp: ", string(body))
 		return resp
 	}
 }
@@ -0,0 +1,296 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The xml representation mirrors the json one: objects become elements named
+// after their json keys, array items are <item> elements and everything sits
+// under a <response> root. Keys that are not valid element names are written
+// as <entry key="...">.
+const (
+	xmlRoot  = "response"
+	xmlItem  = "item"
+	xmlEntry = "entry"
+)
+
+var (
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+type xmlCodec struct{}
+
+func (xmlCodec) MediaType() string { return MediaTypeXML }
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := writeXMLValue(enc, dec, xmlRoot); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal reads the document into a tree and converts it to json guided by
+// the type of v, so numbers and booleans land in typed fields while strings
+// that look like numbers stay strings.
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	root, err := readXMLTree(xml.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(xmlValue(root, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func xmlStart(name string) xml.StartElement {
+	if isXMLName(name) {
+		return xml.StartElement{Name: xml.Name{Local: name}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: xmlEntry},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+	}
+}
+
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, name string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	start := xmlStart(name)
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for dec.More() {
+			child := xmlItem
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+			if err := writeXMLValue(enc, dec, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		return enc.EncodeElement(fmt.Sprint(t), start)
+	}
+}
+
+type xmlNode struct {
+	key      string
+	text     string
+	null     bool
+	children []*xmlNode
+}
+
+func readXMLTree(dec *xml.Decoder) (*xmlNode, error) {
+	var stack []*xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, Error.Text("empty xml document")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{key: t.Name.Local}
+			for _, a := range t.Attr {
+				if a.Name.Local == "key" && t.Name.Local == xmlEntry {
+					node.key = a.Value
+				}
+				if a.Name.Local == "nil" && a.Value == "true" {
+					node.null = true
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return node, nil
+			}
+		}
+	}
+}
+
+func xmlValue(n *xmlNode, t reflect.Type) interface{} {
+	if n.null {
+		return nil
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t == rawMessageType || t.Kind() == reflect.Interface {
+		return xmlGuess(n)
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return n.text
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		obj := map[string]interface{}{}
+		for _, c := range n.children {
+			if ft, ok := fields[c.key]; ok {
+				obj[c.key] = xmlValue(c, ft)
+			}
+		}
+		return obj
+	case reflect.Map:
+		obj := map[string]interface{}{}
+		for _, c := range n.children {
+			obj[c.key] = xmlValue(c, t.Elem())
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return strings.TrimSpace(n.text)
+		}
+		arr := make([]interface{}, 0, len(n.children))
+		for _, c := range n.children {
+			arr = append(arr, xmlValue(c, t.Elem()))
+		}
+		return arr
+	case reflect.Bool:
+		if text := strings.TrimSpace(n.text); text == "true" || text == "false" {
+			return json.RawMessage(text)
+		}
+		return n.text
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return json.Number(strings.TrimSpace(n.text))
+	default:
+		return n.text
+	}
+}
+
+// xmlGuess converts a node without type information, as for interface{}
+// fields.
+func xmlGuess(n *xmlNode) interface{} {
+	if n.null {
+		return nil
+	}
+	if len(n.children) == 0 {
+		text := strings.TrimSpace(n.text)
+		if text == "true" || text == "false" {
+			return text == "true"
+		}
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return json.Number(text)
+		}
+		return n.text
+	}
+
+	items := true
+	for _, c := range n.children {
+		items = items && c.key == xmlItem
+	}
+	if items {
+		arr := make([]interface{}, 0, len(n.children))
+		for _, c := range n.children {
+			arr = append(arr, xmlGuess(c))
+		}
+		return arr
+	}
+	obj := map[string]interface{}{}
+	for _, c := range n.children {
+		obj[c.key] = xmlGuess(c)
+	}
+	return obj
+}
+
+// jsonFields maps the json names of the fields of t, including promoted
+// fields of embedded structs, to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}